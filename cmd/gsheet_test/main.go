@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
 
@@ -17,10 +18,19 @@ func main() {
 	}
 
 	gsheetConfig := gsheetclient.Config{
-		AuthToken:     authToken,
-		SpreadsheetID: "1DNP3yNOA03Qd52u6HPAw4uGQLSpQac2o5JaaI-9JjGs",
+		CredentialsSource: gsheetclient.CredentialsSourceToken,
+		AuthToken:         authToken,
+		CredentialsFile:   "",
+		OAuthTokenFile:    "",
+		SpreadsheetID:     "1DNP3yNOA03Qd52u6HPAw4uGQLSpQac2o5JaaI-9JjGs",
+		RequestTimeout:    0,
+		Retry:             gsheetclient.RetryConfig{MaxAttempts: 0, InitialBackoff: 0, MaxBackoff: 0},
+		BatchWindow:       0,
+		BatchMaxSize:      0,
+		Endpoint:          "",
+		HTTPClient:        nil,
 	}
-	_, err := gsheetclient.New(&gsheetConfig)
+	_, err := gsheetclient.New(context.Background(), &gsheetConfig)
 	if err != nil {
 		slog.Error("can't connect to gsheets", slog.Any("err", err))
 		panic(err)
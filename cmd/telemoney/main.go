@@ -1,20 +1,96 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/mitrkos/telemoney/internal/app/telemoney"
 	"github.com/mitrkos/telemoney/internal/pkg/logger"
 )
 
+const usage = `usage: telemoney [command]
+
+commands:
+  serve      run the bot (default)
+  bootstrap  create the transaction sheets and their headers in the spreadsheet
+  backfill [secondary...]
+             copy the transactions from the spreadsheet to the secondary storages
+  migrate --from <storage> --to <storage>
+             copy the transactions between the storages: gsheet, sqlite;
+             an interrupted migration is resumed by running it again`
+
 func main() {
 	logger.SetLogger()
-	deps, err := telemoney.PrepareDependencies()
+	ctx := context.Background()
+
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = serve(ctx)
+	case "bootstrap":
+		err = telemoney.Bootstrap(ctx)
+	case "backfill":
+		err = telemoney.Backfill(ctx, os.Args[2:])
+	case "migrate":
+		err = migrate(ctx, os.Args[2:])
+	default:
+		exitWithUsage()
+	}
 	if err != nil {
-		panic(err)
+		slog.Error("telemoney failed", slog.Any("command", command), slog.Any("err", err))
+		os.Exit(1)
 	}
+}
 
-	t := telemoney.New(deps.Config, deps.API, deps.TransactionStorage, deps.Parser)
-	err = t.Start()
+func exitWithUsage() {
+	fmt.Fprintln(os.Stderr, usage) //nolint:forbidigo // the usage is for the user
+	os.Exit(2)                     //nolint:gomnd // the usage error code
+}
+
+func migrate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = exitWithUsage
+	from := flags.String("from", "", "the storage to copy from")
+	to := flags.String("to", "", "the storage to copy to")
+	_ = flags.Parse(args) // exits on an error
+	if *from == "" || *to == "" {
+		exitWithUsage()
+	}
+	return telemoney.Migrate(ctx, *from, *to)
+}
+
+// serve runs the bot until SIGTERM or SIGINT, then the handlers in flight and the queued writes are finished.
+// A second signal exits right away.
+func serve(ctx context.Context) error {
+	// the dependencies outlive the signal, the queued writes are flushed after the bot is stopped
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	deps, err := telemoney.PrepareDependencies(ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
+
+	stopCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go func() {
+		<-stopCtx.Done()
+		stop()
+		slog.Info("shutting down")
+	}()
+
+	t := telemoney.New(deps.Config, deps.API, deps.TransactionStorage, deps.Ledgers, deps.Outbox, deps.Reconciler, deps.Parser, deps.AccessControl,
+		deps.ChatSettings, deps.SpreadsheetLinker, deps.Removals, deps.UserNames)
+	err = t.Start(stopCtx)
+	return errors.Join(err, deps.Flush(ctx))
 }
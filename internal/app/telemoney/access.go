@@ -0,0 +1,165 @@
+package telemoney
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/access"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// authorized runs the handler only if the sender may do the action in the chat, the others get a polite refusal.
+// The names of the senders let in are remembered for the replies. The commands are checked by commandHandler.
+func (t *Telemoney) authorized(
+	action access.Action,
+	handler func(context.Context, *model.MessageToHandle),
+) func(context.Context, *model.MessageToHandle) {
+	return func(ctx context.Context, msg *model.MessageToHandle) {
+		err := t.access.Check(msg.ChatID, msg.UserID, action)
+		if err != nil {
+			t.refuse(msg, err)
+			return
+		}
+		t.rememberUserName(msg)
+		handler(ctx, msg)
+	}
+}
+
+// refuse tells the sender why the bot doesn't do what was asked and how to get access, once per sender and chat
+// since the start, so the bot doesn't answer every message of a group member it doesn't know.
+func (t *Telemoney) refuse(msg *model.MessageToHandle, err error) {
+	slog.Info("access is refused", slog.Any("err", err), slog.String("chatID", msg.ChatID), slog.String("userID", msg.UserID))
+	if !t.firstRefusal(msg) {
+		return
+	}
+
+	text := "Sorry, this bot is private. Send /join to ask the owner for access."
+	switch {
+	case errors.Is(err, access.ErrChatNotAllowed):
+		text = "Sorry, the bot doesn't work in this chat yet. Send /join to ask the owner to allow it."
+	case errors.Is(err, access.ErrActionForbidden):
+		text = "Sorry, you can only look here: " + t.readOnlyCommands() + "."
+	}
+	_, _ = t.api.SendMessage(&model.MessageToSend{
+		ChatID: msg.ChatID,
+		Text:   text,
+	})
+}
+
+// chatUser is a user in a chat, the private chat of the user has the id of the user.
+type chatUser struct {
+	chatID string
+	userID string
+}
+
+func (t *Telemoney) firstRefusal(msg *model.MessageToHandle) bool {
+	t.refusedMu.Lock()
+	defer t.refusedMu.Unlock()
+	key := chatUser{chatID: msg.ChatID, userID: msg.UserID}
+	if t.refused[key] {
+		return false
+	}
+	t.refused[key] = true
+	return true
+}
+
+// handleJoinCommand sends the request of a newcomer to the owners, one of them approves or denies it.
+// A newcomer asks once in access.RequestRepeatInterval.
+func (t *Telemoney) handleJoinCommand(_ context.Context, call *model.CommandToHandle) {
+	msg := call.Message
+	err := t.access.Check(msg.ChatID, msg.UserID, access.ActionRead)
+	if err == nil {
+		_, _ = t.api.SendMessage(&model.MessageToSend{
+			ChatID: msg.ChatID,
+			Text:   "You have access here already.",
+		})
+		return
+	}
+
+	added := t.access.AddRequest(&access.Request{
+		UserID:   msg.UserID,
+		UserName: msg.UserName,
+		ChatID:   msg.ChatID,
+	})
+	if !added {
+		t.reply(msg, "Your request is sent already, please wait for the owner.")
+		return
+	}
+	where := "in the private chat"
+	if msg.ChatID != msg.UserID {
+		where = "in the chat " + msg.ChatID
+	}
+	for _, ownerID := range t.access.Owners() {
+		_, _ = t.api.SendMessage(&model.MessageToSend{
+			ChatID: ownerID,
+			Text: fmt.Sprintf("%s (user %s) asks for access %s.\n"+
+				"/approve %s - let them write, /approve %s %s - only look, /deny %s - refuse.",
+				msg.UserName, msg.UserID, where, msg.UserID, msg.UserID, access.RoleReadOnly, msg.UserID),
+		})
+	}
+	_, _ = t.api.SendMessage(&model.MessageToSend{
+		ChatID: msg.ChatID,
+		Text:   "Your request is sent to the owner, you'll get a message here once it is decided.",
+	})
+}
+
+// handleApproveCommand lets a newcomer in, like "/approve 42" or "/approve 42 read-only".
+// The chat the newcomer asked in is allowed too.
+func (t *Telemoney) handleApproveCommand(_ context.Context, call *model.CommandToHandle) {
+	msg := call.Message
+	userID, role := call.Args[0], ""
+	if len(call.Args) == 2 {
+		role = call.Args[1]
+	}
+	parsedRole, err := access.ParseRole(role)
+	if err != nil {
+		t.reply(msg, err.Error())
+		return
+	}
+
+	request := t.access.TakeRequest(userID)
+	chatID := ""
+	if request != nil {
+		chatID = request.ChatID
+	}
+	err = t.access.Grant(userID, parsedRole, chatID)
+	if err != nil {
+		slog.Error("can't save the access grant", slog.Any("err", err), slog.String("userID", userID))
+		t.markMessageHandledFailure(msg)
+		return
+	}
+	slog.Info("access is granted", slog.String("userID", userID), slog.String("role", string(parsedRole)), slog.String("chatID", chatID),
+		slog.String("by", msg.UserID))
+
+	if request != nil {
+		_, _ = t.api.SendMessage(&model.MessageToSend{
+			ChatID: request.ChatID,
+			Text:   fmt.Sprintf("Welcome, %s! You are in as %s.", request.UserName, parsedRole),
+		})
+	}
+	t.reply(msg, fmt.Sprintf("User %s is approved as %s.", userID, parsedRole))
+}
+
+// handleDenyCommand refuses the request of a newcomer, like "/deny 42".
+func (t *Telemoney) handleDenyCommand(_ context.Context, call *model.CommandToHandle) {
+	msg, userID := call.Message, call.Args[0]
+	request := t.access.TakeRequest(userID)
+	if request == nil {
+		t.reply(msg, "No request from user "+userID+".")
+		return
+	}
+	_, _ = t.api.SendMessage(&model.MessageToSend{
+		ChatID: request.ChatID,
+		Text:   "Sorry, the owner has declined your request.",
+	})
+	t.reply(msg, "The request of user "+userID+" is declined.")
+}
+
+func (t *Telemoney) reply(msg *model.MessageToHandle, text string) {
+	_, _ = t.api.SendMessage(&model.MessageToSend{
+		ChatID: msg.ChatID,
+		Text:   text,
+	})
+}
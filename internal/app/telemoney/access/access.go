@@ -0,0 +1,246 @@
+// Package access decides who may use the bot and in which chats: the users have roles, the chats are allow-listed,
+// the owners let the newcomers in.
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/utils"
+)
+
+// RequestRepeatInterval is how long a newcomer waits before asking again, even if the request was declined.
+const RequestRepeatInterval = 24 * time.Hour
+
+var (
+	ErrUnknownUser     = errors.New("unknown user")
+	ErrChatNotAllowed  = errors.New("chat is not allowed")
+	ErrActionForbidden = errors.New("action is forbidden for the role")
+)
+
+type Role string
+
+const (
+	RoleOwner    Role = "owner"     // everything, including letting the newcomers in
+	RoleMember   Role = "member"    // reads and writes the transactions
+	RoleReadOnly Role = "read-only" // only reads
+)
+
+// ParseRole parses the role a newcomer can be given, "" is RoleMember. Owners are set only in the config.
+func ParseRole(s string) (Role, error) {
+	switch Role(s) {
+	case "", RoleMember:
+		return RoleMember, nil
+	case RoleReadOnly:
+		return RoleReadOnly, nil
+	}
+	return "", fmt.Errorf("bad role %q, expected %q or %q", s, RoleMember, RoleReadOnly)
+}
+
+type Action string
+
+const (
+	ActionRead   Action = "read"   // e.g. /summary, /history
+	ActionWrite  Action = "write"  // adding, editing and removing the transactions
+	ActionManage Action = "manage" // approving the newcomers
+)
+
+func (r Role) allows(action Action) bool {
+	switch r {
+	case RoleOwner:
+		return true
+	case RoleMember:
+		return action == ActionRead || action == ActionWrite
+	case RoleReadOnly:
+		return action == ActionRead
+	}
+	return false
+}
+
+type Config struct {
+	Enabled    bool     // false - anyone may do anything anywhere
+	Owners     []string // user ids
+	Members    []string // user ids
+	ReadOnly   []string // user ids
+	Chats      []string // the group chats allowed, the private chats of the known users are always allowed
+	GrantsPath string   // the file keeping the users and chats approved by the owners
+}
+
+// Request is a newcomer asking for access, it waits for an owner in memory, so a restart forgets it.
+type Request struct {
+	UserID   string
+	UserName string
+	ChatID   string // where it was asked, the chat is allowed on the approval too
+}
+
+// Control checks the users against the config and the approved grants.
+type Control struct {
+	config *Config
+
+	mu       sync.Mutex
+	grants   *grants
+	requests map[string]*Request  // user id -> the last request
+	askedAt  map[string]time.Time // user id -> when the last request was sent
+}
+
+// grants are the approvals, they are kept in the file as json.
+type grants struct {
+	Users map[string]Role `json:"users"`
+	Chats []string        `json:"chats"`
+}
+
+func New(config *Config) (*Control, error) {
+	grants, err := readGrants(config.GrantsPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Control{
+		config:   config,
+		mu:       sync.Mutex{},
+		grants:   grants,
+		requests: make(map[string]*Request),
+		askedAt:  make(map[string]time.Time),
+	}, nil
+}
+
+func (c *Control) Enabled() bool {
+	return c.config.Enabled
+}
+
+// Owners returns the user ids of the owners, their private chats get the requests.
+func (c *Control) Owners() []string {
+	return c.config.Owners
+}
+
+// Role returns the role of the user, "" if the user is unknown. The config wins over the grants.
+func (c *Control) Role(userID string) Role {
+	switch {
+	case slices.Contains(c.config.Owners, userID):
+		return RoleOwner
+	case slices.Contains(c.config.Members, userID):
+		return RoleMember
+	case slices.Contains(c.config.ReadOnly, userID):
+		return RoleReadOnly
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.grants.Users[userID]
+}
+
+// Check returns nil if the user may do the action in the chat, otherwise one of the errors above.
+// A private chat has the id of the user.
+func (c *Control) Check(chatID string, userID string, action Action) error {
+	if !c.config.Enabled {
+		return nil
+	}
+
+	role := c.Role(userID)
+	if role == "" {
+		return ErrUnknownUser
+	}
+	if !c.isChatAllowed(chatID, userID) {
+		return ErrChatNotAllowed
+	}
+	if !role.allows(action) {
+		return fmt.Errorf("%w: %s can't %s", ErrActionForbidden, role, action)
+	}
+	return nil
+}
+
+func (c *Control) isChatAllowed(chatID string, userID string) bool {
+	if chatID == userID || slices.Contains(c.config.Chats, chatID) {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.grants.Chats, chatID)
+}
+
+// AddRequest keeps the request until an owner takes it, a newer request of the user replaces it. It returns false
+// and keeps what it has if the user has asked less than RequestRepeatInterval ago.
+func (c *Control) AddRequest(request *Request) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if askedAt, ok := c.askedAt[request.UserID]; ok && time.Since(askedAt) < RequestRepeatInterval {
+		return false
+	}
+	c.requests[request.UserID] = request
+	c.askedAt[request.UserID] = time.Now()
+	return true
+}
+
+// TakeRequest returns the request of the user and forgets it, nil if there is none.
+func (c *Control) TakeRequest(userID string) *Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	request := c.requests[userID]
+	delete(c.requests, userID)
+	return request
+}
+
+// Grant gives the role to the user and allows the chat, "" or the private chat of the user allows no chat.
+// The grants are saved before they take effect.
+func (c *Control) Grant(userID string, role Role, chatID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := &grants{
+		Users: make(map[string]Role, len(c.grants.Users)+1),
+		Chats: c.grants.Chats,
+	}
+	for id, r := range c.grants.Users {
+		updated.Users[id] = r
+	}
+	updated.Users[userID] = role
+	if chatID != "" && chatID != userID && !slices.Contains(updated.Chats, chatID) {
+		updated.Chats = append(append([]string(nil), updated.Chats...), chatID)
+	}
+
+	err := writeGrants(c.config.GrantsPath, updated)
+	if err != nil {
+		return err
+	}
+	c.grants = updated
+	return nil
+}
+
+// readGrants reads the grants file, a missing file is no grants yet.
+func readGrants(path string) (*grants, error) {
+	result := &grants{Users: make(map[string]Role), Chats: nil}
+	if path == "" {
+		return result, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, fmt.Errorf("bad access grants file %s: %w", path, err)
+	}
+	if result.Users == nil {
+		result.Users = make(map[string]Role)
+	}
+	return result, nil
+}
+
+func writeGrants(path string, g *grants) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data)
+}
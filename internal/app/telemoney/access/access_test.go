@@ -0,0 +1,62 @@
+package access_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/access"
+)
+
+func makeConfig(t *testing.T) *access.Config {
+	return &access.Config{
+		Enabled:    true,
+		Owners:     []string{"1"},
+		Members:    []string{"2"},
+		ReadOnly:   []string{"3"},
+		Chats:      []string{"-100"},
+		GrantsPath: filepath.Join(t.TempDir(), "access.json"),
+	}
+}
+
+func TestControl_Check(t *testing.T) {
+	control, err := access.New(makeConfig(t))
+	require.NoError(t, err)
+
+	require.NoError(t, control.Check("1", "1", access.ActionManage))
+	require.NoError(t, control.Check("-100", "2", access.ActionWrite))
+	require.ErrorIs(t, control.Check("-100", "2", access.ActionManage), access.ErrActionForbidden)
+	require.NoError(t, control.Check("3", "3", access.ActionRead))
+	require.ErrorIs(t, control.Check("3", "3", access.ActionWrite), access.ErrActionForbidden)
+	require.ErrorIs(t, control.Check("-200", "2", access.ActionRead), access.ErrChatNotAllowed)
+	require.ErrorIs(t, control.Check("4", "4", access.ActionRead), access.ErrUnknownUser)
+
+	disabled := makeConfig(t)
+	disabled.Enabled = false
+	control, err = access.New(disabled)
+	require.NoError(t, err)
+	require.NoError(t, control.Check("4", "4", access.ActionManage))
+}
+
+func TestControl_GrantIsKept(t *testing.T) {
+	config := makeConfig(t)
+	control, err := access.New(config)
+	require.NoError(t, err)
+
+	require.True(t, control.AddRequest(&access.Request{UserID: "4", UserName: "@newcomer", ChatID: "-200"}))
+	require.False(t, control.AddRequest(&access.Request{UserID: "4", UserName: "@newcomer", ChatID: "-300"}), "the newcomer asked already")
+	request := control.TakeRequest("4")
+	require.Equal(t, &access.Request{UserID: "4", UserName: "@newcomer", ChatID: "-200"}, request)
+	require.Nil(t, control.TakeRequest("4"))
+
+	require.NoError(t, control.Grant("4", access.RoleReadOnly, request.ChatID))
+	require.NoError(t, control.Check("-200", "4", access.ActionRead))
+	require.ErrorIs(t, control.Check("-200", "4", access.ActionWrite), access.ErrActionForbidden)
+
+	// the grants survive a restart
+	control, err = access.New(config)
+	require.NoError(t, err)
+	require.Equal(t, access.RoleReadOnly, control.Role("4"))
+	require.NoError(t, control.Check("-200", "2", access.ActionWrite))
+}
@@ -1,6 +1,7 @@
 package apihandler
 
 import (
+	"context"
 	"errors"
 
 	"github.com/mitrkos/telemoney/internal/model"
@@ -10,16 +11,19 @@ var ErrAPIOperationFailed = errors.New("api operation failed")
 
 type MessageHandler interface {
 	// inputs
-	SetUpdateHandlerStartCommand(func())
-	SetUpdateHandlerMessage(func(*model.MessageToHandle))
-	SetUpdateHandlerEditedMessage(func(*model.MessageToHandle))
-	SetUpdateHandlerRemoveMessageCommand(func(*model.MessageToHandle))
+	SetUpdateHandlerCommand(name string, handler func(context.Context, *model.CommandToHandle))
+	SetUpdateHandlerMessage(func(context.Context, *model.MessageToHandle))
+	SetUpdateHandlerEditedMessage(func(context.Context, *model.MessageToHandle))
 
 	// outputs
-	SendMessage(*model.MessageToSend) error
+	// SendMessage posts the message and returns its id
+	SendMessage(*model.MessageToSend) (string, error)
+	SetCommands(scope model.CommandScope, languageCode string, commands []*model.Command) error
 	RemoveMessage(*model.MessageToInteract) error
 	MarkMessageProcessedOK(*model.MessageToInteract) error
 	MarkMessageProcessedFail(*model.MessageToInteract) error
+	MarkMessageProcessedQueued(*model.MessageToInteract) error
 
-	ListenToUpdates() error
+	// ListenToUpdates blocks until ctx is done, handlers get contexts derived from ctx
+	ListenToUpdates(ctx context.Context) error
 }
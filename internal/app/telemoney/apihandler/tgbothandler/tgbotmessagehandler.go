@@ -1,6 +1,8 @@
 package tgbothandler
 
 import (
+	"context"
+
 	"github.com/mitrkos/telemoney/internal/app/telemoney/apihandler"
 	"github.com/mitrkos/telemoney/internal/model"
 	"github.com/mitrkos/telemoney/internal/pkg/tgbot"
@@ -16,28 +18,32 @@ func New(tgbot *tgbot.TgBot) *TgBotMessageHandler {
 	}
 }
 
-func (tgh *TgBotMessageHandler) ListenToUpdates() error {
-	return tgh.tgbot.ListenToUpdates()
-}
-
-func (tgh *TgBotMessageHandler) SetUpdateHandlerStartCommand(handler func()) {
-	tgh.tgbot.SetUpdateHandlerStartCommand(handler)
+func (tgh *TgBotMessageHandler) ListenToUpdates(ctx context.Context) error {
+	return tgh.tgbot.ListenToUpdates(ctx)
 }
 
-func (tgh *TgBotMessageHandler) SetUpdateHandlerRemoveMessageCommand(handler func(*model.MessageToHandle)) {
-	tgh.tgbot.SetUpdateHandlerRemoveMessageCommand(handler)
+func (tgh *TgBotMessageHandler) SetUpdateHandlerCommand(name string, handler func(context.Context, *model.CommandToHandle)) {
+	tgh.tgbot.SetUpdateHandlerCommand(name, handler)
 }
 
-func (tgh *TgBotMessageHandler) SetUpdateHandlerMessage(handler func(*model.MessageToHandle)) {
+func (tgh *TgBotMessageHandler) SetUpdateHandlerMessage(handler func(context.Context, *model.MessageToHandle)) {
 	tgh.tgbot.SetUpdateHandlerMessage(handler)
 }
 
-func (tgh *TgBotMessageHandler) SetUpdateHandlerEditedMessage(handler func(*model.MessageToHandle)) {
+func (tgh *TgBotMessageHandler) SetUpdateHandlerEditedMessage(handler func(context.Context, *model.MessageToHandle)) {
 	tgh.tgbot.SetUpdateHandlerEditedMessage(handler)
 }
 
-func (tgh *TgBotMessageHandler) SendMessage(msg *model.MessageToSend) error {
-	err := tgh.tgbot.SendMessage(msg)
+func (tgh *TgBotMessageHandler) SendMessage(msg *model.MessageToSend) (string, error) {
+	messageID, err := tgh.tgbot.SendMessage(msg)
+	if err != nil {
+		return "", apihandler.ErrAPIOperationFailed
+	}
+	return messageID, nil
+}
+
+func (tgh *TgBotMessageHandler) SetCommands(scope model.CommandScope, languageCode string, commands []*model.Command) error {
+	err := tgh.tgbot.SetCommands(scope, languageCode, commands)
 	if err != nil {
 		return apihandler.ErrAPIOperationFailed
 	}
@@ -73,3 +79,14 @@ func (tgh *TgBotMessageHandler) MarkMessageProcessedFail(msg *model.MessageToInt
 	}
 	return nil
 }
+
+func (tgh *TgBotMessageHandler) MarkMessageProcessedQueued(msg *model.MessageToInteract) error {
+	err := tgh.tgbot.SetMessageReaction(&tgbot.ReactionForMessage{
+		Msg:      msg,
+		Reaction: tgbot.MakeReactionWritingEmoji(),
+	})
+	if err != nil {
+		return apihandler.ErrAPIOperationFailed
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package audit describes the trail of the transaction writes: what a transaction was before and after, when and why.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+type Operation string
+
+const (
+	OperationInsert  Operation = "insert"
+	OperationUpdate  Operation = "update"
+	OperationDelete  Operation = "delete"
+	OperationRestore Operation = "restore"
+)
+
+// Trigger is what caused the write.
+type Trigger string
+
+const (
+	TriggerMessage Trigger = "message"
+	TriggerEdit    Trigger = "edit"
+	TriggerRemove  Trigger = "remove"
+	TriggerUndo    Trigger = "undo"
+	TriggerSync    Trigger = "sync" // a change made outside the bot, e.g. in the spreadsheet by hand
+)
+
+type Record struct {
+	At        time.Time
+	MessageID string
+	Operation Operation
+	Trigger   Trigger
+	ChatID    string             // the chat of the transaction
+	UserID    string             // who made the change, "" for TriggerSync
+	Before    *model.Transaction // nil for OperationInsert or when the previous state is unknown
+	After     *model.Transaction // nil for OperationDelete
+}
+
+// Log keeps the records, it is append only.
+type Log interface {
+	Append(context.Context, *Record) error
+	// ListByMessageID returns the records of the transaction with the chat id and the message id from the oldest,
+	// the records without the chat id are included
+	ListByMessageID(ctx context.Context, chatID string, messageID string) ([]*Record, error)
+}
+
+// LastState returns the transaction as the last record left it, nil if there are no records or it was deleted.
+func LastState(records []*Record) *model.Transaction {
+	if len(records) == 0 {
+		return nil
+	}
+	return records[len(records)-1].After
+}
@@ -0,0 +1,93 @@
+package telemoney
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/summary"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+const balanceAllTime = "all"
+
+// handleBalanceCommand replies with what each member of the chat paid and who pays whom to settle up,
+// like "/balance" for this month, "/balance 2026-09" or "/balance all".
+func (t *Telemoney) handleBalanceCommand(ctx context.Context, call *model.CommandToHandle) {
+	msg := call.Message
+	settings := t.chatSettings.Get(msg.ChatID)
+	location := settings.LocationOr(t.config.Location)
+	period := summary.MonthOf(time.Now().Unix(), location)
+	if len(call.Args) > 0 {
+		period = call.Args[0]
+	}
+	if _, err := time.Parse("2006-01", period); err != nil && period != balanceAllTime {
+		t.reply(msg, "Usage: /balance [2006-01|all]")
+		return
+	}
+
+	ctx, cancel := t.withHandlerTimeout(ctx)
+	defer cancel()
+
+	transactions, err := t.ledgers.LedgerOf(msg.ChatID).List(ctx)
+	if err != nil {
+		slog.Error("can't list transactions for the balance", slog.Any("err", err))
+		t.markMessageHandledFailure(msg)
+		return
+	}
+
+	totals := summary.MemberTotals(chatTransactions(transactions, msg.ChatID), func(transaction *model.Transaction) bool {
+		return period == balanceAllTime || summary.MonthOf(transaction.CreatedAt, location) == period
+	})
+	t.reply(msg, t.formatBalance(period, totals, settings))
+}
+
+// formatBalance makes the reply to /balance: the total, the members with their share and the transfers.
+func (t *Telemoney) formatBalance(period string, totals []*summary.MemberTotal, settings *chatsettings.Settings) string {
+	if len(totals) == 0 {
+		return period + ": no transactions with a known sender"
+	}
+
+	sum := 0.0
+	for _, total := range totals {
+		sum += total.Amount
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s: %s\n", period, settings.FormatAmount(sum))
+	for _, total := range totals {
+		fmt.Fprintf(&text, "%s: %s (%d)\n", t.userName(total.UserID), settings.FormatAmount(total.Amount), total.Count)
+	}
+	transfers := summary.SettleUp(totals)
+	if len(transfers) == 0 {
+		text.WriteString("everyone is even")
+		return text.String()
+	}
+	fmt.Fprintf(&text, "the share of each: %s\n", settings.FormatAmount(sum/float64(len(totals))))
+	for _, transfer := range transfers {
+		fmt.Fprintf(&text, "%s → %s: %s\n", t.userName(transfer.From), t.userName(transfer.To), settings.FormatAmount(transfer.Amount))
+	}
+	return strings.TrimSuffix(text.String(), "\n")
+}
+
+// rememberUserName keeps the name of the sender to show instead of the id.
+func (t *Telemoney) rememberUserName(msg *model.MessageToHandle) {
+	if msg.UserID == "" || msg.UserName == "" {
+		return
+	}
+	err := t.userNames.Remember(msg.UserID, msg.UserName)
+	if err != nil {
+		slog.Warn("can't save the user name", slog.String("userID", msg.UserID), slog.Any("err", err))
+	}
+}
+
+// userName returns the name of the user last seen, "user <id>" if the user was never seen.
+func (t *Telemoney) userName(userID string) string {
+	if name, ok := t.userNames.Name(userID); ok {
+		return name
+	}
+	return "user " + userID
+}
@@ -0,0 +1,216 @@
+// Package chatsettings keeps what each chat picked on /start: the currency, the timezone, the locale and
+// its own spreadsheet.
+package chatsettings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mitrkos/telemoney/internal/utils"
+)
+
+var ErrBadSetting = errors.New("bad setting")
+
+type Settings struct {
+	Currency      string `json:"currency,omitempty"`       // e.g. "EUR" or "€", "" - not shown
+	Timezone      string `json:"timezone,omitempty"`       // IANA name, "" - the one of the config
+	Locale        string `json:"locale,omitempty"`         // e.g. "en" or "de-DE", "" - en
+	SpreadsheetID string `json:"spreadsheet_id,omitempty"` // "" - the shared spreadsheet
+}
+
+type Config struct {
+	Path string // the file the settings are kept in, "" - in memory only
+}
+
+type Store struct {
+	config *Config
+
+	mu    sync.Mutex
+	chats map[string]*Settings // chat id -> its settings
+}
+
+func New(config *Config) (*Store, error) {
+	chats, err := readChats(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		config: config,
+		mu:     sync.Mutex{},
+		chats:  chats,
+	}, nil
+}
+
+// Get returns a copy of the settings of the chat, the empty settings if the chat has none.
+func (s *Store) Get(chatID string) *Settings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings, ok := s.chats[chatID]
+	if !ok {
+		return &Settings{Currency: "", Timezone: "", Locale: "", SpreadsheetID: ""}
+	}
+	result := *settings
+	return &result
+}
+
+// Set replaces the settings of the chat, they are saved before they take effect.
+func (s *Store) Set(chatID string, settings *Settings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := make(map[string]*Settings, len(s.chats)+1)
+	for id, chatSettings := range s.chats {
+		updated[id] = chatSettings
+	}
+	stored := *settings
+	updated[chatID] = &stored
+
+	err := writeChats(s.config.Path, updated)
+	if err != nil {
+		return err
+	}
+	s.chats = updated
+	return nil
+}
+
+// Spreadsheets returns the chats with their own spreadsheet: chat id -> spreadsheet id.
+func (s *Store) Spreadsheets() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]string)
+	for chatID, settings := range s.chats {
+		if settings.SpreadsheetID != "" {
+			result[chatID] = settings.SpreadsheetID
+		}
+	}
+	return result
+}
+
+// LocationOr returns the location of the timezone, fallback if it is not set.
+func (s *Settings) LocationOr(fallback *time.Location) *time.Location {
+	if s.Timezone == "" {
+		return fallback
+	}
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return fallback
+	}
+	return location
+}
+
+// commaLanguages write the decimal part after a comma, the others after a dot.
+var commaLanguages = map[string]bool{
+	"be": true, "cs": true, "da": true, "de": true, "es": true, "fi": true, "fr": true, "id": true, "it": true,
+	"nl": true, "no": true, "pl": true, "pt": true, "ru": true, "sk": true, "sv": true, "tr": true, "uk": true,
+}
+
+// FormatAmount makes the amount with cents as the locale writes it, with the currency if set, like "9,50 EUR".
+func (s *Settings) FormatAmount(amount float64) string {
+	text := strconv.FormatFloat(amount, 'f', 2, 64) //nolint:gomnd // cents
+	language, _, _ := strings.Cut(s.Locale, "-")
+	if commaLanguages[language] {
+		text = strings.Replace(text, ".", ",", 1)
+	}
+	if s.Currency != "" {
+		text += " " + s.Currency
+	}
+	return text
+}
+
+// maxCurrencyLength fits the ISO codes like "EUR" and the signs like "€".
+const maxCurrencyLength = 3
+
+// ParseCurrency checks the currency is a code or a sign, the code is upper cased.
+func ParseCurrency(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.ContainsAny(text, " \t") || utf8.RuneCountInString(text) > maxCurrencyLength {
+		return "", fmt.Errorf("%w: expected a currency code like EUR or a sign like €", ErrBadSetting)
+	}
+	return strings.ToUpper(text), nil
+}
+
+// ParseTimezone checks the timezone is an IANA name, like "Europe/Berlin".
+func ParseTimezone(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || text == "Local" {
+		return "", fmt.Errorf("%w: expected a timezone name like Europe/Berlin", ErrBadSetting)
+	}
+	_, err := time.LoadLocation(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: expected a timezone name like Europe/Berlin", ErrBadSetting)
+	}
+	return text, nil
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// ParseLocale checks the locale is a language with an optional region, like "en" or "de-DE".
+func ParseLocale(text string) (string, error) {
+	language, region, hasRegion := strings.Cut(strings.TrimSpace(text), "-")
+	locale := strings.ToLower(language)
+	if hasRegion {
+		locale += "-" + strings.ToUpper(region)
+	}
+	if !localePattern.MatchString(locale) {
+		return "", fmt.Errorf("%w: expected a locale like en or de-DE", ErrBadSetting)
+	}
+	return locale, nil
+}
+
+var (
+	spreadsheetURLPattern = regexp.MustCompile(`docs\.google\.com/spreadsheets/d/([A-Za-z0-9_-]+)`)
+	spreadsheetIDPattern  = regexp.MustCompile(`^[A-Za-z0-9_-]{20,}$`)
+)
+
+// ParseSpreadsheetID takes the id out of a spreadsheet link, a bare id is taken as is.
+func ParseSpreadsheetID(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if match := spreadsheetURLPattern.FindStringSubmatch(text); match != nil {
+		return match[1], nil
+	}
+	if spreadsheetIDPattern.MatchString(text) {
+		return text, nil
+	}
+	return "", fmt.Errorf("%w: expected a spreadsheet link like https://docs.google.com/spreadsheets/d/...", ErrBadSetting)
+}
+
+// readChats reads the settings file, a missing file is no settings yet.
+func readChats(path string) (map[string]*Settings, error) {
+	chats := make(map[string]*Settings)
+	if path == "" {
+		return chats, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return chats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &chats)
+	if err != nil {
+		return nil, fmt.Errorf("bad chat settings file %s: %w", path, err)
+	}
+	return chats, nil
+}
+
+func writeChats(path string, chats map[string]*Settings) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(chats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data)
+}
@@ -0,0 +1,60 @@
+package chatsettings_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+)
+
+func TestStore_SettingsAreKept(t *testing.T) {
+	config := &chatsettings.Config{Path: filepath.Join(t.TempDir(), "chat_settings.json")}
+	store, err := chatsettings.New(config)
+	require.NoError(t, err)
+
+	settings := store.Get("-100")
+	require.Equal(t, &chatsettings.Settings{Currency: "", Timezone: "", Locale: "", SpreadsheetID: ""}, settings)
+	settings.Currency, settings.SpreadsheetID = "EUR", "sheet-1"
+	require.Empty(t, store.Get("-100").Currency, "the settings are changed only by Set")
+	require.NoError(t, store.Set("-100", settings))
+
+	reopened, err := chatsettings.New(config)
+	require.NoError(t, err)
+	require.Equal(t, "EUR", reopened.Get("-100").Currency)
+	require.Equal(t, map[string]string{"-100": "sheet-1"}, reopened.Spreadsheets())
+}
+
+func TestSettings_FormatAmount(t *testing.T) {
+	settings := &chatsettings.Settings{Currency: "", Timezone: "", Locale: "", SpreadsheetID: ""}
+	require.Equal(t, "9.50", settings.FormatAmount(9.5))
+
+	settings.Currency, settings.Locale = "EUR", "de-DE"
+	require.Equal(t, "1234,50 EUR", settings.FormatAmount(1234.5))
+}
+
+func TestParse(t *testing.T) {
+	currency, err := chatsettings.ParseCurrency(" eur ")
+	require.NoError(t, err)
+	require.Equal(t, "EUR", currency)
+	_, err = chatsettings.ParseCurrency("euro dollars")
+	require.ErrorIs(t, err, chatsettings.ErrBadSetting)
+
+	_, err = chatsettings.ParseTimezone("Europe/Berlin")
+	require.NoError(t, err)
+	_, err = chatsettings.ParseTimezone("Mars/Olympus")
+	require.ErrorIs(t, err, chatsettings.ErrBadSetting)
+
+	locale, err := chatsettings.ParseLocale("DE-de")
+	require.NoError(t, err)
+	require.Equal(t, "de-DE", locale)
+	_, err = chatsettings.ParseLocale("german")
+	require.ErrorIs(t, err, chatsettings.ErrBadSetting)
+
+	spreadsheetID, err := chatsettings.ParseSpreadsheetID("https://docs.google.com/spreadsheets/d/1AbC_d-EfGh/edit#gid=0")
+	require.NoError(t, err)
+	require.Equal(t, "1AbC_d-EfGh", spreadsheetID)
+	_, err = chatsettings.ParseSpreadsheetID("my sheet")
+	require.ErrorIs(t, err, chatsettings.ErrBadSetting)
+}
@@ -0,0 +1,237 @@
+package telemoney
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/access"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// anyArgs is the command.maxArgs of the commands taking any arguments.
+const anyArgs = -1
+
+// command is an entry of the command registry: how the command is called, who may call it, its handler
+// and its entry in the menus. A new command needs only an entry here.
+type command struct {
+	name          string
+	usage         string // the arguments, like "<user id> [member|read-only]", shown on a call with a wrong count
+	minArgs       int
+	maxArgs       int           // anyArgs - no limit
+	replyRequired bool          // the command is about the message it replies to
+	action        access.Action // the sender is checked for, "" - the handler checks the sender itself
+	// the menu entry: the descriptions are by the language code, "" - the default one in English,
+	// every other language gets its own menu
+	descriptions map[string]string
+	scopes       []model.CommandScope
+	handle       func(context.Context, *model.CommandToHandle) // nil - the menu entry only
+}
+
+var (
+	bothScopes  = []model.CommandScope{model.CommandScopePrivate, model.CommandScopeGroup}
+	privateOnly = []model.CommandScope{model.CommandScopePrivate}
+	groupOnly   = []model.CommandScope{model.CommandScopeGroup}
+)
+
+// makeCommands makes the registry in the order of the menus.
+func (t *Telemoney) makeCommands() []*command {
+	return []*command{
+		{
+			name: "start", usage: "", minArgs: 0, maxArgs: anyArgs, replyRequired: false, action: "",
+			descriptions: map[string]string{
+				"":   "Set up the chat",
+				"ru": "Настроить чат",
+			},
+			scopes: bothScopes, handle: t.handleStartCommand,
+		},
+		{
+			// the answer to a /start question, it has no menu entry
+			name: "skip", usage: "", minArgs: 0, maxArgs: 0, replyRequired: false, action: access.ActionWrite,
+			descriptions: nil, scopes: nil, handle: t.handleSkipCommand,
+		},
+		{
+			name: "help", usage: "", minArgs: 0, maxArgs: 0, replyRequired: false, action: access.ActionRead,
+			descriptions: map[string]string{
+				"":   "How to send the expenses and the commands",
+				"ru": "Как записывать расходы и команды",
+			},
+			scopes: bothScopes, handle: t.handleHelpCommand,
+		},
+		{
+			// tgbot takes the text after /add as a message, see tgbot.Config.GroupPrefixRequired
+			name: "add", usage: "", minArgs: 0, maxArgs: 0, replyRequired: false, action: "",
+			descriptions: map[string]string{
+				"":   "Add an expense, like /add 9.5 lunch",
+				"ru": "Добавить расход, например /add 9.5 обед",
+			},
+			scopes: groupOnly, handle: nil,
+		},
+		{
+			name: "summary", usage: "", minArgs: 0, maxArgs: 0, replyRequired: false, action: access.ActionRead,
+			descriptions: map[string]string{
+				"":   "The month by category",
+				"ru": "Месяц по категориям",
+			},
+			scopes: bothScopes, handle: t.handleSummaryCommand,
+		},
+		{
+			name: "balance", usage: "[2006-01|all]", minArgs: 0, maxArgs: 1, replyRequired: false, action: access.ActionRead,
+			descriptions: map[string]string{
+				"":   "Who paid what and who pays whom to settle up",
+				"ru": "Кто сколько заплатил и кто кому должен",
+			},
+			scopes: bothScopes, handle: t.handleBalanceCommand,
+		},
+		{
+			name: "history", usage: "", minArgs: 0, maxArgs: 0, replyRequired: true, action: access.ActionRead,
+			descriptions: map[string]string{
+				"":   "In reply to an expense: its changes",
+				"ru": "В ответ на расход: его изменения",
+			},
+			scopes: bothScopes, handle: t.handleHistoryCommand,
+		},
+		{
+			name: "remove", usage: "", minArgs: 0, maxArgs: 0, replyRequired: true, action: access.ActionWrite,
+			descriptions: map[string]string{
+				"":   "In reply to an expense: delete it",
+				"ru": "В ответ на расход: удалить его",
+			},
+			scopes: bothScopes, handle: t.handleRemoveMessageCommand,
+		},
+		{
+			name: "undo", usage: "", minArgs: 0, maxArgs: 0, replyRequired: false, action: access.ActionWrite,
+			descriptions: map[string]string{
+				"":   "Bring the last deleted expense back",
+				"ru": "Вернуть последний удаленный расход",
+			},
+			scopes: bothScopes, handle: t.handleUndoCommand,
+		},
+		{
+			// the newcomers have no access yet
+			name: "join", usage: "", minArgs: 0, maxArgs: 0, replyRequired: false, action: "",
+			descriptions: map[string]string{
+				"":   "Ask the owner for access",
+				"ru": "Попросить доступ у владельца",
+			},
+			scopes: bothScopes, handle: t.handleJoinCommand,
+		},
+		{
+			name: "approve", usage: "<user id> [member|read-only]", minArgs: 1, maxArgs: 2, replyRequired: false,
+			action: access.ActionManage,
+			descriptions: map[string]string{
+				"":   "Let a user in: /approve <user id> [member|read-only]",
+				"ru": "Впустить пользователя: /approve <id> [member|read-only]",
+			},
+			scopes: privateOnly, handle: t.handleApproveCommand,
+		},
+		{
+			name: "deny", usage: "<user id>", minArgs: 1, maxArgs: 1, replyRequired: false, action: access.ActionManage,
+			descriptions: map[string]string{
+				"":   "Decline the request of a user: /deny <user id>",
+				"ru": "Отклонить запрос пользователя: /deny <id>",
+			},
+			scopes: privateOnly, handle: t.handleDenyCommand,
+		},
+	}
+}
+
+// routeCommands sets the handlers of the commands of the registry.
+func (t *Telemoney) routeCommands() {
+	for _, c := range t.commands {
+		if c.handle != nil {
+			t.api.SetUpdateHandlerCommand(c.name, t.commandHandler(c))
+		}
+	}
+}
+
+// commandHandler runs the handler of the command once the sender is allowed and the call is right,
+// the wrong calls get the usage.
+func (t *Telemoney) commandHandler(c *command) func(context.Context, *model.CommandToHandle) {
+	return func(ctx context.Context, call *model.CommandToHandle) {
+		msg := call.Message
+		if c.action != "" {
+			err := t.access.Check(msg.ChatID, msg.UserID, c.action)
+			if err != nil {
+				t.refuse(msg, err)
+				return
+			}
+			t.rememberUserName(msg)
+		}
+
+		if c.replyRequired && call.ReplyTo == nil {
+			t.reply(msg, "Send /"+c.name+" in reply to the expense.")
+			return
+		}
+		if len(call.Args) < c.minArgs || (c.maxArgs != anyArgs && len(call.Args) > c.maxArgs) {
+			t.reply(msg, strings.TrimSpace("Usage: /"+c.name+" "+c.usage))
+			return
+		}
+		c.handle(ctx, call)
+	}
+}
+
+// registerCommands sets the command menus of the private and the group chats in every language of the registry.
+// A failure leaves the menus as they were, the commands work anyway.
+func (t *Telemoney) registerCommands() {
+	languages := make(map[string]bool)
+	for _, c := range t.commands {
+		for language := range c.descriptions {
+			languages[language] = true
+		}
+	}
+
+	for _, scope := range bothScopes {
+		for language := range languages {
+			err := t.api.SetCommands(scope, language, t.commandsOf(scope, language))
+			if err != nil {
+				slog.Warn("can't register the commands", slog.Any("err", err), slog.Any("scope", scope),
+					slog.String("language", language))
+			}
+		}
+	}
+}
+
+// commandsOf returns the menu of the scope, the descriptions missing in the language are the default ones.
+func (t *Telemoney) commandsOf(scope model.CommandScope, language string) []*model.Command {
+	var result []*model.Command
+	for _, c := range t.commands {
+		if !containsScope(c.scopes, scope) {
+			continue
+		}
+		description, ok := c.descriptions[language]
+		if !ok {
+			description = c.descriptions[""]
+		}
+		result = append(result, &model.Command{Name: c.name, Description: description})
+	}
+	return result
+}
+
+// readOnlyCommands lists the commands the read-only users may send, like "/help, /summary".
+func (t *Telemoney) readOnlyCommands() string {
+	var names []string
+	for _, c := range t.commands {
+		if c.action == access.ActionRead && c.handle != nil {
+			names = append(names, "/"+c.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func containsScope(scopes []model.CommandScope, scope model.CommandScope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// chatScope tells the kind of the chat by its id, the ids of the group chats are negative.
+func chatScope(chatID string) model.CommandScope {
+	if strings.HasPrefix(chatID, "-") {
+		return model.CommandScopeGroup
+	}
+	return model.CommandScopePrivate
+}
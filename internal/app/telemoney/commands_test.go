@@ -0,0 +1,56 @@
+package telemoney_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney"
+)
+
+func TestCommands_StrangersAreRefusedOnce(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+
+	b.send(groupChatID, "3", "/balance")
+	require.Equal(t, "Sorry, this bot is private. Send /join to ask the owner for access.", b.api.lastSent(groupChatID))
+	b.send(groupChatID, "3", "/remove")
+	require.Equal(t, 1, b.api.sentCount(groupChatID))
+}
+
+func TestCommands_ReadOnlyUsersGetTheCommandsTheyMaySend(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+
+	b.send(groupChatID, readOnlyID, "/undo")
+	require.Equal(t, "Sorry, you can only look here: /help, /summary, /balance, /history.", b.api.lastSent(groupChatID))
+}
+
+func TestCommands_WrongArgsGetTheUsage(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+
+	b.send(groupChatID, memberID, "/balance 2026-09 all")
+	require.Equal(t, "Usage: /balance [2006-01|all]", b.api.lastSent(groupChatID))
+	b.send(groupChatID, ownerID, "/deny")
+	require.Equal(t, "Usage: /deny <user id>", b.api.lastSent(groupChatID))
+}
+
+func TestCommands_ReplyIsRequired(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+	expense := b.send(groupChatID, memberID, "9.5 lunch")
+
+	b.send(groupChatID, memberID, "/remove")
+	require.Equal(t, "Send /remove in reply to the expense.", b.api.lastSent(groupChatID))
+	require.Empty(t, b.api.removed)
+
+	b.sendReply(groupChatID, memberID, "/history", expense)
+	require.NotEqual(t, "Send /history in reply to the expense.", b.api.lastSent(groupChatID))
+}
+
+func TestCommands_FailedRemoveIsMarked(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+	notExpense := b.makeMessage(groupChatID, memberID, "hello")
+
+	remove := b.sendReply(groupChatID, memberID, "/remove", notExpense)
+	require.Len(t, b.api.failed, 1)
+	require.Equal(t, remove.MessageID, b.api.failed[0].MessageID)
+	require.Empty(t, b.api.removed)
+}
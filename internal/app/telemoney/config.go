@@ -3,19 +3,89 @@ package telemoney
 import (
 	"errors"
 	"log/slog"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
 	Env                    string // TODO: use enum
+	HandlerTimeout         time.Duration
+	ShutdownTimeout        time.Duration  // for the handlers in flight and then for the queued writes, 0 - 30s
+	Location               *time.Location // dates in the sheets and the summary months are in it
 	SpreadsheetID          string
 	TransactionSheetID     string
 	TransactionSheetIDTest string
+	GSheetsAmountFormat    string
+	GSheetsPartitioned     bool // transactions go to per month sheets, e.g. transaction_2026_10
+	GSheetsRequestTimeout  time.Duration
+	GSheetsBatchWindow     time.Duration
+	GSheetsBatchMaxSize    int
+	GSheetsRetry           GSheetsRetryConfig
+	GSheetsChats           map[string]GSheetsLedgerConfig // chat id -> its own ledger, the other chats go to the sheets above
 
-	TgAuthToken      string
-	TgAuthTokenTest  string
-	GSheetsAuthToken string
+	TgAuthToken            string
+	TgAuthTokenTest        string
+	TgUpdatesMode          string        // polling, webhook
+	TgPollingTimeout       time.Duration // long polling timeout
+	TgOffsetPath           string        // the file with the last handled update id
+	TgGroupPrefixRequired  bool          // in the group chats only the messages starting with the bot mention or /add are taken
+	TgWebhookAddress       string        // the address the webhook listener is on
+	TgWebhookPath          string
+	TgWebhookURL           string // the public url telegram posts the updates to
+	TgWebhookSecret        string // checked in every posted update
+	TgWebhookCertFile      string // "" - the listener is plain http behind a tls proxy
+	TgWebhookKeyFile       string
+	TgWorkers              int           // chats handled at the same time, the updates of a chat go one by one
+	TgQueueSize            int           // updates waiting to be handled, receiving pauses when it is full
+	TgStatsInterval        time.Duration // how often the update queue stats are logged
+	GSheetsCredentials     string        // token, file, adc, oauth
+	GSheetsAuthToken       string
+	GSheetsCredentialsFile string
+	GSheetsOAuthTokenFile  string
+
+	OutboxPath          string
+	OutboxRetryInterval time.Duration
+
+	SummaryRefreshInterval time.Duration // 0 - the summary is refreshed only by /summary
+
+	SyncInterval     time.Duration // 0 - the changes made in the spreadsheet by hand are not picked up
+	SyncNotifyChatID string        // "" - the changes are not reported to a chat
+
+	MirrorSecondaries   []string // storages the writes are repeated to: sqlite
+	MirrorConsistency   string   // primary, all
+	MirrorRetryAttempts int
+	MirrorRetryInterval time.Duration
+	MirrorQueueSize     int
+	SQLitePath          string
+
+	UndoWindow    time.Duration // how long after /remove the transaction can be restored by /undo
+	UndoPath      string        // the file with the removals /undo can restore
+	PurgeAfter    time.Duration // removed transactions are kept for that long, 0 - forever
+	PurgeInterval time.Duration
+
+	AccessEnabled    bool     // false - anyone who finds the bot can use it
+	AccessOwners     []string // user ids, they approve the newcomers
+	AccessMembers    []string // user ids
+	AccessReadOnly   []string // user ids
+	AccessChats      []string // the group chats the bot works in
+	AccessGrantsPath string   // the file with the users and chats approved by /approve
+
+	ChatSettingsPath  string        // the file with the settings the chats picked on /start
+	OnboardingTimeout time.Duration // how long /start waits for an answer, 0 - 10m
+	UserNamesPath     string        // the file with the names of the senders shown by /balance
+}
+
+// GSheetsLedgerConfig is where the transactions of a chat are kept instead of the transaction sheet.
+type GSheetsLedgerConfig struct {
+	SpreadsheetID      string // "" - the same spreadsheet
+	TransactionSheetID string // "" - the transaction sheet of the env
+}
+
+type GSheetsRetryConfig struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
 }
 
 func readConfig() (*Config, error) {
@@ -31,10 +101,18 @@ func readConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = viper.BindEnv("tg.webhook.secret_token", "TELEMONEY_TG_WEBHOOK_SECRET")
+	if err != nil {
+		return nil, err
+	}
 	err = viper.BindEnv("gsheets.auth_token", "TELEMONEY_GAUTH_TOKEN")
 	if err != nil {
 		return nil, err
 	}
+	err = viper.BindEnv("gsheets.credentials_file", "TELEMONEY_GAUTH_CREDENTIALS_FILE")
+	if err != nil {
+		return nil, err
+	}
 
 	viper.SetConfigName("telemoney")
 	viper.SetConfigType("toml")
@@ -46,13 +124,81 @@ func readConfig() (*Config, error) {
 
 	config := Config{
 		Env:                    viper.GetString("env"),
+		HandlerTimeout:         viper.GetDuration("handler_timeout"),
+		ShutdownTimeout:        viper.GetDuration("shutdown_timeout"),
+		Location:               nil,
 		SpreadsheetID:          viper.GetString("gsheets.spreadsheet_id"),
 		TransactionSheetID:     viper.GetString("gsheets.transaction_sheet_id"),
 		TransactionSheetIDTest: viper.GetString("gsheets.transaction_sheet_id_test"),
+		GSheetsAmountFormat:    viper.GetString("gsheets.amount_format"),
+		GSheetsPartitioned:     viper.GetBool("gsheets.monthly_partitions"),
+		GSheetsRequestTimeout:  viper.GetDuration("gsheets.request_timeout"),
+		GSheetsBatchWindow:     viper.GetDuration("gsheets.batch_window"),
+		GSheetsBatchMaxSize:    viper.GetInt("gsheets.batch_max_size"),
+		GSheetsRetry: GSheetsRetryConfig{
+			MaxAttempts:    viper.GetInt("gsheets.retry.max_attempts"),
+			InitialBackoff: viper.GetDuration("gsheets.retry.initial_backoff"),
+			MaxBackoff:     viper.GetDuration("gsheets.retry.max_backoff"),
+		},
+		GSheetsChats: readGSheetsChats(),
+
+		TgAuthToken:            viper.GetString("tg.auth_token"),
+		TgAuthTokenTest:        viper.GetString("tg.auth_token_test"),
+		TgUpdatesMode:          viper.GetString("tg.updates_mode"),
+		TgPollingTimeout:       viper.GetDuration("tg.polling_timeout"),
+		TgOffsetPath:           viper.GetString("tg.offset_path"),
+		TgGroupPrefixRequired:  viper.GetBool("tg.group_prefix_required"),
+		TgWebhookAddress:       viper.GetString("tg.webhook.listen_address"),
+		TgWebhookPath:          viper.GetString("tg.webhook.path"),
+		TgWebhookURL:           viper.GetString("tg.webhook.url"),
+		TgWebhookSecret:        viper.GetString("tg.webhook.secret_token"),
+		TgWebhookCertFile:      viper.GetString("tg.webhook.cert_file"),
+		TgWebhookKeyFile:       viper.GetString("tg.webhook.key_file"),
+		TgWorkers:              viper.GetInt("tg.dispatcher.workers"),
+		TgQueueSize:            viper.GetInt("tg.dispatcher.queue_size"),
+		TgStatsInterval:        viper.GetDuration("tg.dispatcher.stats_interval"),
+		GSheetsCredentials:     viper.GetString("gsheets.credentials"),
+		GSheetsAuthToken:       viper.GetString("gsheets.auth_token"),
+		GSheetsCredentialsFile: viper.GetString("gsheets.credentials_file"),
+		GSheetsOAuthTokenFile:  viper.GetString("gsheets.oauth_token_file"),
+
+		OutboxPath:          viper.GetString("outbox.path"),
+		OutboxRetryInterval: viper.GetDuration("outbox.retry_interval"),
+
+		SummaryRefreshInterval: viper.GetDuration("summary.refresh_interval"),
 
-		TgAuthToken:      viper.GetString("tg.auth_token"),
-		TgAuthTokenTest:  viper.GetString("tg.auth_token_test"),
-		GSheetsAuthToken: viper.GetString("gsheets.auth_token"),
+		SyncInterval:     viper.GetDuration("sync.interval"),
+		SyncNotifyChatID: viper.GetString("sync.notify_chat_id"),
+
+		MirrorSecondaries:   viper.GetStringSlice("mirror.secondaries"),
+		MirrorConsistency:   viper.GetString("mirror.consistency"),
+		MirrorRetryAttempts: viper.GetInt("mirror.retry_attempts"),
+		MirrorRetryInterval: viper.GetDuration("mirror.retry_interval"),
+		MirrorQueueSize:     viper.GetInt("mirror.queue_size"),
+		SQLitePath:          viper.GetString("sqlite.path"),
+
+		UndoWindow:    viper.GetDuration("undo.window"),
+		UndoPath:      viper.GetString("undo.path"),
+		PurgeAfter:    viper.GetDuration("purge.after"),
+		PurgeInterval: viper.GetDuration("purge.interval"),
+
+		AccessEnabled:    viper.GetBool("access.enabled"),
+		AccessOwners:     viper.GetStringSlice("access.owners"),
+		AccessMembers:    viper.GetStringSlice("access.members"),
+		AccessReadOnly:   viper.GetStringSlice("access.read_only"),
+		AccessChats:      viper.GetStringSlice("access.chats"),
+		AccessGrantsPath: viper.GetString("access.grants_path"),
+
+		ChatSettingsPath:  viper.GetString("chat_settings.path"),
+		OnboardingTimeout: viper.GetDuration("chat_settings.onboarding_timeout"),
+		UserNamesPath:     viper.GetString("user_names.path"),
+	}
+
+	// "" is UTC
+	config.Location, err = time.LoadLocation(viper.GetString("timezone"))
+	if err != nil {
+		slog.Error("Config parsing failed, bad timezone", slog.Any("err", err))
+		return nil, err
 	}
 
 	if config.Env == "" ||
@@ -60,10 +206,60 @@ func readConfig() (*Config, error) {
 		config.TransactionSheetID == "" ||
 		config.TransactionSheetIDTest == "" ||
 		config.TgAuthToken == "" ||
-		config.GSheetsAuthToken == "" {
+		!isGSheetsCredentialsComplete(&config) ||
+		!isGSheetsChatsComplete(&config) ||
+		!isTgUpdatesModeComplete(&config) ||
+		(config.AccessEnabled && len(config.AccessOwners) == 0) ||
+		config.OutboxPath == "" {
 		slog.Error("Config parsing failed", slog.Any("parsedConfig", config))
 		return nil, errors.New("Config is not complete")
 	}
 
 	return &config, nil
 }
+
+func readGSheetsChats() map[string]GSheetsLedgerConfig {
+	chats := make(map[string]GSheetsLedgerConfig)
+	for chatID := range viper.GetStringMap("gsheets.chats") {
+		chats[chatID] = GSheetsLedgerConfig{
+			SpreadsheetID:      viper.GetString("gsheets.chats." + chatID + ".spreadsheet_id"),
+			TransactionSheetID: viper.GetString("gsheets.chats." + chatID + ".transaction_sheet_id"),
+		}
+	}
+	return chats
+}
+
+func isGSheetsCredentialsComplete(config *Config) bool {
+	switch config.GSheetsCredentials {
+	case "token", "":
+		return config.GSheetsAuthToken != ""
+	case "file":
+		return config.GSheetsCredentialsFile != ""
+	case "adc":
+		return true
+	case "oauth":
+		return config.GSheetsCredentialsFile != "" && config.GSheetsOAuthTokenFile != ""
+	}
+	return false
+}
+
+// isGSheetsChatsComplete checks that every chat ledger differs from the transaction sheet.
+func isGSheetsChatsComplete(config *Config) bool {
+	for _, ledger := range config.GSheetsChats {
+		if ledger.SpreadsheetID == "" && ledger.TransactionSheetID == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func isTgUpdatesModeComplete(config *Config) bool {
+	switch config.TgUpdatesMode {
+	case "polling", "":
+		return true
+	case "webhook":
+		return config.TgWebhookAddress != "" && config.TgWebhookURL != "" &&
+			(config.TgWebhookCertFile == "") == (config.TgWebhookKeyFile == "")
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package telemoney
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+	"github.com/mitrkos/telemoney/internal/model"
+	parsing "github.com/mitrkos/telemoney/internal/pkg/parser"
+)
+
+// maxHelpCategories is how many of the categories used in the chat /help shows, the most used ones.
+const maxHelpCategories = 10
+
+// handleHelpCommand replies with the input the parser takes, the examples as it parses them right now,
+// the categories used in the chat and the commands of the chat.
+func (t *Telemoney) handleHelpCommand(ctx context.Context, call *model.CommandToHandle) {
+	msg := call.Message
+	settings := t.chatSettings.Get(msg.ChatID)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Send the expenses, one per message: %s\n", parsing.Grammar)
+	for _, example := range parsing.Examples {
+		data, err := t.parser.ParseTransactionUserInputDataFromText(example)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&text, "%s → %s\n", example, formatParsedInput(data, settings))
+	}
+	text.WriteString("Edit the message to fix the expense.\n")
+
+	categories, err := t.chatCategories(ctx, msg.ChatID)
+	if err != nil {
+		slog.Error("can't list transactions for the help", slog.Any("err", err))
+	} else if len(categories) == 0 {
+		text.WriteString("Any word is a category.\n")
+	} else {
+		fmt.Fprintf(&text, "The categories here: %s\n", strings.Join(categories, ", "))
+	}
+
+	text.WriteString("\n")
+	for _, command := range t.commandsOf(chatScope(msg.ChatID), "") {
+		fmt.Fprintf(&text, "/%s - %s\n", command.Name, command.Description)
+	}
+	t.reply(msg, strings.TrimSuffix(text.String(), "\n"))
+}
+
+// formatParsedInput makes a line like "9.50 EUR in lunch, tags: work, airport, comment: late flight".
+func formatParsedInput(data *parsing.TransactionUserInputData, settings *chatsettings.Settings) string {
+	text := settings.FormatAmount(data.Amount) + " in " + data.Category
+	if len(data.Tags) > 0 {
+		text += ", tags: " + strings.Join(data.Tags, ", ")
+	}
+	if data.Comment != nil {
+		text += ", comment: " + *data.Comment
+	}
+	return text
+}
+
+// chatCategories returns the categories of the chat, the most used first, up to maxHelpCategories.
+func (t *Telemoney) chatCategories(ctx context.Context, chatID string) ([]string, error) {
+	ctx, cancel := t.withHandlerTimeout(ctx)
+	defer cancel()
+
+	transactions, err := t.ledgers.LedgerOf(chatID).List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, transaction := range chatTransactions(transactions, chatID) {
+		counts[transaction.Category]++
+	}
+
+	categories := make([]string, 0, len(counts))
+	for category := range counts {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if counts[categories[i]] != counts[categories[j]] {
+			return counts[categories[i]] > counts[categories[j]]
+		}
+		return categories[i] < categories[j]
+	})
+	if len(categories) > maxHelpCategories {
+		categories = categories[:maxHelpCategories]
+	}
+	return categories, nil
+}
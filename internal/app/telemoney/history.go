@@ -0,0 +1,132 @@
+package telemoney
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/audit"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/outbox"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/reconcile"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// handleHistoryCommand replies with the audit trail of the message the command replies to.
+func (t *Telemoney) handleHistoryCommand(ctx context.Context, call *model.CommandToHandle) {
+	msg := call.ReplyTo
+
+	ctx, cancel := t.withHandlerTimeout(ctx)
+	defer cancel()
+
+	records, err := t.ledgers.LedgerOf(msg.ChatID).AuditLog().ListByMessageID(ctx, msg.ChatID, msg.MessageID)
+	if err != nil {
+		slog.Error("can't read the audit log", slog.Any("err", err), slog.String("messageID", msg.MessageID))
+		t.markMessageHandledFailure(msg)
+		return
+	}
+
+	text := "no history for the message"
+	if len(records) > 0 {
+		location := t.chatSettings.Get(msg.ChatID).LocationOr(t.config.Location)
+		lines := make([]string, 0, len(records))
+		for _, record := range records {
+			lines = append(lines, formatAuditRecord(record, location))
+		}
+		text = strings.Join(lines, "\n")
+	}
+	_, _ = t.api.SendMessage(&model.MessageToSend{
+		ChatID: msg.ChatID,
+		Text:   text,
+	})
+}
+
+// recordEntry adds the applied outbox entry to the audit log, the previous state is the one the outbox read
+// from the storage before the write.
+func (t *Telemoney) recordEntry(ctx context.Context, entry *outbox.Entry) {
+	record := &audit.Record{
+		At:        time.Now(),
+		MessageID: entry.MessageID,
+		Operation: audit.OperationInsert,
+		Trigger:   audit.TriggerMessage,
+		ChatID:    entry.ChatID,
+		UserID:    entry.UserID,
+		Before:    entry.Before,
+		After:     entry.Transaction,
+	}
+	switch entry.Kind {
+	case outbox.OperationInsert:
+	case outbox.OperationUpsert:
+		record.Operation, record.Trigger = audit.OperationUpdate, audit.TriggerEdit
+	case outbox.OperationDelete:
+		record.Operation, record.Trigger = audit.OperationDelete, audit.TriggerRemove
+	case outbox.OperationRestore, outbox.OperationMove:
+		record.Operation, record.Trigger = audit.OperationRestore, audit.TriggerUndo
+	}
+	t.appendAuditRecord(ctx, record)
+}
+
+// recordSyncChanges adds the changes made outside the bot to the audit log.
+func (t *Telemoney) recordSyncChanges(ctx context.Context, events []*reconcile.Event) {
+	for _, event := range events {
+		record := &audit.Record{
+			At:        time.Now(),
+			MessageID: "",
+			Operation: "",
+			Trigger:   audit.TriggerSync,
+			ChatID:    "",
+			UserID:    "",
+			Before:    nil,
+			After:     nil,
+		}
+		if event.Before != nil {
+			record.MessageID, record.ChatID, record.Before = event.Before.Transaction.MessageID, event.Before.Transaction.ChatID, event.Before.Transaction
+		}
+		if event.After != nil {
+			record.MessageID, record.ChatID, record.After = event.After.Transaction.MessageID, event.After.Transaction.ChatID, event.After.Transaction
+		}
+		switch event.Kind {
+		case reconcile.ChangeAdded:
+			record.Operation = audit.OperationInsert
+		case reconcile.ChangeUpdated:
+			record.Operation = audit.OperationUpdate
+		case reconcile.ChangeRemoved:
+			record.Operation = audit.OperationDelete
+		}
+		t.appendAuditRecord(ctx, record)
+	}
+}
+
+// appendAuditRecord writes the record to the ledger of its chat, a failure is logged only: the transaction write
+// itself is done.
+func (t *Telemoney) appendAuditRecord(ctx context.Context, record *audit.Record) {
+	err := t.ledgers.LedgerOf(record.ChatID).AuditLog().Append(ctx, record)
+	if err != nil {
+		slog.Error("can't write the audit log", slog.Any("err", err), slog.Any("record", record))
+	}
+}
+
+// formatAuditRecord makes a line like "2024-03-09 16:00:00 edit by 42: amount 9.5 → 95".
+func formatAuditRecord(record *audit.Record, location *time.Location) string {
+	source := string(record.Trigger)
+	if record.UserID != "" {
+		source += " by " + record.UserID
+	}
+
+	var change string
+	switch {
+	case record.Operation == audit.OperationDelete:
+		change = "removed"
+	case record.Operation == audit.OperationRestore:
+		change = "restored"
+	case record.Operation == audit.OperationInsert || record.Before == nil:
+		change = "set to " + formatTransaction(record.After)
+	default:
+		change = strings.Join(diffTransactions(record.Before, record.After, location), ", ")
+		if change == "" {
+			change = "no changes"
+		}
+	}
+	return fmt.Sprintf("%s %s: %s", record.At.In(location).Format(time.DateTime), source, change)
+}
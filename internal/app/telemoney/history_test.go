@@ -0,0 +1,23 @@
+package telemoney_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney"
+)
+
+func TestHistory_IsKeptInTheLedgerOfTheChat(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+	b.giveOwnLedger(t, ownerID, "transaction_own")
+
+	expense := b.send(ownerID, ownerID, "9.5 lunch")
+	b.send(groupChatID, memberID, "3 coffee")
+
+	// the header and the record of each chat
+	require.Len(t, b.sheets.Rows("transaction_own_audit"), 2)
+	require.Len(t, b.sheets.Rows(testSheetID+"_audit"), 2)
+	b.sendReply(ownerID, ownerID, "/history", expense)
+	require.Contains(t, b.api.lastSent(ownerID), "set to 9.5 lunch")
+}
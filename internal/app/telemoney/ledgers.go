@@ -0,0 +1,232 @@
+package telemoney
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/audit"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/chatstorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/gsheetstorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/summary"
+	"github.com/mitrkos/telemoney/internal/model"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+)
+
+var (
+	errLedgerInConfig   = errors.New("the ledger of the chat is set in the config")
+	errSpreadsheetInUse = errors.New("the spreadsheet is used by another chat")
+)
+
+// Ledgers are the storages the chats keep their transactions in, each ledger has the summary of its transactions.
+type Ledgers interface {
+	// LedgerOf returns the ledger of the chat
+	LedgerOf(chatID string) Ledger
+	// Ledgers returns every ledger once
+	Ledgers() []Ledger
+}
+
+type Ledger interface {
+	// List returns the transactions of the ledger that are not deleted, of all the chats kept there
+	List(ctx context.Context) ([]*model.Transaction, error)
+	summary.Writer
+	// AuditLog keeps the changes of the transactions of the ledger, next to them
+	AuditLog() audit.Log
+}
+
+// gsheetLedgers are the transaction sheets: the one of the env and the ones of the chats with their own ledger,
+// set in the config or linked on /start. The config wins over a link.
+type gsheetLedgers struct {
+	ctx                context.Context // the clients opened on a link live as long as it
+	config             *Config
+	chatSettings       *chatsettings.Store // the spreadsheets linked on /start
+	transactionSheetID string
+
+	mu            sync.Mutex
+	clients       map[string]*gsheetclient.GSheetsClient // by the spreadsheet id
+	storages      map[gsheetLedgerKey]*gsheetstorage.TransactionStorage
+	defaultLedger *gsheetstorage.TransactionStorage
+	chatLedgers   map[string]*gsheetstorage.TransactionStorage // the chats sharing a sheet share the storage
+	router        *chatstorage.TransactionStorage
+}
+
+type gsheetLedgerKey struct {
+	spreadsheetID      string
+	transactionSheetID string
+}
+
+// newGSheetLedgers opens the sheets of the config and of the chats linked in the settings.
+func newGSheetLedgers(ctx context.Context, config *Config, chatSettings *chatsettings.Store) (*gsheetLedgers, error) {
+	transactionSheetID := config.TransactionSheetIDTest
+	if config.Env == "prod" {
+		transactionSheetID = config.TransactionSheetID
+	}
+	ledgers := &gsheetLedgers{
+		ctx:                ctx,
+		config:             config,
+		chatSettings:       chatSettings,
+		transactionSheetID: transactionSheetID,
+		mu:                 sync.Mutex{},
+		clients:            make(map[string]*gsheetclient.GSheetsClient),
+		storages:           make(map[gsheetLedgerKey]*gsheetstorage.TransactionStorage),
+		defaultLedger:      nil,
+		chatLedgers:        make(map[string]*gsheetstorage.TransactionStorage),
+		router:             nil,
+	}
+
+	var err error
+	ledgers.defaultLedger, err = ledgers.open(gsheetLedgerKey{spreadsheetID: config.SpreadsheetID, transactionSheetID: transactionSheetID})
+	if err != nil {
+		return nil, err
+	}
+	for chatID, spreadsheetID := range chatSettings.Spreadsheets() {
+		if _, ok := config.GSheetsChats[chatID]; ok {
+			continue
+		}
+		ledgers.chatLedgers[chatID], err = ledgers.open(gsheetLedgerKey{spreadsheetID: spreadsheetID, transactionSheetID: transactionSheetID})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for chatID, chatConfig := range config.GSheetsChats {
+		key := gsheetLedgerKey{spreadsheetID: chatConfig.SpreadsheetID, transactionSheetID: chatConfig.TransactionSheetID}
+		if key.spreadsheetID == "" {
+			key.spreadsheetID = config.SpreadsheetID
+		}
+		if key.transactionSheetID == "" {
+			key.transactionSheetID = transactionSheetID
+		}
+		ledgers.chatLedgers[chatID], err = ledgers.open(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chatLedgers := make(map[string]chatstorage.Ledger, len(ledgers.chatLedgers))
+	for chatID, ledger := range ledgers.chatLedgers {
+		chatLedgers[chatID] = ledger
+	}
+	ledgers.router = chatstorage.New(ledgers.defaultLedger, chatLedgers)
+	return ledgers, nil
+}
+
+// LinkSpreadsheet sets up the transaction sheet in the spreadsheet and moves the chat there with its transactions.
+// The link is saved in the chat settings before the move, so a restart in the middle of it finds the chat
+// in the spreadsheet; a failed move takes the link back.
+func (l *gsheetLedgers) LinkSpreadsheet(ctx context.Context, chatID string, spreadsheetID string) error {
+	if _, ok := l.config.GSheetsChats[chatID]; ok {
+		return errLedgerInConfig
+	}
+	if l.isSpreadsheetInUse(chatID, spreadsheetID) {
+		return errSpreadsheetInUse
+	}
+
+	l.mu.Lock()
+	ledger, err := l.open(gsheetLedgerKey{spreadsheetID: spreadsheetID, transactionSheetID: l.transactionSheetID})
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	err = ledger.Bootstrap(ctx)
+	if err != nil {
+		slog.Error("can't set up the transaction sheet of the chat", slog.String("chatID", chatID), slog.Any("err", err))
+		return err
+	}
+
+	previous := l.chatSettings.Get(chatID)
+	linked := *previous
+	linked.SpreadsheetID = spreadsheetID
+	err = l.chatSettings.Set(chatID, &linked)
+	if err != nil {
+		slog.Error("can't save the spreadsheet of the chat", slog.String("chatID", chatID), slog.Any("err", err))
+		return err
+	}
+	err = l.router.MoveChat(ctx, chatID, ledger)
+	if err != nil {
+		slog.Error("can't move the transactions of the chat to its spreadsheet", slog.String("chatID", chatID), slog.Any("err", err))
+		if restoreErr := l.chatSettings.Set(chatID, previous); restoreErr != nil {
+			slog.Error("can't take the spreadsheet link back", slog.String("chatID", chatID), slog.Any("err", restoreErr))
+		}
+		return err
+	}
+	l.mu.Lock()
+	l.chatLedgers[chatID] = ledger
+	l.mu.Unlock()
+	slog.Info("chat is linked to its spreadsheet", slog.String("chatID", chatID), slog.String("spreadsheetID", spreadsheetID))
+	return nil
+}
+
+// isSpreadsheetInUse tells if the spreadsheet is the shared one or the one of another chat.
+func (l *gsheetLedgers) isSpreadsheetInUse(chatID string, spreadsheetID string) bool {
+	if spreadsheetID == l.config.SpreadsheetID {
+		return true
+	}
+	for otherChatID, chatConfig := range l.config.GSheetsChats {
+		if otherChatID != chatID && chatConfig.SpreadsheetID == spreadsheetID {
+			return true
+		}
+	}
+	for otherChatID, linked := range l.chatSettings.Spreadsheets() {
+		if otherChatID != chatID && linked == spreadsheetID {
+			return true
+		}
+	}
+	return false
+}
+
+// open returns the storage of the sheet, it is opened once; l.mu is held or there are no other users yet.
+func (l *gsheetLedgers) open(key gsheetLedgerKey) (*gsheetstorage.TransactionStorage, error) {
+	if transactionStorage, ok := l.storages[key]; ok {
+		return transactionStorage, nil
+	}
+	gSheetsClient, ok := l.clients[key.spreadsheetID]
+	if !ok {
+		var err error
+		gSheetsClient, err = newGSheetsClient(l.ctx, l.config, key.spreadsheetID)
+		if err != nil {
+			return nil, err
+		}
+		l.clients[key.spreadsheetID] = gSheetsClient
+	}
+	l.storages[key] = gsheetstorage.New(gSheetsClient, &gsheetstorage.Config{
+		TransactionSheetID: key.transactionSheetID,
+		MonthlyPartitions:  l.config.GSheetsPartitioned,
+		Location:           l.config.Location,
+		AmountFormat:       l.config.GSheetsAmountFormat,
+	})
+	return l.storages[key], nil
+}
+
+func (l *gsheetLedgers) LedgerOf(chatID string) Ledger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ledger, ok := l.chatLedgers[chatID]; ok {
+		return ledger
+	}
+	return l.defaultLedger
+}
+
+func (l *gsheetLedgers) Ledgers() []Ledger {
+	all := l.all()
+	result := make([]Ledger, 0, len(all))
+	for _, ledger := range all {
+		result = append(result, ledger)
+	}
+	return result
+}
+
+// all returns the default ledger and then the other ones, each once.
+func (l *gsheetLedgers) all() []*gsheetstorage.TransactionStorage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := []*gsheetstorage.TransactionStorage{l.defaultLedger}
+	for _, ledger := range l.chatLedgers {
+		if !slices.Contains(result, ledger) {
+			result = append(result, ledger)
+		}
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+// Package migrate copies the transactions from one storage to another.
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+var ErrVerificationFailed = errors.New("migration verification failed")
+
+const progressEvery = 100
+
+type Result struct {
+	Copied  int // missing in the target
+	Updated int // different in the target, the source wins
+	Skipped int // already in the target, e.g. copied by an interrupted run
+	Extra   int // only the target has them, they are kept
+
+	Count    int    // transactions in the source, all of them are in the target after the migration
+	Checksum string // of the source transactions, equal to the one of the same transactions in the target
+}
+
+// Migrate copies the transactions of the source to the target one by one, keeping the message ids and the dates.
+// The source is read page by page. The transactions the target has already are skipped, so an interrupted
+// migration is resumed by running it again. In the end the target is read back and compared with the source.
+func Migrate(ctx context.Context, source storage.TransactionStorage, target storage.TransactionStorage) (*Result, error) {
+	digests := make(map[model.TransactionKey]digest)
+	synced, err := storage.Sync(ctx, source, target, func(transaction *model.Transaction) {
+		digests[model.KeyOf(transaction)] = digestOf(transaction)
+		if len(digests)%progressEvery == 0 {
+			slog.Info("migrating", slog.Int("done", len(digests)))
+		}
+	})
+	if synced == nil {
+		return nil, fmt.Errorf("can't read the target: %w", err)
+	}
+	result := &Result{
+		Copied:   synced.Inserted,
+		Updated:  synced.Updated,
+		Skipped:  synced.Unchanged,
+		Extra:    len(synced.Extra),
+		Count:    len(digests),
+		Checksum: "",
+	}
+	if err != nil {
+		return result, fmt.Errorf("can't copy the transactions: %w", err)
+	}
+
+	result.Checksum, err = verify(ctx, digests, target)
+	if err != nil {
+		return result, err
+	}
+	slog.Info("migration is done",
+		slog.Int("copied", result.Copied), slog.Int("updated", result.Updated), slog.Int("skipped", result.Skipped),
+		slog.Int("extra", result.Extra), slog.Int("count", result.Count), slog.String("checksum", result.Checksum))
+	return result, nil
+}
+
+// verify reads the target back page by page, it must have all the source transactions as they are.
+// Returns the checksum of the source.
+func verify(ctx context.Context, sourceDigests map[model.TransactionKey]digest, target storage.TransactionStorage) (string, error) {
+	copies := make([]digest, 0, len(sourceDigests))
+	err := storage.ListPages(ctx, target, storage.SyncPageSize, func(transactions []*model.Transaction) error {
+		for _, transaction := range transactions {
+			if _, ok := sourceDigests[model.KeyOf(transaction)]; ok {
+				copies = append(copies, digestOf(transaction))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't read the target back: %w", err)
+	}
+
+	if len(copies) != len(sourceDigests) {
+		return "", fmt.Errorf("%w: %d transactions in the source, %d of them in the target",
+			ErrVerificationFailed, len(sourceDigests), len(copies))
+	}
+	originals := make([]digest, 0, len(sourceDigests))
+	for _, d := range sourceDigests {
+		originals = append(originals, d)
+	}
+	sourceChecksum, targetChecksum := combineDigests(originals), combineDigests(copies)
+	if sourceChecksum != targetChecksum {
+		return "", fmt.Errorf("%w: the source checksum is %s, the target one is %s",
+			ErrVerificationFailed, sourceChecksum, targetChecksum)
+	}
+	return sourceChecksum, nil
+}
+
+type digest [sha256.Size]byte
+
+func digestOf(transaction *model.Transaction) digest {
+	return sha256.Sum256([]byte(formatTransaction(transaction)))
+}
+
+// Checksum is sha256 of the transactions in any order, a missing comment equals an empty one.
+func Checksum(transactions []*model.Transaction) string {
+	digests := make([]digest, 0, len(transactions))
+	for _, transaction := range transactions {
+		digests = append(digests, digestOf(transaction))
+	}
+	return combineDigests(digests)
+}
+
+// combineDigests hashes the digests of the transactions sorted, so the order the storages list them in doesn't matter.
+func combineDigests(digests []digest) string {
+	slices.SortFunc(digests, func(a, b digest) int { return bytes.Compare(a[:], b[:]) })
+	hash := sha256.New()
+	for _, d := range digests {
+		hash.Write(d[:])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func formatTransaction(transaction *model.Transaction) string {
+	comment := ""
+	if transaction.Comment != nil {
+		comment = *transaction.Comment
+	}
+	return strings.Join([]string{
+		strconv.FormatInt(transaction.CreatedAt, 10),
+		transaction.ChatID,
+		transaction.MessageID,
+		transaction.UserID,
+		strconv.FormatFloat(transaction.Amount, 'g', -1, 64),
+		transaction.Category,
+		strings.Join(transaction.Tags, ","),
+		comment,
+	}, "\t")
+}
@@ -0,0 +1,71 @@
+package migrate_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/migrate"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/sqlitestorage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// interruptedStorage fails the inserts after the first limit ones.
+type interruptedStorage struct {
+	storage.TransactionStorage
+	limit int
+}
+
+func (s *interruptedStorage) Insert(ctx context.Context, transaction *model.Transaction) error {
+	if s.limit == 0 {
+		return storage.ErrOperationFailed
+	}
+	s.limit--
+	return s.TransactionStorage.Insert(ctx, transaction)
+}
+
+func newSQLiteStorage(t *testing.T, name string) *sqlitestorage.TransactionStorage {
+	s, err := sqlitestorage.New(&sqlitestorage.Config{Path: filepath.Join(t.TempDir(), name)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	source := newSQLiteStorage(t, "source.db")
+	target := newSQLiteStorage(t, "target.db")
+
+	comment := "I need food!"
+	transactions := []*model.Transaction{
+		{CreatedAt: 1710000000, MessageID: "1", ChatID: "7", UserID: "8", Amount: 9.5, Category: "lunch", Tags: []string{"a", "b"}, Comment: &comment},
+		{CreatedAt: 1710000100, MessageID: "2", ChatID: "7", UserID: "8", Amount: 3, Category: "coffee", Tags: nil, Comment: nil},
+		{CreatedAt: 1710000200, MessageID: "3", ChatID: "7", UserID: "8", Amount: 15, Category: "taxi", Tags: nil, Comment: nil},
+	}
+	for _, transaction := range transactions {
+		require.NoError(t, source.Insert(context.Background(), transaction))
+	}
+	require.NoError(t, target.Insert(context.Background(),
+		&model.Transaction{CreatedAt: 1700000000, MessageID: "100", ChatID: "7", UserID: "8", Amount: 1, Category: "other", Tags: nil, Comment: nil}))
+
+	_, err := migrate.Migrate(context.Background(), source, &interruptedStorage{TransactionStorage: target, limit: 2})
+	require.ErrorIs(t, err, storage.ErrOperationFailed)
+
+	// resumed
+	result, err := migrate.Migrate(context.Background(), source, target)
+	require.NoError(t, err)
+	require.Equal(t, &migrate.Result{
+		Copied:   1,
+		Updated:  0,
+		Skipped:  2,
+		Extra:    1,
+		Count:    3,
+		Checksum: migrate.Checksum(transactions),
+	}, result)
+
+	migrated, err := target.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, transactions, migrated[1:])
+}
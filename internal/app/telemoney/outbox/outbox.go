@@ -0,0 +1,419 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+	"github.com/mitrkos/telemoney/internal/utils"
+)
+
+type OperationKind string
+
+const (
+	OperationInsert OperationKind = "insert"
+	OperationUpsert OperationKind = "upsert" // update, insert if the transaction is not found
+	OperationDelete OperationKind = "delete"
+	// OperationRestore brings back the transaction of the message deleted last, the outbox sets Transaction to it
+	OperationRestore OperationKind = "restore"
+	// OperationMove keys Transaction by its message instead of MessageID, e.g. the message /undo posts
+	OperationMove OperationKind = "move"
+)
+
+// Entry is a storage write together with the message it came from.
+type Entry struct {
+	Kind        OperationKind
+	Transaction *model.Transaction // nil for OperationDelete and OperationRestore till it is applied
+	MessageID   string
+	ChatID      string
+	UserID      string
+	// Before is the transaction as it was stored right before the entry was applied, nil if there was none.
+	// It is set by the outbox and isn't kept in the journal.
+	Before *model.Transaction
+}
+
+type Config struct {
+	Path          string        // the journal of the queued entries, one json record per line
+	RetryInterval time.Duration // pause between replays while the storage is unavailable
+}
+
+// Outbox keeps writes the storage couldn't take because it was temporarily unavailable
+// and replays them in order once it is back.
+type Outbox struct {
+	config  *Config
+	storage storage.TransactionStorage
+
+	mu       sync.Mutex
+	entries  []*Entry
+	inFlight map[model.TransactionKey]chan struct{} // the keys Submit writes right now, closed when it is done
+	wakeup   chan struct{}
+
+	onDelivered func(context.Context, *Entry)
+	onFailed    func(context.Context, *Entry, error)
+}
+
+func New(config *Config, transactionStorage storage.TransactionStorage) (*Outbox, error) {
+	err := os.MkdirAll(filepath.Dir(config.Path), 0o750) //nolint:gomnd // rwx for the owner, rx for the group
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := readEntries(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		slog.Info("outbox has queued entries", slog.Int("count", len(entries)))
+	}
+	// the journal starts with the entries left, without the records of the delivered ones
+	err = writeEntries(config.Path, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Outbox{
+		config:      config,
+		storage:     transactionStorage,
+		mu:          sync.Mutex{},
+		entries:     entries,
+		inFlight:    make(map[model.TransactionKey]chan struct{}),
+		wakeup:      make(chan struct{}, 1),
+		onDelivered: nil,
+		onFailed:    nil,
+	}, nil
+}
+
+// SetDeliveryHandlers sets callbacks for queued entries: delivered when the replay succeeded,
+// failed when the storage rejected the entry for good.
+func (o *Outbox) SetDeliveryHandlers(onDelivered func(context.Context, *Entry), onFailed func(context.Context, *Entry, error)) {
+	o.onDelivered = onDelivered
+	o.onFailed = onFailed
+}
+
+// Submit applies the entry right away if nothing is queued before it.
+// If the storage is temporarily unavailable or older entries are still queued, the entry is queued and queued is true.
+// An entry waits for the one of the same transaction being applied, so it can't overtake it when that one is queued.
+func (o *Outbox) Submit(ctx context.Context, entry *Entry) (bool, error) {
+	key := model.TransactionKey{ChatID: entry.ChatID, MessageID: entry.MessageID}
+	for {
+		o.mu.Lock()
+		if len(o.entries) > 0 {
+			err := o.enqueueLocked(entry)
+			o.mu.Unlock()
+			return err == nil, err
+		}
+		done, busy := o.inFlight[key]
+		if !busy {
+			o.inFlight[key] = make(chan struct{})
+			o.mu.Unlock()
+			break
+		}
+		o.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	err := applyEntry(ctx, o.storage, entry)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	close(o.inFlight[key])
+	delete(o.inFlight, key)
+	if err == nil || !errors.Is(err, storage.ErrTemporarilyUnavailable) {
+		return false, err
+	}
+	err = o.enqueueLocked(entry)
+	return err == nil, err
+}
+
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Run replays the queued entries until ctx is done.
+func (o *Outbox) Run(ctx context.Context) {
+	for {
+		entry := o.head()
+		if entry == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.wakeup:
+				continue
+			}
+		}
+
+		err := applyEntry(ctx, o.storage, entry)
+		if err != nil && errors.Is(err, storage.ErrTemporarilyUnavailable) {
+			slog.Warn("outbox replay failed, waiting", slog.Any("err", err), slog.Int("queued", o.Len()))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(o.config.RetryInterval):
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if dropErr := o.dropHead(entry); dropErr != nil {
+			slog.Error("outbox can't drop the replayed entry", slog.Any("err", dropErr), slog.Any("entry", entry))
+		}
+
+		if err != nil {
+			slog.Error("outbox entry is rejected by the storage", slog.Any("err", err), slog.Any("entry", entry))
+			if o.onFailed != nil {
+				o.onFailed(ctx, entry, err)
+			}
+			continue
+		}
+		if o.onDelivered != nil {
+			o.onDelivered(ctx, entry)
+		}
+	}
+}
+
+// enqueueLocked appends the entry to the journal and the queue, o.mu is held.
+func (o *Outbox) enqueueLocked(entry *Entry) error {
+	err := appendRecord(o.config.Path, convertEntryToRecord(entry))
+	if err != nil {
+		slog.Error("outbox write failed", slog.Any("err", err), slog.Any("entry", entry))
+		return err
+	}
+	o.entries = append(o.entries, entry)
+
+	select {
+	case o.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (o *Outbox) head() *Entry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) == 0 {
+		return nil
+	}
+	return o.entries[0]
+}
+
+func (o *Outbox) dropHead(entry *Entry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) == 0 || o.entries[0] != entry {
+		return nil
+	}
+	o.entries = o.entries[1:]
+	if len(o.entries) == 0 {
+		// the journal is started over when everything is delivered
+		return writeEntries(o.config.Path, nil)
+	}
+	return appendRecord(o.config.Path, &entryRecord{
+		Kind:        recordKindAck,
+		Transaction: nil,
+		MessageID:   entry.MessageID,
+		ChatID:      entry.ChatID,
+		UserID:      "",
+	})
+}
+
+func applyEntry(ctx context.Context, transactionStorage storage.TransactionStorage, entry *Entry) error {
+	// the restored and the moved transactions have no state before to keep, nor does a new one
+	if entry.Kind == OperationUpsert || entry.Kind == OperationDelete {
+		before, err := transactionStorage.Get(ctx, entry.ChatID, entry.MessageID)
+		if err != nil && !errors.Is(err, storage.ErrTransactionNotFound) {
+			slog.Warn("can't read the transaction before the change", slog.Any("err", err), slog.String("messageID", entry.MessageID))
+		}
+		entry.Before = before
+	}
+
+	switch entry.Kind {
+	case OperationInsert:
+		return transactionStorage.Insert(ctx, entry.Transaction)
+	case OperationUpsert:
+		err := transactionStorage.Update(ctx, entry.Transaction)
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			err = transactionStorage.Insert(ctx, entry.Transaction)
+		}
+		return err
+	case OperationDelete:
+		return transactionStorage.DeleteByMessageID(ctx, entry.ChatID, entry.MessageID)
+	case OperationRestore:
+		restored, err := transactionStorage.Restore(ctx, entry.ChatID, entry.MessageID)
+		if err != nil {
+			return err
+		}
+		entry.Transaction = restored
+		return nil
+	case OperationMove:
+		// Insert overwrites the transaction a replay has put already
+		err := transactionStorage.Insert(ctx, entry.Transaction)
+		if err != nil {
+			return err
+		}
+		err = transactionStorage.DeleteByMessageID(ctx, entry.ChatID, entry.MessageID)
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			// deleted by the try before
+			return nil
+		}
+		return err
+	}
+	return errors.New("unknown outbox operation: " + string(entry.Kind))
+}
+
+// recordKindAck is the journal record of a delivered entry, it drops the oldest entry queued.
+const recordKindAck OperationKind = "ack"
+
+type entryRecord struct {
+	Kind        OperationKind      `json:"kind"`
+	Transaction *transactionRecord `json:"transaction,omitempty"`
+	MessageID   string             `json:"message_id"`
+	ChatID      string             `json:"chat_id"`
+	UserID      string             `json:"user_id,omitempty"`
+}
+
+type transactionRecord struct {
+	CreatedAt int64    `json:"created_at"`
+	MessageID string   `json:"message_id"`
+	ChatID    string   `json:"chat_id,omitempty"`
+	UserID    string   `json:"user_id,omitempty"`
+	Amount    float64  `json:"amount"`
+	Category  string   `json:"category"`
+	Tags      []string `json:"tags,omitempty"`
+	Comment   *string  `json:"comment,omitempty"`
+}
+
+// readEntries replays the journal: the entries less the delivered ones.
+// A broken last line is an append cut by a crash, its Submit didn't return, so it is skipped.
+func readEntries(path string) ([]*Entry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*Entry
+	var brokenLineErr error
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if brokenLineErr != nil {
+			return nil, brokenLineErr
+		}
+		var record entryRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			brokenLineErr = err
+			continue
+		}
+		if record.Kind == recordKindAck {
+			if len(entries) > 0 {
+				entries = entries[1:]
+			}
+			continue
+		}
+		entries = append(entries, convertRecordToEntry(&record))
+	}
+	if brokenLineErr != nil {
+		slog.Warn("outbox journal ends with a broken line, it is skipped", slog.Any("err", brokenLineErr))
+	}
+	return entries, scanner.Err()
+}
+
+// appendRecord adds the record to the end of the journal and syncs it.
+func appendRecord(path string, record *entryRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) //nolint:gomnd // rw only for the owner
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeEntries replaces the journal with the entries.
+func writeEntries(path string, entries []*Entry) error {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, entry := range entries {
+		if err := encoder.Encode(convertEntryToRecord(entry)); err != nil {
+			return err
+		}
+	}
+	return utils.WriteFileAtomic(path, data.Bytes())
+}
+
+func convertEntryToRecord(entry *Entry) *entryRecord {
+	record := &entryRecord{
+		Kind:        entry.Kind,
+		Transaction: nil,
+		MessageID:   entry.MessageID,
+		ChatID:      entry.ChatID,
+		UserID:      entry.UserID,
+	}
+	if entry.Transaction != nil {
+		record.Transaction = &transactionRecord{
+			CreatedAt: entry.Transaction.CreatedAt,
+			MessageID: entry.Transaction.MessageID,
+			ChatID:    entry.Transaction.ChatID,
+			UserID:    entry.Transaction.UserID,
+			Amount:    entry.Transaction.Amount,
+			Category:  entry.Transaction.Category,
+			Tags:      entry.Transaction.Tags,
+			Comment:   entry.Transaction.Comment,
+		}
+	}
+	return record
+}
+
+func convertRecordToEntry(record *entryRecord) *Entry {
+	entry := &Entry{
+		Kind:        record.Kind,
+		Transaction: nil,
+		MessageID:   record.MessageID,
+		ChatID:      record.ChatID,
+		UserID:      record.UserID,
+		Before:      nil,
+	}
+	if record.Transaction != nil {
+		entry.Transaction = &model.Transaction{
+			CreatedAt: record.Transaction.CreatedAt,
+			MessageID: record.Transaction.MessageID,
+			ChatID:    record.Transaction.ChatID,
+			UserID:    record.Transaction.UserID,
+			Amount:    record.Transaction.Amount,
+			Category:  record.Transaction.Category,
+			Tags:      record.Transaction.Tags,
+			Comment:   record.Transaction.Comment,
+		}
+	}
+	return entry
+}
@@ -0,0 +1,252 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/outbox"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+type flakyStorage struct {
+	mu       sync.Mutex
+	down     bool
+	inserted []string
+}
+
+func (s *flakyStorage) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *flakyStorage) insertedMessageIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.inserted...)
+}
+
+func (s *flakyStorage) Insert(_ context.Context, transaction *model.Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return fmt.Errorf("%w: %w", storage.ErrOperationFailed, storage.ErrTemporarilyUnavailable)
+	}
+	s.inserted = append(s.inserted, transaction.MessageID)
+	return nil
+}
+
+func (s *flakyStorage) Update(_ context.Context, _ *model.Transaction) error {
+	return storage.ErrTransactionNotFound
+}
+
+func (s *flakyStorage) DeleteByMessageID(_ context.Context, _ string, _ string) error {
+	return storage.ErrTransactionNotFound
+}
+
+func (s *flakyStorage) Restore(_ context.Context, _ string, _ string) (*model.Transaction, error) {
+	return nil, storage.ErrTransactionNotFound
+}
+
+func (s *flakyStorage) Get(_ context.Context, _ string, _ string) (*model.Transaction, error) {
+	return nil, storage.ErrTransactionNotFound
+}
+
+func (s *flakyStorage) Purge(_ context.Context, _ time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *flakyStorage) List(_ context.Context) ([]*model.Transaction, error) {
+	return nil, nil
+}
+
+func makeInsertEntry(messageID string) *outbox.Entry {
+	return &outbox.Entry{
+		Kind:        outbox.OperationInsert,
+		Transaction: &model.Transaction{CreatedAt: 1710000000, MessageID: messageID, ChatID: "1", UserID: "2", Amount: 9.5, Category: "lunch", Tags: nil, Comment: nil},
+		MessageID:   messageID,
+		ChatID:      "1",
+		UserID:      "2",
+		Before:      nil,
+	}
+}
+
+func TestOutbox_QueuesWhileStorageIsDownAndReplaysInOrder(t *testing.T) {
+	config := &outbox.Config{
+		Path:          filepath.Join(t.TempDir(), "outbox.jsonl"),
+		RetryInterval: 10 * time.Millisecond,
+	}
+	transactionStorage := &flakyStorage{mu: sync.Mutex{}, down: false, inserted: nil}
+
+	o, err := outbox.New(config, transactionStorage)
+	require.NoError(t, err)
+
+	queued, err := o.Submit(context.Background(), makeInsertEntry("1"))
+	require.NoError(t, err)
+	require.False(t, queued)
+
+	transactionStorage.setDown(true)
+	for _, messageID := range []string{"2", "3"} {
+		queued, err = o.Submit(context.Background(), makeInsertEntry(messageID))
+		require.NoError(t, err)
+		require.True(t, queued)
+	}
+
+	transactionStorage.setDown(false)
+	// the storage is back, but the new entry still goes behind the queued ones
+	queued, err = o.Submit(context.Background(), makeInsertEntry("4"))
+	require.NoError(t, err)
+	require.True(t, queued)
+
+	// the queue survives a restart
+	o, err = outbox.New(config, transactionStorage)
+	require.NoError(t, err)
+	require.Equal(t, 3, o.Len())
+
+	var delivered []string
+	var deliveredMu sync.Mutex
+	o.SetDeliveryHandlers(func(_ context.Context, entry *outbox.Entry) {
+		deliveredMu.Lock()
+		defer deliveredMu.Unlock()
+		delivered = append(delivered, entry.MessageID)
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go o.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		deliveredMu.Lock()
+		defer deliveredMu.Unlock()
+		return len(delivered) == 3
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, 0, o.Len())
+	require.Equal(t, []string{"1", "2", "3", "4"}, transactionStorage.insertedMessageIDs())
+
+	deliveredMu.Lock()
+	defer deliveredMu.Unlock()
+	require.Equal(t, []string{"2", "3", "4"}, delivered)
+}
+
+// stallingStorage holds the first insert until released, then fails it as temporarily unavailable.
+type stallingStorage struct {
+	flakyStorage
+	stalled chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (s *stallingStorage) Insert(ctx context.Context, transaction *model.Transaction) error {
+	first := false
+	s.once.Do(func() { first = true })
+	if first {
+		close(s.stalled)
+		<-s.release
+		return fmt.Errorf("%w: %w", storage.ErrOperationFailed, storage.ErrTemporarilyUnavailable)
+	}
+	return s.flakyStorage.Insert(ctx, transaction)
+}
+
+func TestOutbox_EntryDoesNotOvertakeTheSameTransaction(t *testing.T) {
+	config := &outbox.Config{
+		Path:          filepath.Join(t.TempDir(), "outbox.jsonl"),
+		RetryInterval: 10 * time.Millisecond,
+	}
+	transactionStorage := &stallingStorage{
+		flakyStorage: flakyStorage{mu: sync.Mutex{}, down: false, inserted: nil},
+		stalled:      make(chan struct{}),
+		release:      make(chan struct{}),
+		once:         sync.Once{},
+	}
+	o, err := outbox.New(config, transactionStorage)
+	require.NoError(t, err)
+
+	first := make(chan bool, 1)
+	go func() {
+		queued, err := o.Submit(context.Background(), makeInsertEntry("1"))
+		require.NoError(t, err)
+		first <- queued
+	}()
+	<-transactionStorage.stalled
+
+	edit := makeInsertEntry("1")
+	edit.Kind, edit.Transaction.Amount = outbox.OperationUpsert, 12
+	second := make(chan bool, 1)
+	go func() {
+		queued, err := o.Submit(context.Background(), edit)
+		require.NoError(t, err)
+		second <- queued
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.Empty(t, transactionStorage.insertedMessageIDs(), "the edit waits for the insert of the same message")
+
+	close(transactionStorage.release)
+	require.True(t, <-first)
+	require.True(t, <-second)
+	require.Equal(t, 2, o.Len())
+}
+
+func TestOutbox_JournalSkipsDeliveredEntriesAndBrokenTail(t *testing.T) {
+	config := &outbox.Config{
+		Path:          filepath.Join(t.TempDir(), "outbox.jsonl"),
+		RetryInterval: 10 * time.Millisecond,
+	}
+	journal := `{"kind":"insert","message_id":"1","chat_id":"1"}
+{"kind":"insert","message_id":"2","chat_id":"1"}
+{"kind":"ack","message_id":"1","chat_id":"1"}
+{"kind":"ins`
+	require.NoError(t, os.WriteFile(config.Path, []byte(journal), 0o600))
+
+	o, err := outbox.New(config, &flakyStorage{mu: sync.Mutex{}, down: true, inserted: nil})
+	require.NoError(t, err)
+	require.Equal(t, 1, o.Len())
+
+	queued, err := o.Submit(context.Background(), makeInsertEntry("3"))
+	require.NoError(t, err)
+	require.True(t, queued)
+	o, err = outbox.New(config, &flakyStorage{mu: sync.Mutex{}, down: true, inserted: nil})
+	require.NoError(t, err)
+	require.Equal(t, 2, o.Len())
+}
+
+// storedStorage has one transaction stored, the updates are taken.
+type storedStorage struct {
+	flakyStorage
+	stored *model.Transaction
+}
+
+func (s *storedStorage) Get(_ context.Context, _ string, _ string) (*model.Transaction, error) {
+	return s.stored, nil
+}
+
+func (s *storedStorage) Update(_ context.Context, _ *model.Transaction) error {
+	return nil
+}
+
+func TestOutbox_EntryKeepsTheStateBeforeIt(t *testing.T) {
+	config := &outbox.Config{Path: filepath.Join(t.TempDir(), "outbox.jsonl"), RetryInterval: time.Hour}
+	stored := makeInsertEntry("1").Transaction
+	transactionStorage := &storedStorage{flakyStorage: flakyStorage{mu: sync.Mutex{}, down: false, inserted: nil}, stored: stored}
+	o, err := outbox.New(config, transactionStorage)
+	require.NoError(t, err)
+
+	insert := makeInsertEntry("1")
+	_, err = o.Submit(context.Background(), insert)
+	require.NoError(t, err)
+	require.Nil(t, insert.Before, "an insert doesn't look for the previous state")
+
+	upsert := makeInsertEntry("1")
+	upsert.Kind = outbox.OperationUpsert
+	upsert.Transaction.Amount = 95
+	_, err = o.Submit(context.Background(), upsert)
+	require.NoError(t, err)
+	require.Equal(t, stored, upsert.Before)
+}
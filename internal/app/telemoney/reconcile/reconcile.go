@@ -0,0 +1,209 @@
+// Package reconcile picks up the changes made to the stored transactions outside the bot, e.g. by hand in the spreadsheet.
+package reconcile
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeUpdated ChangeKind = "updated"
+	ChangeRemoved ChangeKind = "removed"
+)
+
+// Event is a change of one transaction, Before is nil for ChangeAdded, After is nil for ChangeRemoved.
+type Event struct {
+	Kind   ChangeKind
+	Before *storage.TransactionRecord
+	After  *storage.TransactionRecord
+}
+
+type Config struct {
+	Interval time.Duration // pause between the reads of the source
+}
+
+// Reconciler reads the source periodically and diffs it against the last known snapshot.
+// The writes of the bot itself go through Observe so they don't come back as changes.
+type Reconciler struct {
+	config *Config
+	source storage.TransactionSource
+
+	mu       sync.Mutex
+	snapshot map[model.TransactionKey]*snapshotEntry // the last known states
+	ready    bool                                    // the first read is done
+	handlers []func(context.Context, []*Event)
+}
+
+type snapshotEntry struct {
+	record     *storage.TransactionRecord // nil - the bot removed it
+	observedAt time.Time                  // zero - it came from a read
+}
+
+func New(config *Config, source storage.TransactionSource) *Reconciler {
+	return &Reconciler{
+		config:   config,
+		source:   source,
+		mu:       sync.Mutex{},
+		snapshot: make(map[model.TransactionKey]*snapshotEntry),
+		ready:    false,
+		handlers: nil,
+	}
+}
+
+// AddChangeHandler adds a handler for the changes found by a reconciliation, handlers are called in order.
+func (r *Reconciler) AddChangeHandler(handler func(context.Context, []*Event)) {
+	r.handlers = append(r.handlers, handler)
+}
+
+// Run reconciles every Interval until ctx is done. The first read only takes the snapshot.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := r.Reconcile(ctx)
+		if err != nil {
+			slog.Error("reconciliation failed", slog.Any("err", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile reads the source, emits the changes since the last read and returns them.
+func (r *Reconciler) Reconcile(ctx context.Context) ([]*Event, error) {
+	readStartedAt := time.Now()
+	records, err := r.source.ListRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := r.diff(records, readStartedAt)
+	if len(events) > 0 {
+		slog.Info("transactions changed outside the bot", slog.Int("changes", len(events)))
+		for _, handler := range r.handlers {
+			handler(ctx, events)
+		}
+	}
+	return events, nil
+}
+
+// Observe records a transaction the bot wrote.
+func (r *Reconciler) Observe(transaction *model.Transaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := model.KeyOf(transaction)
+	place := ""
+	if entry, ok := r.snapshot[key]; ok && entry.record != nil {
+		place = entry.record.Place
+	}
+	// the write of a transaction stored without the chat gives it the chat, it is not removed
+	legacyKey := model.TransactionKey{ChatID: "", MessageID: transaction.MessageID}
+	if entry, ok := r.snapshot[legacyKey]; ok && key != legacyKey {
+		if place == "" && entry.record != nil {
+			place = entry.record.Place
+		}
+		delete(r.snapshot, legacyKey)
+	}
+	r.snapshot[key] = &snapshotEntry{
+		record:     &storage.TransactionRecord{Transaction: transaction, Place: place},
+		observedAt: time.Now(),
+	}
+}
+
+// ObserveRemoved records a transaction the bot removed.
+func (r *Reconciler) ObserveRemoved(chatID string, messageID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := model.TransactionKey{ChatID: chatID, MessageID: messageID}
+	legacyKey := model.TransactionKey{ChatID: "", MessageID: messageID}
+	if _, ok := r.snapshot[key]; !ok {
+		if _, ok := r.snapshot[legacyKey]; ok {
+			key = legacyKey
+		}
+	}
+	r.snapshot[key] = &snapshotEntry{record: nil, observedAt: time.Now()}
+}
+
+// diff replaces the snapshot with the records. The bot's writes observed after the read started may be missing
+// in the records, they are kept as they are.
+func (r *Reconciler) diff(records []*storage.TransactionRecord, readStartedAt time.Time) []*Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := make(map[model.TransactionKey]*snapshotEntry, len(records))
+	for _, record := range records {
+		current[model.KeyOf(record.Transaction)] = &snapshotEntry{record: record, observedAt: time.Time{}}
+	}
+
+	var events []*Event
+	for key, entry := range current {
+		known, ok := r.snapshot[key]
+		switch {
+		case ok && known.observedAt.After(readStartedAt):
+			current[key] = known
+		case !ok || known.record == nil:
+			events = append(events, &Event{Kind: ChangeAdded, Before: nil, After: entry.record})
+		case !storage.EqualTransactions(known.record.Transaction, entry.record.Transaction):
+			events = append(events, &Event{Kind: ChangeUpdated, Before: known.record, After: entry.record})
+		}
+	}
+	for key, known := range r.snapshot {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		switch {
+		case known.observedAt.After(readStartedAt):
+			current[key] = known
+		case known.record != nil:
+			events = append(events, &Event{Kind: ChangeRemoved, Before: known.record, After: nil})
+		}
+	}
+
+	r.snapshot = current
+	if !r.ready {
+		// the first read is the baseline
+		r.ready = true
+		return nil
+	}
+
+	slices.SortFunc(events, func(a, b *Event) int {
+		aKey, bKey := eventKey(a), eventKey(b)
+		if aKey.ChatID != bKey.ChatID {
+			return cmp.Compare(aKey.ChatID, bKey.ChatID)
+		}
+		return compareMessageIDs(aKey.MessageID, bKey.MessageID)
+	})
+	return events
+}
+
+func eventKey(event *Event) model.TransactionKey {
+	if event.After != nil {
+		return model.KeyOf(event.After.Transaction)
+	}
+	return model.KeyOf(event.Before.Transaction)
+}
+
+// compareMessageIDs orders numeric ids by the number, the shorter one is the smaller one.
+func compareMessageIDs(a, b string) int {
+	if len(a) != len(b) {
+		return cmp.Compare(len(a), len(b))
+	}
+	return cmp.Compare(a, b)
+}
@@ -0,0 +1,93 @@
+package reconcile_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/reconcile"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+type staticSource struct {
+	mu      sync.Mutex
+	records []*storage.TransactionRecord
+}
+
+func (s *staticSource) set(records ...*storage.TransactionRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = records
+}
+
+func (s *staticSource) ListRecords(_ context.Context) ([]*storage.TransactionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records, nil
+}
+
+func makeRecord(messageID string, amount float64, row string) *storage.TransactionRecord {
+	return &storage.TransactionRecord{
+		Transaction: &model.Transaction{
+			CreatedAt: 1710000000,
+			MessageID: messageID,
+			ChatID:    "7",
+			UserID:    "8",
+			Amount:    amount,
+			Category:  "lunch",
+			Tags:      nil,
+			Comment:   nil,
+		},
+		Place: "transaction row " + row,
+	}
+}
+
+func TestReconciler_EmitsChangesMadeOutsideTheBot(t *testing.T) {
+	source := &staticSource{mu: sync.Mutex{}, records: nil}
+	r := reconcile.New(&reconcile.Config{Interval: time.Minute}, source)
+	var handled []*reconcile.Event
+	r.AddChangeHandler(func(_ context.Context, events []*reconcile.Event) {
+		handled = append(handled, events...)
+	})
+
+	source.set(makeRecord("1", 9.5, "3"), makeRecord("2", 5, "4"))
+	events, err := r.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, events) // the baseline
+
+	// the bot writes go through the observed storage and are not changes
+	r.Observe(makeRecord("3", 7, "").Transaction)
+	source.set(makeRecord("1", 95, "3"), makeRecord("3", 7, "5"), makeRecord("10", 1, "6"))
+
+	events, err = r.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []*reconcile.Event{
+		{Kind: reconcile.ChangeUpdated, Before: makeRecord("1", 9.5, "3"), After: makeRecord("1", 95, "3")},
+		{Kind: reconcile.ChangeRemoved, Before: makeRecord("2", 5, "4"), After: nil},
+		{Kind: reconcile.ChangeAdded, Before: nil, After: makeRecord("10", 1, "6")},
+	}, events)
+	require.Equal(t, events, handled)
+
+	events, err = r.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestReconciler_BotRemovalsAreNotChanges(t *testing.T) {
+	source := &staticSource{mu: sync.Mutex{}, records: nil}
+	r := reconcile.New(&reconcile.Config{Interval: time.Minute}, source)
+
+	source.set(makeRecord("1", 9.5, "3"))
+	_, err := r.Reconcile(context.Background())
+	require.NoError(t, err)
+
+	r.ObserveRemoved("7", "1")
+	source.set() // the removal landed
+	events, err := r.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
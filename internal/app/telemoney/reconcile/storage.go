@@ -0,0 +1,82 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// ObservedStorage passes the successful writes to the reconciler, so it knows them from the changes made by hand.
+type ObservedStorage struct {
+	storage.TransactionStorage
+	reconciler *Reconciler
+}
+
+func NewObservedStorage(transactionStorage storage.TransactionStorage, reconciler *Reconciler) *ObservedStorage {
+	return &ObservedStorage{TransactionStorage: transactionStorage, reconciler: reconciler}
+}
+
+func (s *ObservedStorage) Insert(ctx context.Context, transaction *model.Transaction) error {
+	err := s.TransactionStorage.Insert(ctx, transaction)
+	if err == nil {
+		s.reconciler.Observe(transaction)
+	}
+	return err
+}
+
+func (s *ObservedStorage) Update(ctx context.Context, transaction *model.Transaction) error {
+	err := s.TransactionStorage.Update(ctx, transaction)
+	if err == nil {
+		s.reconciler.Observe(transaction)
+	}
+	return err
+}
+
+func (s *ObservedStorage) DeleteByMessageID(ctx context.Context, chatID string, messageID string) error {
+	err := s.TransactionStorage.DeleteByMessageID(ctx, chatID, messageID)
+	if err == nil {
+		s.reconciler.ObserveRemoved(chatID, messageID)
+	}
+	return err
+}
+
+func (s *ObservedStorage) Restore(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	transaction, err := s.TransactionStorage.Restore(ctx, chatID, messageID)
+	if err == nil {
+		s.reconciler.Observe(transaction)
+	}
+	return transaction, err
+}
+
+// ApplyTo makes a change handler that repeats the changes in another storage.
+func ApplyTo(target storage.TransactionStorage) func(context.Context, []*Event) {
+	return func(ctx context.Context, events []*Event) {
+		for _, event := range events {
+			err := applyEvent(ctx, target, event)
+			if err != nil {
+				slog.Error("can't apply the change to the storage", slog.Any("err", err), slog.Any("event", event))
+			}
+		}
+	}
+}
+
+func applyEvent(ctx context.Context, target storage.TransactionStorage, event *Event) error {
+	switch event.Kind {
+	case ChangeAdded, ChangeUpdated:
+		err := target.Update(ctx, event.After.Transaction)
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			return target.Insert(ctx, event.After.Transaction)
+		}
+		return err
+	case ChangeRemoved:
+		err := target.DeleteByMessageID(ctx, event.Before.Transaction.ChatID, event.Before.Transaction.MessageID)
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Package removals keeps the transactions removed by /remove that /undo can bring back, so they survive a restart.
+package removals
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/utils"
+)
+
+type Removal struct {
+	MessageID string    `json:"message_id"`
+	RemovedAt time.Time `json:"removed_at"`
+}
+
+type Config struct {
+	Path   string        // the file the removals are kept in, "" - in memory only
+	Window time.Duration // how long a removal can be taken back
+}
+
+type Store struct {
+	config *Config
+
+	mu    sync.Mutex
+	chats map[string][]*Removal // chat id -> its removals from the oldest
+}
+
+func New(config *Config) (*Store, error) {
+	chats, err := readChats(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		config: config,
+		mu:     sync.Mutex{},
+		chats:  chats,
+	}, nil
+}
+
+// Add keeps the removal of the transaction of the message, the removals out of the window are forgotten.
+func (s *Store) Add(chatID string, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := s.withinWindowLocked()
+	updated[chatID] = append(updated[chatID], &Removal{MessageID: messageID, RemovedAt: time.Now()})
+	return s.replaceLocked(updated)
+}
+
+// Last returns the last removal of the chat within the window, nil if there is none.
+// It is kept until Drop, so a failed /undo can be tried again.
+func (s *Store) Last(chatID string) *Removal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removals := s.withinWindowLocked()[chatID]
+	if len(removals) == 0 {
+		return nil
+	}
+	return removals[len(removals)-1]
+}
+
+// Drop forgets the removal of the transaction of the message, the removals out of the window are forgotten too.
+func (s *Store) Drop(chatID string, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := s.withinWindowLocked()
+	var kept []*Removal
+	for _, removal := range updated[chatID] {
+		if removal.MessageID != messageID {
+			kept = append(kept, removal)
+		}
+	}
+	if len(kept) == 0 {
+		delete(updated, chatID)
+	} else {
+		updated[chatID] = kept
+	}
+	return s.replaceLocked(updated)
+}
+
+// withinWindowLocked returns a copy of the removals without the ones out of the window.
+func (s *Store) withinWindowLocked() map[string][]*Removal {
+	windowStart := time.Now().Add(-s.config.Window)
+	result := make(map[string][]*Removal, len(s.chats))
+	for chatID, removals := range s.chats {
+		var kept []*Removal
+		for _, removal := range removals {
+			if !removal.RemovedAt.Before(windowStart) {
+				kept = append(kept, removal)
+			}
+		}
+		if len(kept) > 0 {
+			result[chatID] = kept
+		}
+	}
+	return result
+}
+
+// replaceLocked saves the removals before they take effect.
+func (s *Store) replaceLocked(chats map[string][]*Removal) error {
+	err := writeChats(s.config.Path, chats)
+	if err != nil {
+		return err
+	}
+	s.chats = chats
+	return nil
+}
+
+func readChats(path string) (map[string][]*Removal, error) {
+	chats := make(map[string][]*Removal)
+	if path == "" {
+		return chats, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return chats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &chats)
+	if err != nil {
+		return nil, fmt.Errorf("bad removals file %s: %w", path, err)
+	}
+	return chats, nil
+}
+
+func writeChats(path string, chats map[string][]*Removal) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(chats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data)
+}
@@ -0,0 +1,44 @@
+package removals_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/removals"
+)
+
+func TestStore_RemovalsAreKept(t *testing.T) {
+	config := &removals.Config{Path: filepath.Join(t.TempDir(), "removals.json"), Window: time.Hour}
+	store, err := removals.New(config)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Add("-100", "1"))
+	require.NoError(t, store.Add("-100", "2"))
+	require.NoError(t, store.Add("-200", "3"))
+
+	reopened, err := removals.New(config)
+	require.NoError(t, err)
+	require.Equal(t, "2", reopened.Last("-100").MessageID)
+	// the removal stays until it is dropped
+	require.Equal(t, "2", reopened.Last("-100").MessageID)
+	require.NoError(t, reopened.Drop("-100", "2"))
+
+	reopened, err = removals.New(config)
+	require.NoError(t, err)
+	require.Equal(t, "1", reopened.Last("-100").MessageID)
+	require.NoError(t, reopened.Drop("-100", "1"))
+	require.Nil(t, reopened.Last("-100"))
+	require.Equal(t, "3", reopened.Last("-200").MessageID)
+}
+
+func TestStore_ForgetsRemovalsOutOfTheWindow(t *testing.T) {
+	store, err := removals.New(&removals.Config{Path: "", Window: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Add("-100", "1"))
+	time.Sleep(20 * time.Millisecond)
+	require.Nil(t, store.Last("-100"))
+}
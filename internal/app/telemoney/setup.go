@@ -1,25 +1,52 @@
 package telemoney
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/mitrkos/telemoney/internal/app/telemoney/access"
 	"github.com/mitrkos/telemoney/internal/app/telemoney/apihandler"
 	"github.com/mitrkos/telemoney/internal/app/telemoney/apihandler/tgbothandler"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/migrate"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/outbox"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/reconcile"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/removals"
 	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/chatstorage"
 	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/gsheetstorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/mirrorstorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/sqlitestorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/usernames"
 	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
 	parsing "github.com/mitrkos/telemoney/internal/pkg/parser"
 	"github.com/mitrkos/telemoney/internal/pkg/tgbot"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 type Dependencies struct {
 	Config             *Config
 	API                apihandler.MessageHandler
 	TransactionStorage storage.TransactionStorage
+	Ledgers            Ledgers
+	Outbox             *outbox.Outbox
+	Reconciler         *reconcile.Reconciler
 	Parser             *parsing.Parser
+	AccessControl      *access.Control
+	ChatSettings       *chatsettings.Store
+	SpreadsheetLinker  SpreadsheetLinker
+	Removals           *removals.Store
+	UserNames          *usernames.Store
+
+	// the storages with writes queued in the background, see Flush
+	gsheetLedgers   *gsheetLedgers
+	mirroredStorage *mirrorstorage.TransactionStorage
 }
 
-func PrepareDependencies() (*Dependencies, error) {
+func PrepareDependencies(ctx context.Context) (*Dependencies, error) {
 	config, err := readConfig()
 	if err != nil {
 		slog.Error("can't read the config", slog.Any("err", err))
@@ -27,7 +54,26 @@ func PrepareDependencies() (*Dependencies, error) {
 	}
 
 	tgConfig := tgbot.Config{
-		AuthToken: config.TgAuthTokenTest,
+		AuthToken:      config.TgAuthTokenTest,
+		APIServer:      "",
+		UpdatesMode:    tgbot.UpdatesMode(config.TgUpdatesMode),
+		PollingTimeout: config.TgPollingTimeout,
+		Webhook: tgbot.WebhookConfig{
+			ListenAddress: config.TgWebhookAddress,
+			Path:          config.TgWebhookPath,
+			URL:           config.TgWebhookURL,
+			SecretToken:   config.TgWebhookSecret,
+			CertFile:      config.TgWebhookCertFile,
+			KeyFile:       config.TgWebhookKeyFile,
+		},
+		Dispatcher: tgbot.DispatcherConfig{
+			Workers:       config.TgWorkers,
+			QueueSize:     config.TgQueueSize,
+			StatsInterval: config.TgStatsInterval,
+		},
+		DrainTimeout:        config.ShutdownTimeout,
+		OffsetPath:          config.TgOffsetPath,
+		GroupPrefixRequired: config.TgGroupPrefixRequired,
 	}
 	if config.Env == "prod" {
 		tgConfig.AuthToken = config.TgAuthToken
@@ -40,28 +86,310 @@ func PrepareDependencies() (*Dependencies, error) {
 	}
 	tgBotHandler := tgbothandler.New(tgBot)
 
-	gsheetConfig := gsheetclient.Config{
-		AuthToken:     config.GSheetsAuthToken,
-		SpreadsheetID: config.SpreadsheetID,
+	chatSettings, err := newChatSettings(config)
+	if err != nil {
+		return nil, err
 	}
-	gSheetsClient, err := gsheetclient.New(&gsheetConfig)
+	ledgers, err := newGSheetLedgers(ctx, config, chatSettings)
 	if err != nil {
-		slog.Error("can't connect to gsheets", slog.Any("err", err))
 		return nil, err
 	}
+	for _, ledger := range ledgers.all() {
+		err = ledger.CheckSchema(ctx)
+		if err != nil {
+			slog.Error("the transaction sheet is not ready, run `telemoney bootstrap`", slog.Any("err", err))
+			return nil, err
+		}
+	}
+	transactionStorage := ledgers.router
 
-	transactionSheetID := config.TransactionSheetIDTest
-	if config.Env == "prod" {
-		transactionSheetID = config.TransactionSheetID
+	secondaries, err := newSecondaryStorages(config)
+	if err != nil {
+		return nil, err
+	}
+	mirroredStorage := mirrorstorage.New(ctx, newMirrorConfig(config), transactionStorage, secondaries)
+
+	reconciler := reconcile.New(&reconcile.Config{
+		Interval: config.SyncInterval,
+	}, transactionStorage)
+	// the changes made in the spreadsheet by hand go to the secondaries too
+	for _, secondary := range secondaries {
+		reconciler.AddChangeHandler(reconcile.ApplyTo(secondary.Storage))
+	}
+
+	observedStorage := reconcile.NewObservedStorage(mirroredStorage, reconciler)
+	transactionOutbox, err := outbox.New(&outbox.Config{
+		Path:          config.OutboxPath,
+		RetryInterval: config.OutboxRetryInterval,
+	}, observedStorage)
+	if err != nil {
+		slog.Error("can't open the outbox", slog.Any("err", err))
+		return nil, err
 	}
-	transactionStorage := gsheetstorage.New(gSheetsClient, transactionSheetID)
 
 	parser := parsing.New()
 
+	accessControl, err := access.New(&access.Config{
+		Enabled:    config.AccessEnabled,
+		Owners:     config.AccessOwners,
+		Members:    config.AccessMembers,
+		ReadOnly:   config.AccessReadOnly,
+		Chats:      config.AccessChats,
+		GrantsPath: config.AccessGrantsPath,
+	})
+	if err != nil {
+		slog.Error("can't read the access grants", slog.Any("err", err))
+		return nil, err
+	}
+	if !config.AccessEnabled {
+		slog.Warn("access control is disabled, anyone who finds the bot can use it")
+	}
+
+	removalStore, err := removals.New(&removals.Config{Path: config.UndoPath, Window: config.UndoWindow})
+	if err != nil {
+		slog.Error("can't read the removals", slog.Any("err", err))
+		return nil, err
+	}
+
+	userNames, err := usernames.New(&usernames.Config{Path: config.UserNamesPath})
+	if err != nil {
+		slog.Error("can't read the user names", slog.Any("err", err))
+		return nil, err
+	}
+
 	return &Dependencies{
 		Config:             config,
 		API:                tgBotHandler,
-		TransactionStorage: transactionStorage,
+		TransactionStorage: observedStorage,
+		Ledgers:            ledgers,
+		Outbox:             transactionOutbox,
+		Reconciler:         reconciler,
 		Parser:             parser,
+		AccessControl:      accessControl,
+		ChatSettings:       chatSettings,
+		SpreadsheetLinker:  ledgers,
+		Removals:           removalStore,
+		UserNames:          userNames,
+		gsheetLedgers:      ledgers,
+		mirroredStorage:    mirroredStorage,
 	}, nil
 }
+
+// Flush sends the writes queued in the background: the gsheets batches and then the secondary storage writes.
+// It is for the shutdown, after Telemoney.Start returns, and it takes Config.ShutdownTimeout at most.
+func (d *Dependencies) Flush(ctx context.Context) error {
+	timeout := d.Config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, ledger := range d.gsheetLedgers.all() {
+		ledger.Flush(ctx)
+	}
+	err := d.mirroredStorage.Flush(ctx)
+	if err != nil {
+		slog.Error("can't flush the queued writes, run the backfill", slog.Any("err", err))
+		return err
+	}
+	slog.Info("queued writes are flushed")
+	return nil
+}
+
+// Bootstrap sets up the transaction sheets of both envs in the spreadsheet and the sheets of the chat ledgers.
+func Bootstrap(ctx context.Context) error {
+	config, err := readConfig()
+	if err != nil {
+		slog.Error("can't read the config", slog.Any("err", err))
+		return err
+	}
+
+	gSheetsClient, err := newGSheetsClient(ctx, config, config.SpreadsheetID)
+	if err != nil {
+		return err
+	}
+	for _, transactionSheetID := range []string{config.TransactionSheetID, config.TransactionSheetIDTest} {
+		err = gsheetstorage.New(gSheetsClient, &gsheetstorage.Config{
+			TransactionSheetID: transactionSheetID,
+			MonthlyPartitions:  config.GSheetsPartitioned,
+			Location:           config.Location,
+			AmountFormat:       config.GSheetsAmountFormat,
+		}).Bootstrap(ctx)
+		if err != nil {
+			slog.Error("can't set up the transaction sheet", slog.String("sheet", transactionSheetID), slog.Any("err", err))
+			return err
+		}
+	}
+
+	chatSettings, err := newChatSettings(config)
+	if err != nil {
+		return err
+	}
+	ledgers, err := newGSheetLedgers(ctx, config, chatSettings)
+	if err != nil {
+		return err
+	}
+	for chatID, ledger := range ledgers.chatLedgers {
+		err = ledger.Bootstrap(ctx)
+		if err != nil {
+			slog.Error("can't set up the transaction sheet of the chat", slog.String("chatID", chatID), slog.Any("err", err))
+			return err
+		}
+	}
+	return nil
+}
+
+// Backfill copies the transactions from the spreadsheet to the secondary storages, all or the named ones.
+func Backfill(ctx context.Context, names []string) error {
+	config, err := readConfig()
+	if err != nil {
+		slog.Error("can't read the config", slog.Any("err", err))
+		return err
+	}
+	if len(names) > 0 {
+		config.MirrorSecondaries = names
+	}
+
+	transactionStorage, err := newTransactionStorage(ctx, config)
+	if err != nil {
+		return err
+	}
+	secondaries, err := newSecondaryStorages(config)
+	if err != nil {
+		return err
+	}
+	for _, secondary := range secondaries {
+		result, err := mirrorstorage.Backfill(ctx, transactionStorage, secondary.Storage)
+		if err != nil {
+			slog.Error("backfill failed", slog.String("secondary", secondary.Name), slog.Any("err", err))
+			return err
+		}
+		slog.Info("secondary storage is backfilled", slog.String("secondary", secondary.Name), slog.Any("result", result))
+	}
+	return nil
+}
+
+// Migrate copies the transactions between the storages, from and to are "gsheet" or "sqlite".
+func Migrate(ctx context.Context, from string, to string) error {
+	if from == to {
+		return fmt.Errorf("the source and the target are both %q", from)
+	}
+	config, err := readConfig()
+	if err != nil {
+		slog.Error("can't read the config", slog.Any("err", err))
+		return err
+	}
+
+	source, err := newStorage(ctx, config, from)
+	if err != nil {
+		return err
+	}
+	target, err := newStorage(ctx, config, to)
+	if err != nil {
+		return err
+	}
+	result, err := migrate.Migrate(ctx, source, target)
+	if err != nil {
+		slog.Error("migration failed", slog.String("from", from), slog.String("to", to), slog.Any("err", err))
+		return err
+	}
+	slog.Info("transactions are migrated", slog.String("from", from), slog.String("to", to), slog.Any("result", result))
+	return nil
+}
+
+func newStorage(ctx context.Context, config *Config, name string) (storage.TransactionStorage, error) {
+	switch name {
+	case "gsheet":
+		return newTransactionStorage(ctx, config)
+	case "sqlite":
+		return newSQLiteStorage(config)
+	}
+	return nil, fmt.Errorf("unknown storage %q", name)
+}
+
+func newSecondaryStorages(config *Config) ([]mirrorstorage.Secondary, error) {
+	secondaries := make([]mirrorstorage.Secondary, 0, len(config.MirrorSecondaries))
+	for _, name := range config.MirrorSecondaries {
+		switch name {
+		case "sqlite":
+			sqliteStorage, err := newSQLiteStorage(config)
+			if err != nil {
+				return nil, err
+			}
+			secondaries = append(secondaries, mirrorstorage.Secondary{Name: name, Storage: sqliteStorage})
+		default:
+			return nil, fmt.Errorf("unknown secondary storage %q", name)
+		}
+	}
+	return secondaries, nil
+}
+
+func newSQLiteStorage(config *Config) (*sqlitestorage.TransactionStorage, error) {
+	sqliteStorage, err := sqlitestorage.New(&sqlitestorage.Config{
+		Path: config.SQLitePath,
+	})
+	if err != nil {
+		slog.Error("can't open sqlite", slog.Any("err", err))
+		return nil, err
+	}
+	return sqliteStorage, nil
+}
+
+func newMirrorConfig(config *Config) *mirrorstorage.Config {
+	return &mirrorstorage.Config{
+		Consistency:   mirrorstorage.Consistency(config.MirrorConsistency),
+		RetryAttempts: config.MirrorRetryAttempts,
+		RetryInterval: config.MirrorRetryInterval,
+		QueueSize:     config.MirrorQueueSize,
+	}
+}
+
+func newGSheetsClient(ctx context.Context, config *Config, spreadsheetID string) (*gsheetclient.GSheetsClient, error) {
+	gsheetConfig := gsheetclient.Config{
+		CredentialsSource: gsheetclient.CredentialsSource(config.GSheetsCredentials),
+		AuthToken:         config.GSheetsAuthToken,
+		CredentialsFile:   config.GSheetsCredentialsFile,
+		OAuthTokenFile:    config.GSheetsOAuthTokenFile,
+		SpreadsheetID:     spreadsheetID,
+		RequestTimeout:    config.GSheetsRequestTimeout,
+		BatchWindow:       config.GSheetsBatchWindow,
+		BatchMaxSize:      config.GSheetsBatchMaxSize,
+		Retry: gsheetclient.RetryConfig{
+			MaxAttempts:    config.GSheetsRetry.MaxAttempts,
+			InitialBackoff: config.GSheetsRetry.InitialBackoff,
+			MaxBackoff:     config.GSheetsRetry.MaxBackoff,
+		},
+		Endpoint:   "",
+		HTTPClient: nil,
+	}
+	gSheetsClient, err := gsheetclient.New(ctx, &gsheetConfig)
+	if err != nil {
+		slog.Error("can't connect to gsheets", slog.Any("err", err))
+		return nil, err
+	}
+	return gSheetsClient, nil
+}
+
+func newTransactionStorage(ctx context.Context, config *Config) (*chatstorage.TransactionStorage, error) {
+	chatSettings, err := newChatSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	ledgers, err := newGSheetLedgers(ctx, config, chatSettings)
+	if err != nil {
+		return nil, err
+	}
+	return ledgers.router, nil
+}
+
+func newChatSettings(config *Config) (*chatsettings.Store, error) {
+	chatSettings, err := chatsettings.New(&chatsettings.Config{
+		Path: config.ChatSettingsPath,
+	})
+	if err != nil {
+		slog.Error("can't read the chat settings", slog.Any("err", err))
+		return nil, err
+	}
+	return chatSettings, nil
+}
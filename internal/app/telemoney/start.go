@@ -0,0 +1,218 @@
+package telemoney
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/access"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// SpreadsheetLinker moves the transactions of a chat to its own spreadsheet.
+type SpreadsheetLinker interface {
+	LinkSpreadsheet(ctx context.Context, chatID string, spreadsheetID string) error
+}
+
+// defaultOnboardingTimeout is how long the /start conversation waits for an answer if the config doesn't say,
+// then the messages are transactions again.
+const defaultOnboardingTimeout = 10 * time.Minute
+
+const startIntro = `Hi! Send me the expenses, one per message: the amount, the category, the tags in brackets and a comment.
+9.5 lunch
+12,30 taxi (work, airport) late flight
+Edit a message to fix the expense, reply /remove to a message to delete it, /undo brings it back.
+/summary - the month by category, /balance - who paid what in the chat, /history in reply - the changes of an expense, /help - the rest.`
+
+type onboardingStep int
+
+const (
+	onboardingCurrency onboardingStep = iota
+	onboardingTimezone
+	onboardingLocale
+	onboardingSpreadsheet
+)
+
+// onboarding is the /start conversation in a chat: the settings are asked one by one and the answers of the user
+// who started it are not taken for transactions. It is kept in memory, a restart forgets it.
+type onboarding struct {
+	userID     string
+	step       onboardingStep
+	lastStep   onboardingStep // only the owners link a spreadsheet
+	settings   *chatsettings.Settings
+	answeredAt time.Time
+}
+
+// handleStartCommand explains the input and starts asking the chat settings, the read-only users get only
+// the explanation.
+func (t *Telemoney) handleStartCommand(_ context.Context, call *model.CommandToHandle) {
+	msg := call.Message
+	// the newcomers learn how to ask for access
+	err := t.access.Check(msg.ChatID, msg.UserID, access.ActionRead)
+	if err != nil {
+		t.refuse(msg, err)
+		return
+	}
+	t.rememberUserName(msg)
+	t.reply(msg, startIntro)
+	if t.access.Check(msg.ChatID, msg.UserID, access.ActionWrite) != nil {
+		return
+	}
+
+	current := &onboarding{
+		userID:     msg.UserID,
+		step:       onboardingCurrency,
+		lastStep:   onboardingLocale,
+		settings:   t.chatSettings.Get(msg.ChatID),
+		answeredAt: time.Now(),
+	}
+	if t.access.Check(msg.ChatID, msg.UserID, access.ActionManage) == nil {
+		current.lastStep = onboardingSpreadsheet
+	}
+	t.onboardingsMu.Lock()
+	t.onboardings[msg.ChatID] = current
+	t.onboardingsMu.Unlock()
+	t.askOnboardingStep(msg, current)
+}
+
+// handleSkipCommand keeps the setting asked by /start as is and asks the next one.
+func (t *Telemoney) handleSkipCommand(ctx context.Context, call *model.CommandToHandle) {
+	if !t.answerOnboarding(ctx, call.Message) {
+		t.reply(call.Message, "Nothing to skip, /start sets up the chat.")
+	}
+}
+
+// answerOnboarding takes the message as the answer to the /start conversation of the chat,
+// false if there is no conversation for the sender.
+func (t *Telemoney) answerOnboarding(ctx context.Context, msg *model.MessageToHandle) bool {
+	t.onboardingsMu.Lock()
+	current, ok := t.onboardings[msg.ChatID]
+	timeout := t.config.OnboardingTimeout
+	if timeout <= 0 {
+		timeout = defaultOnboardingTimeout
+	}
+	if ok && time.Since(current.answeredAt) > timeout {
+		delete(t.onboardings, msg.ChatID)
+		ok = false
+	}
+	t.onboardingsMu.Unlock()
+	if !ok || current.userID != msg.UserID {
+		return false
+	}
+	current.answeredAt = time.Now()
+
+	problem := t.applyOnboardingAnswer(ctx, msg, current)
+	if problem != "" {
+		t.reply(msg, problem+". Try again or /skip.")
+		return true
+	}
+	if current.step < current.lastStep {
+		current.step++
+		t.askOnboardingStep(msg, current)
+		return true
+	}
+
+	t.onboardingsMu.Lock()
+	delete(t.onboardings, msg.ChatID)
+	t.onboardingsMu.Unlock()
+	err := t.chatSettings.Set(msg.ChatID, current.settings)
+	if err != nil {
+		slog.Error("can't save the chat settings", slog.Any("err", err), slog.String("chatID", msg.ChatID))
+		t.markMessageHandledFailure(msg)
+		return true
+	}
+	t.reply(msg, "All set: "+formatChatSettings(current.settings)+". Send the first expense, like 9.5 lunch.")
+	return true
+}
+
+// applyOnboardingAnswer puts the answer to the current step into the settings, /skip keeps the setting as is.
+// It returns what is wrong with the answer to tell the user, "" if the answer is taken.
+func (t *Telemoney) applyOnboardingAnswer(ctx context.Context, msg *model.MessageToHandle, current *onboarding) string {
+	answer := strings.TrimSpace(msg.Text)
+	if answer == "/skip" || strings.HasPrefix(answer, "/skip@") {
+		return ""
+	}
+
+	var err error
+	switch current.step {
+	case onboardingCurrency:
+		current.settings.Currency, err = chatsettings.ParseCurrency(answer)
+	case onboardingTimezone:
+		current.settings.Timezone, err = chatsettings.ParseTimezone(answer)
+	case onboardingLocale:
+		current.settings.Locale, err = chatsettings.ParseLocale(answer)
+	case onboardingSpreadsheet:
+		var spreadsheetID string
+		spreadsheetID, err = chatsettings.ParseSpreadsheetID(answer)
+		if err != nil {
+			break
+		}
+		ctx, cancel := t.withHandlerTimeout(ctx)
+		defer cancel()
+		err = t.spreadsheetLinker.LinkSpreadsheet(ctx, msg.ChatID, spreadsheetID)
+		if errors.Is(err, errLedgerInConfig) {
+			return "The spreadsheet of this chat is set in the bot config"
+		}
+		if errors.Is(err, errSpreadsheetInUse) {
+			return "This spreadsheet is used by another chat, make a new one"
+		}
+		if err != nil {
+			return "Can't open the spreadsheet, the bot's Google account has to be its editor"
+		}
+		current.settings.SpreadsheetID = spreadsheetID
+	}
+	if err != nil {
+		return "Hmm, " + strings.TrimPrefix(err.Error(), chatsettings.ErrBadSetting.Error()+": ")
+	}
+	return ""
+}
+
+// askOnboardingStep sends the question of the current step, in the groups where the bot takes only the messages
+// addressed to it the answer goes in reply.
+func (t *Telemoney) askOnboardingStep(msg *model.MessageToHandle, current *onboarding) {
+	text := onboardingQuestion(current)
+	if t.config.TgGroupPrefixRequired && chatScope(msg.ChatID) == model.CommandScopeGroup {
+		text += "\nReply to this message with the answer."
+	}
+	t.reply(msg, text)
+}
+
+func onboardingQuestion(current *onboarding) string {
+	settings := current.settings
+	switch current.step {
+	case onboardingCurrency:
+		return "What currency do you use? Like EUR or €. /skip keeps " + orNone(settings.Currency) + "."
+	case onboardingTimezone:
+		return "Your timezone? Like Europe/Berlin. /skip keeps " + orNone(settings.Timezone) + "."
+	case onboardingLocale:
+		return "Your locale for the numbers? Like en or de-DE. /skip keeps " + orNone(settings.Locale) + "."
+	case onboardingSpreadsheet:
+		return "Keep this chat in its own Google spreadsheet? Send its link, the bot's Google account has to be " +
+			"an editor. /skip keeps " + formatSpreadsheet(settings) + "."
+	}
+	return ""
+}
+
+// formatChatSettings makes a line like "currency EUR, timezone Europe/Berlin, locale de, the shared spreadsheet".
+func formatChatSettings(settings *chatsettings.Settings) string {
+	return fmt.Sprintf("currency %s, timezone %s, locale %s, %s",
+		orNone(settings.Currency), orNone(settings.Timezone), orNone(settings.Locale), formatSpreadsheet(settings))
+}
+
+func formatSpreadsheet(settings *chatsettings.Settings) string {
+	if settings.SpreadsheetID == "" {
+		return "the shared spreadsheet"
+	}
+	return "spreadsheet " + settings.SpreadsheetID
+}
+
+func orNone(setting string) string {
+	if setting == "" {
+		return "none"
+	}
+	return setting
+}
@@ -0,0 +1,96 @@
+package telemoney_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+)
+
+const linkedSpreadsheetID = "1AbCdEfGhIjKlMnOpQrStUvWxYz0123456789_-abcd"
+
+func TestOnboarding_AsksTheSettingsOneByOne(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+
+	b.send(groupChatID, ownerID, "/start")
+	require.Contains(t, b.api.lastSent(groupChatID), "What currency")
+	b.send(groupChatID, ownerID, "EUR")
+	require.Contains(t, b.api.lastSent(groupChatID), "timezone")
+	// the others' messages are not answers
+	b.send(groupChatID, memberID, "9.5 lunch")
+	require.Contains(t, b.api.lastSent(groupChatID), "timezone")
+	b.send(groupChatID, ownerID, "Europe/Berlin")
+	require.Contains(t, b.api.lastSent(groupChatID), "locale")
+	b.send(groupChatID, ownerID, "/skip")
+	require.Contains(t, b.api.lastSent(groupChatID), "spreadsheet")
+	b.send(groupChatID, ownerID, "https://docs.google.com/spreadsheets/d/"+linkedSpreadsheetID+"/edit")
+
+	require.Contains(t, b.api.lastSent(groupChatID), "All set")
+	require.Equal(t, map[string]string{groupChatID: linkedSpreadsheetID}, b.linker.linked)
+	require.Equal(t, &chatsettings.Settings{Currency: "EUR", Timezone: "Europe/Berlin", Locale: "", SpreadsheetID: linkedSpreadsheetID},
+		b.chatSettings.Get(groupChatID))
+
+	// the conversation is over, /skip has nothing to skip
+	b.send(groupChatID, ownerID, "/skip")
+	require.Contains(t, b.api.lastSent(groupChatID), "Nothing to skip")
+}
+
+func TestOnboarding_OnlyOwnersLinkASpreadsheet(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+
+	b.send(groupChatID, memberID, "/start")
+	b.send(groupChatID, memberID, "/skip")
+	b.send(groupChatID, memberID, "/skip")
+	b.send(groupChatID, memberID, "de")
+
+	require.Contains(t, b.api.lastSent(groupChatID), "All set")
+	require.Empty(t, b.linker.linked)
+	require.Equal(t, "de", b.chatSettings.Get(groupChatID).Locale)
+}
+
+func TestOnboarding_FailedLinkIsAskedAgain(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+	b.linker.fail[linkedSpreadsheetID] = errors.New("no access")
+
+	b.send(groupChatID, ownerID, "/start")
+	for i := 0; i < 3; i++ {
+		b.send(groupChatID, ownerID, "/skip")
+	}
+	b.send(groupChatID, ownerID, linkedSpreadsheetID)
+	require.Contains(t, b.api.lastSent(groupChatID), "Can't open the spreadsheet")
+	require.Contains(t, b.api.lastSent(groupChatID), "Try again or /skip")
+
+	b.send(groupChatID, ownerID, "/skip")
+	require.Contains(t, b.api.lastSent(groupChatID), "All set")
+	require.Empty(t, b.chatSettings.Get(groupChatID).SpreadsheetID)
+}
+
+func TestOnboarding_TimesOut(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{OnboardingTimeout: 10 * time.Millisecond})
+
+	b.send(groupChatID, ownerID, "/start")
+	time.Sleep(20 * time.Millisecond)
+	sent := b.api.sentCount(groupChatID)
+	msg := b.send(groupChatID, ownerID, "9.5 lunch")
+
+	// the message is an expense again
+	require.Equal(t, sent, b.api.sentCount(groupChatID))
+	transaction, err := b.ledger.Get(context.Background(), groupChatID, msg.MessageID)
+	require.NoError(t, err)
+	require.Equal(t, 9.5, transaction.Amount)
+	require.Empty(t, b.chatSettings.Get(groupChatID).Currency)
+}
+
+func TestOnboarding_AsksForRepliesInPrefixGroups(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{TgGroupPrefixRequired: true})
+
+	b.send(groupChatID, ownerID, "/start")
+	require.Contains(t, b.api.lastSent(groupChatID), "Reply to this message")
+	b.send(ownerID, ownerID, "/start")
+	require.NotContains(t, b.api.lastSent(ownerID), "Reply to this message")
+}
@@ -0,0 +1,249 @@
+// Package chatstorage keeps the transactions of the chats in their own ledgers, e.g. a spreadsheet or a sheet per chat.
+package chatstorage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// Ledger is a storage the transactions of some chats are kept in.
+type Ledger interface {
+	storage.TransactionStorage
+	storage.TransactionSource
+}
+
+// TransactionStorage writes a transaction to the ledger of its chat, the chats without their own ledger and
+// the transactions stored before the chat was kept go to the default one. Reads go over all the ledgers.
+// A chat moved to another ledger takes its transactions with it, see MoveChat.
+type TransactionStorage struct {
+	defaultLedger Ledger
+
+	mu          sync.RWMutex
+	chatLedgers map[string]Ledger        // chat id -> its ledger
+	chatLocks   map[string]*sync.RWMutex // chat id -> held for reading by the writes of the chat, for writing by a move
+}
+
+func New(defaultLedger Ledger, chatLedgers map[string]Ledger) *TransactionStorage {
+	ledgers := make(map[string]Ledger, len(chatLedgers))
+	for chatID, ledger := range chatLedgers {
+		ledgers[chatID] = ledger
+	}
+	return &TransactionStorage{
+		defaultLedger: defaultLedger,
+		mu:            sync.RWMutex{},
+		chatLedgers:   ledgers,
+		chatLocks:     make(map[string]*sync.RWMutex),
+	}
+}
+
+// MoveChat moves the chat to the ledger together with its transactions: they are copied to the ledger, then
+// the chat writes there and the copied transactions are deleted from the ledger it had. The writes of the chat
+// wait for the move. If the copy fails the chat stays where it was, a failed deletion is only logged.
+func (s *TransactionStorage) MoveChat(ctx context.Context, chatID string, ledger Ledger) error {
+	lock := s.chatLock(chatID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	previous := s.ledger(chatID)
+	if previous == ledger {
+		return nil
+	}
+	var moved []*model.Transaction
+	err := storage.ListPages(ctx, previous, storage.SyncPageSize, func(transactions []*model.Transaction) error {
+		for _, transaction := range transactions {
+			if transaction.ChatID != chatID {
+				continue
+			}
+			err := ledger.Insert(ctx, transaction)
+			if err != nil {
+				return err
+			}
+			moved = append(moved, transaction)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.chatLedgers[chatID] = ledger
+	s.mu.Unlock()
+
+	for _, transaction := range moved {
+		err = previous.DeleteByMessageID(ctx, chatID, transaction.MessageID)
+		if err != nil {
+			slog.Warn("can't delete a moved transaction from the previous ledger", slog.String("chatID", chatID),
+				slog.String("messageID", transaction.MessageID), slog.Any("err", err))
+		}
+	}
+	slog.Info("chat is moved to its ledger", slog.String("chatID", chatID), slog.Int("transactions", len(moved)))
+	return nil
+}
+
+func (s *TransactionStorage) Insert(ctx context.Context, transaction *model.Transaction) error {
+	defer s.readLockChat(transaction.ChatID)()
+	return s.ledger(transaction.ChatID).Insert(ctx, transaction)
+}
+
+func (s *TransactionStorage) Update(ctx context.Context, transaction *model.Transaction) error {
+	defer s.readLockChat(transaction.ChatID)()
+	return s.ledger(transaction.ChatID).Update(ctx, transaction)
+}
+
+func (s *TransactionStorage) Get(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	defer s.readLockChat(chatID)()
+	return s.ledger(chatID).Get(ctx, chatID, messageID)
+}
+
+func (s *TransactionStorage) DeleteByMessageID(ctx context.Context, chatID string, messageID string) error {
+	defer s.readLockChat(chatID)()
+	return s.ledger(chatID).DeleteByMessageID(ctx, chatID, messageID)
+}
+
+// Restore restores the transaction in the ledger of the chat. A transaction deleted before the chat was moved
+// is in a ledger the chat had, it is restored there and moved to the ledger of the chat.
+func (s *TransactionStorage) Restore(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	defer s.readLockChat(chatID)()
+	ledger := s.ledger(chatID)
+	transaction, err := ledger.Restore(ctx, chatID, messageID)
+	if !errors.Is(err, storage.ErrTransactionNotFound) {
+		return transaction, err
+	}
+
+	for _, previous := range s.ledgers() {
+		if previous == ledger {
+			continue
+		}
+		transaction, err = previous.Restore(ctx, chatID, messageID)
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = ledger.Insert(ctx, transaction)
+		if err != nil {
+			return nil, err
+		}
+		err = previous.DeleteByMessageID(ctx, chatID, messageID)
+		if err != nil {
+			slog.Warn("can't delete a moved transaction from the previous ledger", slog.String("chatID", chatID),
+				slog.String("messageID", messageID), slog.Any("err", err))
+		}
+		return transaction, nil
+	}
+	return nil, storage.ErrTransactionNotFound
+}
+
+// Purge purges every ledger, the count is the total of the ones purged before an error.
+func (s *TransactionStorage) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	total := 0
+	for _, ledger := range s.ledgers() {
+		purged, err := ledger.Purge(ctx, deletedBefore)
+		total += purged
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (s *TransactionStorage) List(ctx context.Context) ([]*model.Transaction, error) {
+	var result []*model.Transaction
+	for _, ledger := range s.ledgers() {
+		transactions, err := ledger.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, transactions...)
+	}
+	return result, nil
+}
+
+// ListPages lists the ledgers one after another, a page has the transactions of one ledger.
+func (s *TransactionStorage) ListPages(ctx context.Context, size int, page func([]*model.Transaction) error) error {
+	for _, ledger := range s.ledgers() {
+		err := storage.ListPages(ctx, ledger, size, page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TransactionStorage) ListRecords(ctx context.Context) ([]*storage.TransactionRecord, error) {
+	var result []*storage.TransactionRecord
+	for _, ledger := range s.ledgers() {
+		records, err := ledger.ListRecords(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, records...)
+	}
+	return result, nil
+}
+
+// readLockChat holds the chat from moving and returns the release.
+func (s *TransactionStorage) readLockChat(chatID string) func() {
+	lock := s.chatLock(chatID)
+	lock.RLock()
+	return lock.RUnlock
+}
+
+func (s *TransactionStorage) chatLock(chatID string) *sync.RWMutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.chatLocks[chatID]
+	if !ok {
+		lock = &sync.RWMutex{}
+		s.chatLocks[chatID] = lock
+	}
+	return lock
+}
+
+func (s *TransactionStorage) ledger(chatID string) Ledger {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ledger, ok := s.chatLedgers[chatID]
+	if !ok {
+		return s.defaultLedger
+	}
+	return ledger
+}
+
+// ledgers returns the default ledger and then the ones of the chats by the chat id, each once
+// even if several chats share it.
+func (s *TransactionStorage) ledgers() []Ledger {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chatIDs := make([]string, 0, len(s.chatLedgers))
+	for chatID := range s.chatLedgers {
+		chatIDs = append(chatIDs, chatID)
+	}
+	sort.Strings(chatIDs)
+
+	result := []Ledger{s.defaultLedger}
+	for _, chatID := range chatIDs {
+		if !containsLedger(result, s.chatLedgers[chatID]) {
+			result = append(result, s.chatLedgers[chatID])
+		}
+	}
+	return result
+}
+
+func containsLedger(ledgers []Ledger, ledger Ledger) bool {
+	for _, l := range ledgers {
+		if l == ledger {
+			return true
+		}
+	}
+	return false
+}
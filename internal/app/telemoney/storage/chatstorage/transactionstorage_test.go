@@ -0,0 +1,111 @@
+package chatstorage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/chatstorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/sqlitestorage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// sqliteLedger lists the records of the sqlite storage by its name.
+type sqliteLedger struct {
+	*sqlitestorage.TransactionStorage
+	name string
+}
+
+func (l *sqliteLedger) ListRecords(ctx context.Context) ([]*storage.TransactionRecord, error) {
+	transactions, err := l.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*storage.TransactionRecord, 0, len(transactions))
+	for _, transaction := range transactions {
+		records = append(records, &storage.TransactionRecord{Transaction: transaction, Place: l.name})
+	}
+	return records, nil
+}
+
+func newLedger(t *testing.T, name string) *sqliteLedger {
+	s, err := sqlitestorage.New(&sqlitestorage.Config{Path: filepath.Join(t.TempDir(), name+".db")})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return &sqliteLedger{TransactionStorage: s, name: name}
+}
+
+func makeTransaction(chatID string, messageID string, amount float64) *model.Transaction {
+	return &model.Transaction{CreatedAt: 1710000000, MessageID: messageID, ChatID: chatID, UserID: "8", Amount: amount, Category: "lunch", Tags: nil, Comment: nil}
+}
+
+func TestTransactionStorage_RoutesByChat(t *testing.T) {
+	ctx := context.Background()
+	defaultLedger := newLedger(t, "default")
+	familyLedger := newLedger(t, "family")
+	s := chatstorage.New(defaultLedger, map[string]chatstorage.Ledger{
+		"-100": familyLedger,
+		"-200": familyLedger,
+	})
+
+	require.NoError(t, s.Insert(ctx, makeTransaction("7", "1", 9.5)))
+	require.NoError(t, s.Insert(ctx, makeTransaction("-100", "1", 3)))
+	require.NoError(t, s.Insert(ctx, makeTransaction("-200", "2", 4)))
+	require.NoError(t, s.Update(ctx, makeTransaction("-100", "1", 5)))
+
+	transactions, err := defaultLedger.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{makeTransaction("7", "1", 9.5)}, transactions)
+	transactions, err = familyLedger.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{makeTransaction("-100", "1", 5), makeTransaction("-200", "2", 4)}, transactions)
+
+	// the shared ledger is read once
+	records, err := s.ListRecords(ctx)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, "default", records[0].Place)
+
+	require.ErrorIs(t, s.DeleteByMessageID(ctx, "-100", "2"), storage.ErrTransactionNotFound)
+	require.NoError(t, s.DeleteByMessageID(ctx, "-200", "2"))
+	transactions, err = s.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{makeTransaction("7", "1", 9.5), makeTransaction("-100", "1", 5)}, transactions)
+
+	restored, err := s.Restore(ctx, "-200", "2")
+	require.NoError(t, err)
+	require.Equal(t, makeTransaction("-200", "2", 4), restored)
+}
+
+func TestTransactionStorage_MoveChatTakesItsTransactions(t *testing.T) {
+	ctx := context.Background()
+	defaultLedger := newLedger(t, "default")
+	familyLedger := newLedger(t, "family")
+	s := chatstorage.New(defaultLedger, nil)
+
+	require.NoError(t, s.Insert(ctx, makeTransaction("7", "1", 9.5)))
+	require.NoError(t, s.Insert(ctx, makeTransaction("-100", "1", 3)))
+	require.NoError(t, s.Insert(ctx, makeTransaction("-100", "2", 4)))
+	require.NoError(t, s.DeleteByMessageID(ctx, "-100", "2"))
+
+	require.NoError(t, s.MoveChat(ctx, "-100", familyLedger))
+
+	transactions, err := defaultLedger.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{makeTransaction("7", "1", 9.5)}, transactions)
+	transactions, err = familyLedger.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{makeTransaction("-100", "1", 3)}, transactions)
+
+	// the older messages of the chat are edited in its ledger, the one deleted before the move is restored there
+	require.NoError(t, s.Update(ctx, makeTransaction("-100", "1", 5)))
+	restored, err := s.Restore(ctx, "-100", "2")
+	require.NoError(t, err)
+	require.Equal(t, makeTransaction("-100", "2", 4), restored)
+	transactions, err = s.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{makeTransaction("7", "1", 9.5), makeTransaction("-100", "1", 5), makeTransaction("-100", "2", 4)}, transactions)
+}
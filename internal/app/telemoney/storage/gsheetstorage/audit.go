@@ -0,0 +1,235 @@
+package gsheetstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/audit"
+	"github.com/mitrkos/telemoney/internal/model"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+)
+
+// the layout of the audit sheet: headers are in row 1, the records go from row 2
+const (
+	auditHeaderRow       = 1
+	auditFirstDataRow    = 2
+	auditFirstColumn     = "A"
+	auditLastColumn      = "H"
+	auditMessageIDColumn = 1
+	auditChatIDColumn    = 4
+)
+
+var auditHeaders = []interface{}{"at", "message_id", "operation", "trigger", "chat_id", "user_id", "before", "after"}
+
+// AuditLog keeps the audit records in the <transaction sheet>_audit sheet, the transactions are json there.
+type AuditLog struct {
+	gsheetclient *gsheetclient.GSheetsClient
+	sheetID      string
+	location     *time.Location
+
+	readyMu sync.Mutex
+	ready   bool // the sheet and its headers exist
+}
+
+type auditTransaction struct {
+	CreatedAt int64    `json:"created_at"`
+	Amount    float64  `json:"amount"`
+	Category  string   `json:"category"`
+	Tags      []string `json:"tags,omitempty"`
+	Comment   *string  `json:"comment,omitempty"`
+	UserID    string   `json:"user_id,omitempty"`
+}
+
+func newAuditLog(gsheetclient *gsheetclient.GSheetsClient, transactionSheetID string, location *time.Location) *AuditLog {
+	return &AuditLog{
+		gsheetclient: gsheetclient,
+		sheetID:      transactionSheetID + "_audit",
+		location:     location,
+		readyMu:      sync.Mutex{},
+		ready:        false,
+	}
+}
+
+// AuditLog returns the audit log next to the transaction sheet.
+func (trr *TransactionStorage) AuditLog() audit.Log {
+	return trr.auditLog
+}
+
+func (l *AuditLog) Append(ctx context.Context, record *audit.Record) error {
+	err := l.ensureSheet(ctx)
+	if err != nil {
+		return err
+	}
+
+	row, err := convertAuditRecordToDataRow(record, l.location)
+	if err != nil {
+		return err
+	}
+	err = l.gsheetclient.AppendDataToRange(ctx, l.makeDataRange(), row)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	return nil
+}
+
+func (l *AuditLog) ListByMessageID(ctx context.Context, chatID string, messageID string) ([]*audit.Record, error) {
+	err := l.ensureSheet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := l.gsheetclient.ReadRangeUnformatted(ctx, l.makeDataRange())
+	if err != nil {
+		return nil, convertGSheetError(err)
+	}
+
+	var records []*audit.Record
+	for i, row := range rows {
+		if len(row) <= auditMessageIDColumn || formatCell(row[auditMessageIDColumn]) != messageID {
+			continue
+		}
+		if len(row) > auditChatIDColumn && row[auditChatIDColumn] != nil {
+			if rowChatID := formatCell(row[auditChatIDColumn]); rowChatID != "" && rowChatID != chatID {
+				continue
+			}
+		}
+		record, err := convertDataRowToAuditRecord(row, messageID, l.location)
+		if err != nil {
+			slog.Warn("skipping a broken audit row", slog.String("sheet", l.sheetID), slog.Int("row", auditFirstDataRow+i), slog.Any("err", err))
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ensureSheet creates the audit sheet with the headers on the first use.
+func (l *AuditLog) ensureSheet(ctx context.Context) error {
+	l.readyMu.Lock()
+	defer l.readyMu.Unlock()
+	if l.ready {
+		return nil
+	}
+
+	_, err := l.gsheetclient.EnsureSheet(ctx, l.sheetID)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	headerRange := makeSheetRange(l.sheetID,
+		&gsheetclient.A1Location{Column: auditFirstColumn, Row: auditHeaderRow},
+		&gsheetclient.A1Location{Column: auditLastColumn, Row: auditHeaderRow})
+	headers, err := l.gsheetclient.ReadRange(ctx, headerRange)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	if len(headers) == 0 {
+		err = l.gsheetclient.UpdateDataRange(ctx, headerRange, auditHeaders)
+		if err != nil {
+			return convertGSheetError(err)
+		}
+		slog.Info("audit sheet is set up", slog.String("sheet", l.sheetID))
+	}
+	l.ready = true
+	return nil
+}
+
+func (l *AuditLog) makeDataRange() *gsheetclient.A1Range {
+	return makeSheetRange(l.sheetID,
+		&gsheetclient.A1Location{Column: auditFirstColumn, Row: auditFirstDataRow},
+		&gsheetclient.A1Location{Column: auditLastColumn, Row: 0})
+}
+
+func convertAuditRecordToDataRow(record *audit.Record, location *time.Location) ([]interface{}, error) {
+	before, err := marshalAuditTransaction(record.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := marshalAuditTransaction(record.After)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		gsheetclient.ToDateSerial(record.At.In(location)),
+		record.MessageID,
+		string(record.Operation),
+		string(record.Trigger),
+		record.ChatID,
+		record.UserID,
+		before,
+		after,
+	}, nil
+}
+
+func convertDataRowToAuditRecord(dataRow []interface{}, messageID string, location *time.Location) (*audit.Record, error) {
+	cell := func(idx int) string {
+		if idx < len(dataRow) && dataRow[idx] != nil {
+			return formatCell(dataRow[idx])
+		}
+		return ""
+	}
+
+	at, err := strconv.ParseFloat(cell(0), 64)
+	if err != nil {
+		return nil, fmt.Errorf("at: %w", err)
+	}
+	before, err := unmarshalAuditTransaction(cell(6), cell(4), messageID)
+	if err != nil {
+		return nil, fmt.Errorf("before: %w", err)
+	}
+	after, err := unmarshalAuditTransaction(cell(7), cell(4), messageID)
+	if err != nil {
+		return nil, fmt.Errorf("after: %w", err)
+	}
+	return &audit.Record{
+		At:        gsheetclient.FromDateSerial(at, location),
+		MessageID: messageID,
+		Operation: audit.Operation(cell(2)),
+		Trigger:   audit.Trigger(cell(3)),
+		ChatID:    cell(4),
+		UserID:    cell(5),
+		Before:    before,
+		After:     after,
+	}, nil
+}
+
+// marshalAuditTransaction makes the json of the transaction without the chat and the message id, the row has them already.
+func marshalAuditTransaction(transaction *model.Transaction) (string, error) {
+	if transaction == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(&auditTransaction{
+		CreatedAt: transaction.CreatedAt,
+		Amount:    transaction.Amount,
+		Category:  transaction.Category,
+		Tags:      transaction.Tags,
+		Comment:   transaction.Comment,
+		UserID:    transaction.UserID,
+	})
+	return string(data), err
+}
+
+func unmarshalAuditTransaction(data string, chatID string, messageID string) (*model.Transaction, error) {
+	if data == "" {
+		return nil, nil //nolint:nilnil // no transaction is not an error
+	}
+	var transaction auditTransaction
+	err := json.Unmarshal([]byte(data), &transaction)
+	if err != nil {
+		return nil, err
+	}
+	return &model.Transaction{
+		CreatedAt: transaction.CreatedAt,
+		MessageID: messageID,
+		ChatID:    chatID,
+		UserID:    transaction.UserID,
+		Amount:    transaction.Amount,
+		Category:  transaction.Category,
+		Tags:      transaction.Tags,
+		Comment:   transaction.Comment,
+	}, nil
+}
@@ -0,0 +1,45 @@
+package gsheetstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/audit"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/gsheetstorage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+func TestAuditLog(t *testing.T) {
+	gsc, server := newTestClient(t)
+	auditLog := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: "transaction", MonthlyPartitions: false}).AuditLog()
+
+	inserted := &model.Transaction{CreatedAt: 1710000000, MessageID: "1", ChatID: "10", UserID: "20", Amount: 9.5, Category: "lunch", Tags: []string{"a"}, Comment: nil}
+	updated := &model.Transaction{CreatedAt: 1710000000, MessageID: "1", ChatID: "10", UserID: "20", Amount: 95, Category: "lunch", Tags: nil, Comment: makeStringPtrInPlace("food")}
+	otherChat := &model.Transaction{CreatedAt: 1710000000, MessageID: "1", ChatID: "11", UserID: "21", Amount: 3, Category: "coffee", Tags: nil, Comment: nil}
+	records := []*audit.Record{
+		{At: time.Unix(1710000001, 0), MessageID: "1", Operation: audit.OperationInsert, Trigger: audit.TriggerMessage, ChatID: "10", UserID: "20", Before: nil, After: inserted},
+		{At: time.Unix(1710000002, 0), MessageID: "2", Operation: audit.OperationDelete, Trigger: audit.TriggerSync, ChatID: "", UserID: "", Before: inserted, After: nil},
+		{At: time.Unix(1710000003, 0), MessageID: "1", Operation: audit.OperationUpdate, Trigger: audit.TriggerEdit, ChatID: "10", UserID: "20", Before: inserted, After: updated},
+		{At: time.Unix(1710000004, 0), MessageID: "1", Operation: audit.OperationInsert, Trigger: audit.TriggerMessage, ChatID: "11", UserID: "21", Before: nil, After: otherChat},
+	}
+	for _, record := range records {
+		require.NoError(t, auditLog.Append(context.Background(), record))
+	}
+
+	rows := server.Rows("transaction_audit")
+	require.Equal(t, []interface{}{"at", "message_id", "operation", "trigger", "chat_id", "user_id", "before", "after"}, rows[0])
+	require.Len(t, rows, 5)
+
+	history, err := auditLog.ListByMessageID(context.Background(), "10", "1")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	for i, record := range []*audit.Record{records[0], records[2]} {
+		require.True(t, record.At.Equal(history[i].At))
+		history[i].At = record.At
+		require.Equal(t, record, history[i])
+	}
+	require.Equal(t, updated, audit.LastState(history))
+}
@@ -0,0 +1,134 @@
+package gsheetstorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// rowIndex keeps where the rows of the transactions that are not deleted are, so a write doesn't read
+// the whole sheet to find its row. The rows written before the chat was kept are under the key without the chat id.
+type rowIndex struct {
+	mu         sync.Mutex
+	loaded     bool
+	generation uint64 // counts the loads
+	rows       map[model.TransactionKey]*rowRef
+	inserting  map[model.TransactionKey]chan struct{} // the keys being inserted, closed when the insert is done
+}
+
+type rowRef struct {
+	sheetID string
+	row     int    // 0 - appended, the append doesn't tell the row
+	changed uint64 // the generation of the loads the row was changed in, 0 - read by a load
+}
+
+func newRowIndex() *rowIndex {
+	return &rowIndex{
+		mu:         sync.Mutex{},
+		loaded:     false,
+		generation: 0,
+		rows:       make(map[model.TransactionKey]*rowRef),
+		inserting:  make(map[model.TransactionKey]chan struct{}),
+	}
+}
+
+// lockInsert waits for the insert of the key running, if any, and returns the release of the key for the caller,
+// so two inserts of the key don't both append a row.
+func (ri *rowIndex) lockInsert(ctx context.Context, key model.TransactionKey) (func(), error) {
+	for {
+		ri.mu.Lock()
+		done, ok := ri.inserting[key]
+		if !ok {
+			done = make(chan struct{})
+			ri.inserting[key] = done
+			ri.mu.Unlock()
+			return func() {
+				ri.mu.Lock()
+				delete(ri.inserting, key)
+				ri.mu.Unlock()
+				close(done)
+			}, nil
+		}
+		ri.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lookup returns the row of the key, the row of the key without the chat id if the chat has none.
+// ok is false if the index is not loaded.
+func (ri *rowIndex) lookup(chatID string, messageID string) (ref *rowRef, key model.TransactionKey, ok bool) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	if !ri.loaded {
+		return nil, model.TransactionKey{}, false
+	}
+	key = model.TransactionKey{ChatID: chatID, MessageID: messageID}
+	if ref, found := ri.rows[key]; found {
+		return ref, key, true
+	}
+	legacyKey := model.TransactionKey{ChatID: "", MessageID: messageID}
+	if ref, found := ri.rows[legacyKey]; found {
+		return ref, legacyKey, true
+	}
+	return nil, key, true
+}
+
+// startLoad returns the generation of the load starting, the rows appended before it are in what it reads.
+func (ri *rowIndex) startLoad() uint64 {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.generation++
+	return ri.generation
+}
+
+// load replaces the rows with the ones read, the rows changed since the load started are kept as they are.
+func (ri *rowIndex) load(generation uint64, rows []*transactionRow) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	loaded := make(map[model.TransactionKey]*rowRef, len(rows))
+	for _, row := range rows {
+		key := model.TransactionKey{ChatID: row.chatID, MessageID: row.messageID}
+		if _, ok := loaded[key]; ok || row.deleted {
+			continue
+		}
+		loaded[key] = &rowRef{sheetID: row.sheetID, row: row.row, changed: 0}
+	}
+	for key, ref := range ri.rows {
+		if ref.changed >= generation {
+			loaded[key] = ref
+		}
+	}
+	ri.rows = loaded
+	ri.loaded = true
+}
+
+// appended keeps that the row of the key is somewhere in the sheet, an append that failed might have written it too.
+func (ri *rowIndex) appended(key model.TransactionKey, sheetID string) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.rows[key] = &rowRef{sheetID: sheetID, row: 0, changed: ri.generation}
+}
+
+func (ri *rowIndex) set(key model.TransactionKey, sheetID string, row int) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.rows[key] = &rowRef{sheetID: sheetID, row: row, changed: ri.generation}
+}
+
+func (ri *rowIndex) remove(key model.TransactionKey) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	delete(ri.rows, key)
+}
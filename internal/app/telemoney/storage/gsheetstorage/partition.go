@@ -0,0 +1,107 @@
+package gsheetstorage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+const partitionSuffixLayout = "2006_01"
+
+// partitionSheetID returns the name of the partition sheet for the unix time, e.g. transaction_2026_10.
+func (trr *TransactionStorage) partitionSheetID(unixTime int64) string {
+	return trr.config.TransactionSheetID + "_" + time.Unix(unixTime, 0).In(trr.location).Format(partitionSuffixLayout)
+}
+
+func (trr *TransactionStorage) isPartitionSheetID(sheetID string) bool {
+	suffix, ok := strings.CutPrefix(sheetID, trr.config.TransactionSheetID+"_")
+	if !ok {
+		return false
+	}
+	_, err := time.Parse(partitionSuffixLayout, suffix)
+	return err == nil
+}
+
+// sheetForInsert returns the sheet the transaction goes to, a missing partition is created.
+// The errors are storage errors already.
+func (trr *TransactionStorage) sheetForInsert(ctx context.Context, transaction *model.Transaction) (string, error) {
+	if !trr.config.MonthlyPartitions {
+		return trr.config.TransactionSheetID, nil
+	}
+
+	sheetID := trr.partitionSheetID(transaction.CreatedAt)
+
+	// held while the partition is created, so concurrent inserts don't create it twice
+	trr.partitionsMu.Lock()
+	defer trr.partitionsMu.Unlock()
+
+	if trr.partitions[sheetID] {
+		return sheetID, nil
+	}
+	partitions, err := trr.listPartitionsLocked(ctx)
+	if err != nil {
+		return "", convertGSheetError(err)
+	}
+	if !slices.Contains(partitions, sheetID) {
+		err = trr.bootstrapSheet(ctx, sheetID)
+		if err != nil {
+			return "", err
+		}
+		trr.partitions[sheetID] = true
+	}
+	return sheetID, nil
+}
+
+// sheetsForLookup returns the sheets to search a transaction in, in order: the partitions from the newest,
+// then the unpartitioned sheet with the rows written before partitioning.
+func (trr *TransactionStorage) sheetsForLookup(ctx context.Context) ([]string, error) {
+	if !trr.config.MonthlyPartitions {
+		return []string{trr.config.TransactionSheetID}, nil
+	}
+
+	partitions, err := trr.listPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(partitions)+1)
+	result = append(result, partitions...)
+
+	legacy, err := trr.findSheet(ctx, trr.config.TransactionSheetID)
+	if err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		result = append(result, trr.config.TransactionSheetID)
+	}
+	return result, nil
+}
+
+// listPartitions returns the existing partition sheets from the newest.
+func (trr *TransactionStorage) listPartitions(ctx context.Context) ([]string, error) {
+	trr.partitionsMu.Lock()
+	defer trr.partitionsMu.Unlock()
+	return trr.listPartitionsLocked(ctx)
+}
+
+func (trr *TransactionStorage) listPartitionsLocked(ctx context.Context) ([]string, error) {
+	sheetList, err := trr.sheetList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []string
+	for _, sheet := range sheetList {
+		if trr.isPartitionSheetID(sheet.Title) {
+			partitions = append(partitions, sheet.Title)
+			trr.partitions[sheet.Title] = true
+		}
+	}
+	// the suffix sorts like the date
+	slices.Sort(partitions)
+	slices.Reverse(partitions)
+	return partitions, nil
+}
@@ -0,0 +1,97 @@
+package gsheetstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/gsheetstorage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+func TestTransactionStorage_MonthlyPartitions(t *testing.T) {
+	gsc, server := newTestClient(t)
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: testSheetID, MonthlyPartitions: true})
+
+	march := &model.Transaction{
+		CreatedAt: 1710000000, // 2024-03-09
+		MessageID: "200",
+		ChatID:    testChatID,
+		UserID:    testUserID,
+		Amount:    9.5,
+		Category:  "lunch",
+		Tags:      nil,
+		Comment:   nil,
+	}
+	april := &model.Transaction{
+		CreatedAt: 1712000000, // 2024-04-01
+		MessageID: "201",
+		ChatID:    testChatID,
+		UserID:    testUserID,
+		Amount:    3,
+		Category:  "coffee",
+		Tags:      nil,
+		Comment:   nil,
+	}
+	require.NoError(t, trr.Insert(context.Background(), march))
+	require.NoError(t, trr.Insert(context.Background(), april))
+
+	marchRows := server.Rows(testSheetID + "_2024_03")
+	require.Len(t, marchRows, 3)
+	require.Equal(t, "message_id", marchRows[1][1])
+	require.Equal(t, float64(200), marchRows[2][1])
+	require.Len(t, server.Rows(testSheetID+"_2024_04"), 3)
+	require.Len(t, server.Rows(testSheetID), 27) // the unpartitioned sheet isn't written to
+
+	april.Amount = 4
+	require.NoError(t, trr.Update(context.Background(), april))
+	require.Equal(t, float64(4), server.Rows(testSheetID + "_2024_04")[2][2])
+
+	// a delete has no date, the partitions are scanned from the newest
+	require.NoError(t, trr.DeleteByMessageID(context.Background(), testChatID, "200"))
+	require.IsType(t, float64(0), server.Rows(testSheetID + "_2024_03")[2][6]) // deleted_at
+
+	// old messages are still in the unpartitioned sheet
+	require.NoError(t, trr.DeleteByMessageID(context.Background(), testChatID, "95"))
+	require.IsType(t, float64(0), server.Rows(testSheetID)[8][6])
+
+	restored, err := trr.Restore(context.Background(), testChatID, "200")
+	require.NoError(t, err)
+	require.Equal(t, march, restored)
+
+	require.ErrorIs(t, trr.DeleteByMessageID(context.Background(), testChatID, "404"), storage.ErrTransactionNotFound)
+	require.NoError(t, trr.CheckSchema(context.Background()))
+}
+
+func TestTransactionStorage_SheetListIsReadOnce(t *testing.T) {
+	gsc, server := newTestClient(t)
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: testSheetID, MonthlyPartitions: true})
+
+	transaction := &model.Transaction{
+		CreatedAt: 1710000000, // 2024-03-09
+		MessageID: "200",
+		ChatID:    testChatID,
+		UserID:    testUserID,
+		Amount:    9.5,
+		Category:  "lunch",
+		Tags:      nil,
+		Comment:   nil,
+	}
+	require.NoError(t, trr.Insert(context.Background(), transaction))
+	require.NoError(t, trr.Update(context.Background(), transaction)) // reads the sheets with the new partition
+	afterInsert := server.RequestCount("get")
+
+	for i := 0; i < 3; i++ {
+		transaction.Amount++
+		require.NoError(t, trr.Update(context.Background(), transaction))
+	}
+	require.Equal(t, afterInsert, server.RequestCount("get"), "the lookups use the known sheets")
+
+	// the partition of a new month is created and the lookups see it
+	transaction.CreatedAt, transaction.MessageID = 1712000000, "201" // 2024-04-01
+	require.NoError(t, trr.Insert(context.Background(), transaction))
+	require.NoError(t, trr.DeleteByMessageID(context.Background(), testChatID, "201"))
+	require.IsType(t, float64(0), server.Rows(testSheetID + "_2024_04")[2][6]) // deleted_at
+}
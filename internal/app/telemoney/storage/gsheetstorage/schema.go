@@ -0,0 +1,302 @@
+package gsheetstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+)
+
+var ErrSchemaMismatch = errors.New("transaction sheet doesn't match the schema")
+
+// the layout of the transaction sheet: row 1 is free for the user, headers are in row 2, the data goes from row 3
+const (
+	transactionHeaderRow       = 2
+	transactionFirstDataRow    = 3
+	transactionFirstColumn     = "A"
+	transactionLastColumn      = "I"
+	transactionDeletedAtColumn = "G"
+	transactionMessageIDIdx    = 1
+	transactionDeletedAtIdx    = 6
+	transactionChatIDIdx       = 7
+	transactionUserIDIdx       = 8
+	transactionColumns         = 9
+)
+
+type columnSchema struct {
+	column     string
+	header     string
+	formatType string // sheets NumberFormat type, "" - the format is not set
+	pattern    string
+}
+
+const defaultAmountFormat = "#,##0.00"
+
+func (trr *TransactionStorage) transactionSheetSchema() []columnSchema {
+	amountFormat := trr.config.AmountFormat
+	if amountFormat == "" {
+		amountFormat = defaultAmountFormat
+	}
+	return []columnSchema{
+		{column: "A", header: "created_at", formatType: "DATE_TIME", pattern: "yyyy-mm-dd hh:mm:ss"},
+		{column: "B", header: "message_id", formatType: "NUMBER", pattern: "0"},
+		{column: "C", header: "amount", formatType: "NUMBER", pattern: amountFormat},
+		{column: "D", header: "category", formatType: "", pattern: ""},
+		{column: "E", header: "tags", formatType: "TEXT", pattern: ""},
+		{column: "F", header: "comment", formatType: "TEXT", pattern: ""},
+		{column: "G", header: "deleted_at", formatType: "DATE_TIME", pattern: "yyyy-mm-dd hh:mm:ss"},
+		{column: "H", header: "chat_id", formatType: "NUMBER", pattern: "0"},
+		{column: "I", header: "user_id", formatType: "NUMBER", pattern: "0"},
+	}
+}
+
+// Bootstrap creates the transaction sheet if it's missing, fills in the headers, freezes the header rows
+// and sets the column formats. With partitions it sets up the existing ones and the one of the current month.
+// It is safe to run on sheets that are already set up.
+func (trr *TransactionStorage) Bootstrap(ctx context.Context) error {
+	if !trr.config.MonthlyPartitions {
+		return trr.bootstrapSheet(ctx, trr.config.TransactionSheetID)
+	}
+
+	partitions, err := trr.listPartitions(ctx)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	currentPartition := trr.partitionSheetID(time.Now().Unix())
+	if !slices.Contains(partitions, currentPartition) {
+		partitions = append(partitions, currentPartition)
+	}
+	for _, sheetID := range partitions {
+		err = trr.bootstrapSheet(ctx, sheetID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSchema checks that the transaction sheets exist and have the expected headers.
+// The columns missing at the end, the ones added to the schema after the sheet was set up, are added on the way.
+// Partitions are created on the first insert so having none of them is fine.
+func (trr *TransactionStorage) CheckSchema(ctx context.Context) error {
+	if !trr.config.MonthlyPartitions {
+		return trr.checkSheetSchema(ctx, trr.config.TransactionSheetID)
+	}
+
+	partitions, err := trr.listPartitions(ctx)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	for _, sheetID := range partitions {
+		err = trr.checkSheetSchema(ctx, sheetID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (trr *TransactionStorage) bootstrapSheet(ctx context.Context, sheetID string) error {
+	sheet, err := trr.findSheet(ctx, sheetID)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	if sheet == nil {
+		sheet, err = trr.gsheetclient.AddSheet(ctx, sheetID)
+		trr.forgetSheets()
+		if err != nil {
+			return convertGSheetError(err)
+		}
+		slog.Info("transaction sheet created", slog.String("sheet", sheetID))
+	}
+
+	headers, err := trr.readHeaders(ctx, sheetID)
+	if err != nil {
+		return err
+	}
+	missing, conflicts := trr.compareHeaders(headers)
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrSchemaMismatch, sheetID, strings.Join(conflicts, ", "))
+	}
+	if missing > 0 {
+		err = trr.gsheetclient.UpdateDataRange(ctx, makeTransactionHeaderRange(sheetID), trr.makeHeaderRow())
+		if err != nil {
+			return convertGSheetError(err)
+		}
+	}
+
+	requests := []*sheets.Request{gsheetclient.MakeFreezeRowsRequest(sheet.SheetID, transactionHeaderRow)}
+	for _, column := range trr.transactionSheetSchema() {
+		if column.formatType == "" {
+			continue
+		}
+		requests = append(requests, gsheetclient.MakeColumnNumberFormatRequest(
+			sheet.SheetID, column.column, transactionFirstDataRow, column.formatType, column.pattern))
+	}
+	_, err = trr.gsheetclient.BatchUpdate(ctx, requests)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+
+	slog.Info("transaction sheet is set up", slog.String("sheet", sheetID))
+	return nil
+}
+
+func (trr *TransactionStorage) checkSheetSchema(ctx context.Context, sheetID string) error {
+	sheet, err := trr.findSheet(ctx, sheetID)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	if sheet == nil {
+		return fmt.Errorf("%w: no sheet %q", ErrSchemaMismatch, sheetID)
+	}
+
+	headers, err := trr.readHeaders(ctx, sheetID)
+	if err != nil {
+		return err
+	}
+	missing, conflicts := trr.compareHeaders(headers)
+	if missing > 0 && (len(conflicts) > 0 || !trr.onlyTrailingMissing(headers, missing)) {
+		conflicts = append(conflicts, fmt.Sprintf("%d headers are missing", missing))
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrSchemaMismatch, sheetID, strings.Join(conflicts, ", "))
+	}
+	if missing > 0 {
+		return trr.addTrailingColumns(ctx, sheet, missing)
+	}
+	return nil
+}
+
+// onlyTrailingMissing tells if the missing headers are the last ones of the schema and some of them are there:
+// an empty header row is a sheet that is not set up.
+func (trr *TransactionStorage) onlyTrailingMissing(headers []interface{}, missing int) bool {
+	present := 0
+	for i := 0; i < len(headers) && i < transactionColumns; i++ {
+		if strings.TrimSpace(fmt.Sprint(headers[i])) != "" {
+			present = i + 1
+		}
+	}
+	return present > 0 && present+missing == transactionColumns
+}
+
+// addTrailingColumns fills in the headers and the formats of the last missing columns of the schema.
+func (trr *TransactionStorage) addTrailingColumns(ctx context.Context, sheet *gsheetclient.SheetProperties, missing int) error {
+	err := trr.gsheetclient.UpdateDataRange(ctx, makeTransactionHeaderRange(sheet.Title), trr.makeHeaderRow())
+	if err != nil {
+		return convertGSheetError(err)
+	}
+
+	schema := trr.transactionSheetSchema()
+	added := schema[len(schema)-missing:]
+	var requests []*sheets.Request
+	for _, column := range added {
+		if column.formatType == "" {
+			continue
+		}
+		requests = append(requests, gsheetclient.MakeColumnNumberFormatRequest(
+			sheet.SheetID, column.column, transactionFirstDataRow, column.formatType, column.pattern))
+	}
+	if len(requests) > 0 {
+		_, err = trr.gsheetclient.BatchUpdate(ctx, requests)
+		if err != nil {
+			return convertGSheetError(err)
+		}
+	}
+
+	slog.Warn("transaction sheet columns added", slog.String("sheet", sheet.Title),
+		slog.String("from", added[0].column), slog.String("to", added[len(added)-1].column))
+	return nil
+}
+
+func (trr *TransactionStorage) findSheet(ctx context.Context, sheetID string) (*gsheetclient.SheetProperties, error) {
+	sheetList, err := trr.sheetList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sheet := range sheetList {
+		if sheet.Title == sheetID {
+			return sheet, nil
+		}
+	}
+	return nil, nil //nolint:nilnil // no sheet is not an error
+}
+
+// sheetList returns the sheets of the spreadsheet, they are read once and again only after a sheet is added.
+// A sheet removed by hand is noticed after a restart.
+func (trr *TransactionStorage) sheetList(ctx context.Context) ([]*gsheetclient.SheetProperties, error) {
+	trr.sheetsMu.Lock()
+	defer trr.sheetsMu.Unlock()
+
+	if trr.sheets == nil {
+		sheetList, err := trr.gsheetclient.GetSheets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		trr.sheets = sheetList
+	}
+	return trr.sheets, nil
+}
+
+// forgetSheets makes the next sheetList read the sheets again.
+func (trr *TransactionStorage) forgetSheets() {
+	trr.sheetsMu.Lock()
+	defer trr.sheetsMu.Unlock()
+	trr.sheets = nil
+}
+
+func (trr *TransactionStorage) readHeaders(ctx context.Context, sheetID string) ([]interface{}, error) {
+	values, err := trr.gsheetclient.ReadRange(ctx, makeTransactionHeaderRange(sheetID))
+	if err != nil {
+		return nil, convertGSheetError(err)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values[0], nil
+}
+
+// compareHeaders returns how many headers are empty and which ones differ from the schema.
+func (trr *TransactionStorage) compareHeaders(headers []interface{}) (int, []string) {
+	missing := 0
+	var conflicts []string
+	for i, column := range trr.transactionSheetSchema() {
+		header := ""
+		if i < len(headers) {
+			header = strings.TrimSpace(fmt.Sprint(headers[i]))
+		}
+		switch {
+		case header == "":
+			missing++
+		case !strings.EqualFold(header, column.header):
+			conflicts = append(conflicts, fmt.Sprintf("column %s is %q, expected %q", column.column, header, column.header))
+		}
+	}
+	return missing, conflicts
+}
+
+func (trr *TransactionStorage) makeHeaderRow() []interface{} {
+	schema := trr.transactionSheetSchema()
+	headerRow := make([]interface{}, 0, len(schema))
+	for _, column := range schema {
+		headerRow = append(headerRow, column.header)
+	}
+	return headerRow
+}
+
+func makeTransactionHeaderRange(sheetID string) *gsheetclient.A1Range {
+	return makeSheetRange(sheetID, &gsheetclient.A1Location{
+		Column: transactionFirstColumn,
+		Row:    transactionHeaderRow,
+	}, &gsheetclient.A1Location{
+		Column: transactionLastColumn,
+		Row:    transactionHeaderRow,
+	})
+}
@@ -0,0 +1,55 @@
+package gsheetstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/gsheetstorage"
+)
+
+func TestTransactionStorage_Bootstrap(t *testing.T) {
+	gsc, server := newTestClient(t)
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: "transaction", MonthlyPartitions: false})
+
+	require.ErrorIs(t, trr.CheckSchema(context.Background()), gsheetstorage.ErrSchemaMismatch)
+
+	require.NoError(t, trr.Bootstrap(context.Background()))
+	require.NoError(t, trr.Bootstrap(context.Background())) // nothing changes on the second run
+	require.NoError(t, trr.CheckSchema(context.Background()))
+
+	rows := server.Rows("transaction")
+	require.Len(t, rows, 2)
+	require.Equal(t, []interface{}{"created_at", "message_id", "amount", "category", "tags", "comment", "deleted_at", "chat_id", "user_id"}, rows[1])
+	require.Equal(t, int64(2), server.SheetProperties("transaction").GridProperties.FrozenRowCount)
+}
+
+func TestTransactionStorage_CheckSchemaConflict(t *testing.T) {
+	gsc, server := newTestClient(t)
+	server.SetRows("transaction", [][]interface{}{nil, {"date", "message_id"}})
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: "transaction", MonthlyPartitions: false})
+
+	require.ErrorIs(t, trr.Bootstrap(context.Background()), gsheetstorage.ErrSchemaMismatch)
+	require.Equal(t, []interface{}{"date", "message_id"}, server.Rows("transaction")[1])
+}
+
+func TestTransactionStorage_CheckSchemaAddsTrailingColumns(t *testing.T) {
+	gsc, server := newTestClient(t)
+	oldHeaders := []interface{}{"created_at", "message_id", "amount", "category", "tags", "comment", "deleted_at"}
+	server.SetRows("transaction", [][]interface{}{nil, oldHeaders})
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: "transaction", MonthlyPartitions: false})
+
+	require.NoError(t, trr.CheckSchema(context.Background()))
+	require.Equal(t, append(oldHeaders, "chat_id", "user_id"), server.Rows("transaction")[1])
+}
+
+func TestTransactionStorage_CheckSchemaRejectsMissingColumnsInTheMiddle(t *testing.T) {
+	gsc, server := newTestClient(t)
+	headers := []interface{}{"created_at", "", "amount", "category", "tags", "comment", "deleted_at"}
+	server.SetRows("transaction", [][]interface{}{nil, headers})
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: "transaction", MonthlyPartitions: false})
+
+	require.ErrorIs(t, trr.CheckSchema(context.Background()), gsheetstorage.ErrSchemaMismatch)
+	require.Len(t, server.Rows("transaction")[1], len(headers), "the headers are left as they are")
+}
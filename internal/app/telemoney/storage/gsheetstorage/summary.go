@@ -0,0 +1,68 @@
+package gsheetstorage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/summary"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+)
+
+// WriteSummary replaces the content of the summary sheet (<transaction sheet>_summary) with the summary.
+func (trr *TransactionStorage) WriteSummary(ctx context.Context, s *summary.Summary) error {
+	sheet, err := trr.gsheetclient.EnsureSheet(ctx, trr.summarySheetID())
+	if err != nil {
+		return convertGSheetError(err)
+	}
+
+	_, err = trr.gsheetclient.BatchUpdate(ctx, []*sheets.Request{
+		gsheetclient.MakeClearSheetRequest(sheet.SheetID),
+		gsheetclient.MakeWriteRowsRequest(sheet.SheetID, 1, "A", makeSummaryRows(s)),
+	})
+	if err != nil {
+		return convertGSheetError(err)
+	}
+
+	slog.Info("summary sheet is updated", slog.String("sheet", sheet.Title))
+	return nil
+}
+
+func (trr *TransactionStorage) summarySheetID() string {
+	return trr.config.TransactionSheetID + "_summary"
+}
+
+// makeSummaryRows lays out the summary sections one under another.
+func makeSummaryRows(s *summary.Summary) [][]interface{} {
+	rows := [][]interface{}{
+		{"updated_at", s.GeneratedAt.Format(time.DateTime)},
+		nil,
+		{"per category per month"},
+	}
+
+	header := []interface{}{"category"}
+	for _, month := range s.Months {
+		header = append(header, month)
+	}
+	rows = append(rows, append(header, "total"))
+	for _, total := range s.Categories {
+		row := []interface{}{total.Name}
+		for _, month := range s.Months {
+			row = append(row, total.ByMonth[month])
+		}
+		rows = append(rows, append(row, total.Amount))
+	}
+
+	rows = append(rows, nil, []interface{}{"per tag"}, []interface{}{"tag", "count", "total"})
+	for _, total := range s.Tags {
+		rows = append(rows, []interface{}{total.Name, total.Count, total.Amount})
+	}
+
+	rows = append(rows, nil, []interface{}{"balance"}, []interface{}{"month", "amount", "running"})
+	for _, balance := range s.Balance {
+		rows = append(rows, []interface{}{balance.Month, balance.Amount, balance.Running})
+	}
+	return rows
+}
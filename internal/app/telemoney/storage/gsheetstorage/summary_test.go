@@ -0,0 +1,58 @@
+package gsheetstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/gsheetstorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/summary"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+func TestTransactionStorage_ListAndWriteSummary(t *testing.T) {
+	gsc, server := newTestClient(t)
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: "transaction", MonthlyPartitions: false})
+	require.NoError(t, trr.Bootstrap(context.Background()))
+
+	inserted := []*model.Transaction{
+		{CreatedAt: 1710000000, MessageID: "1", ChatID: testChatID, UserID: testUserID, Amount: 9.5, Category: "lunch", Tags: []string{"a", "b"}, Comment: makeStringPtrInPlace("food")},
+		{CreatedAt: 1712000000, MessageID: "2", ChatID: testChatID, UserID: testUserID, Amount: 3, Category: "coffee", Tags: nil, Comment: nil},
+	}
+	for _, transaction := range inserted {
+		require.NoError(t, trr.Insert(context.Background(), transaction))
+	}
+	require.NoError(t, trr.DeleteByMessageID(context.Background(), testChatID, "2"))
+
+	transactions, err := trr.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, inserted[:1], transactions)
+
+	// the second write replaces the first one
+	require.NoError(t, trr.WriteSummary(context.Background(), summary.Build(inserted, time.Unix(1712000000, 0).UTC())))
+	require.NoError(t, trr.WriteSummary(context.Background(), summary.Build(transactions, time.Unix(1712000000, 0).UTC())))
+
+	rows := trimRows(server.Rows("transaction_summary"))
+	require.Equal(t, []interface{}{"updated_at", "2024-04-01 19:33:20"}, rows[0])
+	require.Equal(t, []interface{}{"category", "2024-03", "total"}, rows[3]) // 2024-04 of the first write is cleared
+	require.Equal(t, []interface{}{"lunch", 9.5, 9.5}, rows[4])
+	require.Equal(t, []interface{}{"a", float64(1), 9.5}, rows[8])
+	require.Equal(t, []interface{}{"2024-03", 9.5, 9.5}, rows[13])
+	require.Len(t, rows, 14)
+}
+
+// trimRows drops the cells left empty by clearing at the end of the rows and the empty rows at the end.
+func trimRows(rows [][]interface{}) [][]interface{} {
+	for i, row := range rows {
+		for len(row) > 0 && row[len(row)-1] == nil {
+			row = row[:len(row)-1]
+		}
+		rows[i] = row
+	}
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+	return rows
+}
@@ -1,7 +1,14 @@
 package gsheetstorage
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
 	"github.com/mitrkos/telemoney/internal/model"
@@ -9,109 +16,498 @@ import (
 )
 
 type TransactionStorage struct {
-	gsheetclient                  *gsheetclient.GSheetsClient
-	transactionSheetID            string
-	transactionMessageIDScanRange *gsheetclient.A1Range
+	gsheetclient *gsheetclient.GSheetsClient
+	config       *Config
+	location     *time.Location
+
+	partitionsMu sync.Mutex
+	partitions   map[string]bool // partition sheets known to exist
+
+	sheetsMu sync.Mutex
+	sheets   []*gsheetclient.SheetProperties // the sheets of the spreadsheet, nil - not read yet
+
+	index    *rowIndex
+	auditLog *AuditLog
+}
+
+type Config struct {
+	TransactionSheetID string
+	// MonthlyPartitions puts transactions to per month sheets like transaction_2026_10, by the transaction date
+	MonthlyPartitions bool
+	// Location is the timezone the dates are written and partitioned in, nil - UTC
+	Location *time.Location
+	// AmountFormat is the sheets number format pattern of the amount column, "" - #,##0.00
+	AmountFormat string
 }
 
-func New(gsheetclient *gsheetclient.GSheetsClient, transactionSheetID string) *TransactionStorage {
+func New(gsheetclient *gsheetclient.GSheetsClient, config *Config) *TransactionStorage {
 	// TODO: move gsheetclient creation to here
-	trr := &TransactionStorage{
-		gsheetclient:                  gsheetclient,
-		transactionSheetID:            transactionSheetID,
-		transactionMessageIDScanRange: nil,
+	location := config.Location
+	if location == nil {
+		location = time.UTC
+	}
+	return &TransactionStorage{
+		gsheetclient: gsheetclient,
+		config:       config,
+		location:     location,
+		partitionsMu: sync.Mutex{},
+		partitions:   make(map[string]bool),
+		sheetsMu:     sync.Mutex{},
+		sheets:       nil,
+		index:        newRowIndex(),
+		auditLog:     newAuditLog(gsheetclient, config.TransactionSheetID, location),
+	}
+}
+
+// Flush sends the batched writes queued so far.
+func (trr *TransactionStorage) Flush(ctx context.Context) {
+	trr.gsheetclient.Flush(ctx)
+}
+
+// Insert appends the row of the transaction, the row of the chat with the same message id that is not deleted
+// is overwritten instead, so an update handled again doesn't add a duplicate. The inserts of the same key
+// run one at a time.
+func (trr *TransactionStorage) Insert(ctx context.Context, transaction *model.Transaction) error {
+	key := model.KeyOf(transaction)
+	release, err := trr.index.lockInsert(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+	defer release()
+
+	row, err := trr.findRowForInsert(ctx, transaction.ChatID, transaction.MessageID)
+	if err == nil && row.chatID == transaction.ChatID {
+		slog.Info("transaction exists, overwriting it", slog.String("chatID", transaction.ChatID), slog.String("messageID", transaction.MessageID))
+		err = trr.gsheetclient.UpdateDataRange(ctx, makeTransactionRowRange(row.sheetID, row.row), convertTransactionToDataRow(transaction, trr.location))
+		if err != nil {
+			return convertGSheetError(err)
+		}
+		return nil
+	}
+	if err != nil && !errors.Is(err, storage.ErrTransactionNotFound) {
+		return err
+	}
+
+	sheetID, err := trr.sheetForInsert(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	err = trr.gsheetclient.AppendDataToRange(ctx, makeTransactionAppendRange(sheetID), convertTransactionToDataRow(transaction, trr.location))
+	// a failed append might have written the row still, the next lookup of the key reads the sheets to know
+	trr.index.appended(key, sheetID)
+	if err != nil {
+		return convertGSheetError(err)
 	}
-	trr.transactionMessageIDScanRange = trr.makeTransactionMessageIDScanRange()
-	return trr
+	return nil
 }
 
-func (trr *TransactionStorage) Insert(transaction *model.Transaction) error {
-	err := trr.gsheetclient.AppendDataToRange(trr.makeTransactionAppendRange(), convertTransactionToDataRow(transaction))
+// Update overwrites the row of the transaction, a row without the chat id gets it.
+func (trr *TransactionStorage) Update(ctx context.Context, transaction *model.Transaction) error {
+	row, err := trr.findTransactionRow(ctx, transaction.ChatID, transaction.MessageID)
+	if err != nil {
+		return err
+	}
+
+	err = trr.gsheetclient.UpdateDataRange(ctx, makeTransactionRowRange(row.sheetID, row.row), convertTransactionToDataRow(transaction, trr.location))
 	if err != nil {
-		return storage.ErrOperationFailed
+		return convertGSheetError(err)
+	}
+	if row.chatID != transaction.ChatID {
+		trr.index.remove(model.TransactionKey{ChatID: row.chatID, MessageID: row.messageID})
+		trr.index.set(model.KeyOf(transaction), row.sheetID, row.row)
 	}
 	return nil
 }
 
-func (trr *TransactionStorage) Update(transaction *model.Transaction) error {
-	msgIDLocation, err := trr.gsheetclient.FindValueLocation(trr.transactionMessageIDScanRange, transaction.MessageID)
+func (trr *TransactionStorage) Get(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	row, err := trr.findTransactionRow(ctx, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := convertDataRowToTransaction(row.data, trr.location)
 	if err != nil {
-		return storage.ErrOperationFailed
+		return nil, fmt.Errorf("%w: %s row %d: %w", storage.ErrOperationFailed, row.sheetID, row.row, err)
 	}
-	if msgIDLocation == nil {
-		return storage.ErrTransactionNotFound
+	return transaction, nil
+}
+
+// DeleteByMessageID puts the time of the deletion to the deleted_at column of the row.
+func (trr *TransactionStorage) DeleteByMessageID(ctx context.Context, chatID string, messageID string) error {
+	row, err := trr.findTransactionRow(ctx, chatID, messageID)
+	if err != nil {
+		return err
 	}
 
-	err = trr.gsheetclient.UpdateDataRange(trr.makeTransactionRowRangeFromLocation(msgIDLocation), convertTransactionToDataRow(transaction))
+	deletedAt := gsheetclient.ToDateSerial(time.Now().In(trr.location))
+	err = trr.gsheetclient.UpdateDataRange(ctx, makeTransactionDeletedAtRange(row.sheetID, row.row), []interface{}{deletedAt})
 	if err != nil {
-		return storage.ErrOperationFailed
+		return convertGSheetError(err)
 	}
+	trr.index.remove(model.TransactionKey{ChatID: row.chatID, MessageID: row.messageID})
 	return nil
 }
 
-func (trr *TransactionStorage) DeleteByMessageID(transactionMessageID string) error {
-	msgIDLocation, err := trr.gsheetclient.FindValueLocation(trr.transactionMessageIDScanRange, transactionMessageID)
+// Restore clears the deleted_at column of the row deleted last.
+func (trr *TransactionStorage) Restore(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	row, err := trr.findDeletedTransactionRow(ctx, chatID, messageID)
 	if err != nil {
-		return storage.ErrOperationFailed
+		return nil, err
 	}
-	if msgIDLocation == nil {
-		return storage.ErrTransactionNotFound
+	transaction, err := convertDataRowToTransaction(row.data, trr.location)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
 	}
 
-	err = trr.gsheetclient.ClearRange(trr.makeTransactionRowRangeFromLocation(msgIDLocation))
+	err = trr.gsheetclient.UpdateDataRange(ctx, makeTransactionDeletedAtRange(row.sheetID, row.row), []interface{}{""})
 	if err != nil {
-		return storage.ErrOperationFailed
+		return nil, convertGSheetError(err)
+	}
+	trr.index.set(model.TransactionKey{ChatID: row.chatID, MessageID: row.messageID}, row.sheetID, row.row)
+	return transaction, nil
+}
+
+// Purge clears the rows deleted before the time, one request per sheet.
+func (trr *TransactionStorage) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	sheetIDs, err := trr.sheetsForLookup(ctx)
+	if err != nil {
+		return 0, convertGSheetError(err)
+	}
+
+	purged := 0
+	for _, sheetID := range sheetIDs {
+		rows, err := trr.readTransactionRows(ctx, sheetID)
+		if err != nil {
+			return purged, err
+		}
+		var purgeRanges []*gsheetclient.A1Range
+		for _, row := range rows {
+			if row.deleted && row.deletedAt.Before(deletedBefore) {
+				purgeRanges = append(purgeRanges, makeTransactionRowRange(row.sheetID, row.row))
+			}
+		}
+		err = trr.gsheetclient.ClearRanges(ctx, purgeRanges)
+		if err != nil {
+			return purged, convertGSheetError(err)
+		}
+		purged += len(purgeRanges)
+	}
+	return purged, nil
+}
+
+func (trr *TransactionStorage) List(ctx context.Context) ([]*model.Transaction, error) {
+	records, err := trr.ListRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]*model.Transaction, 0, len(records))
+	for _, record := range records {
+		transactions = append(transactions, record.Transaction)
+	}
+	return transactions, nil
+}
+
+// ListRecords returns the transactions of all transaction sheets, the place is "<sheet> row <row>".
+// The index is loaded from the rows read, so it learns the rows added by hand.
+func (trr *TransactionStorage) ListRecords(ctx context.Context) ([]*storage.TransactionRecord, error) {
+	rows, err := trr.loadIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return trr.makeRecords(rows), nil
+}
+
+// ListPages lists the transactions of the transaction sheets reading up to size rows at a time,
+// a page can have less transactions as the deleted and the broken rows are skipped.
+func (trr *TransactionStorage) ListPages(ctx context.Context, size int, page func([]*model.Transaction) error) error {
+	sheetIDs, err := trr.sheetsForLookup(ctx)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	// the cached sheet list has the row counts of when it was read, the appends grow the sheets since
+	sheetList, err := trr.gsheetclient.GetSheets(ctx)
+	if err != nil {
+		return convertGSheetError(err)
+	}
+	rowCounts := make(map[string]int, len(sheetList))
+	for _, sheet := range sheetList {
+		rowCounts[sheet.Title] = int(sheet.RowCount)
+	}
+
+	for _, sheetID := range sheetIDs {
+		for first := transactionFirstDataRow; first <= rowCounts[sheetID]; first += size {
+			values, err := trr.gsheetclient.ReadRangeUnformatted(ctx, makeTransactionRowsRange(sheetID, first, first+size-1))
+			if err != nil {
+				return convertGSheetError(err)
+			}
+			records := trr.makeRecords(trr.parseTransactionRows(sheetID, first, values))
+			if len(records) == 0 {
+				continue
+			}
+			transactions := make([]*model.Transaction, 0, len(records))
+			for _, record := range records {
+				transactions = append(transactions, record.Transaction)
+			}
+			err = page(transactions)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (trr *TransactionStorage) makeSheetRange(
+// makeRecords converts the rows that are not deleted, the broken ones are skipped.
+func (trr *TransactionStorage) makeRecords(rows []*transactionRow) []*storage.TransactionRecord {
+	var records []*storage.TransactionRecord
+	for _, row := range rows {
+		if row.deleted {
+			continue
+		}
+		transaction, err := convertDataRowToTransaction(row.data, trr.location)
+		if err != nil {
+			slog.Warn("skipping a broken transaction row", slog.String("sheet", row.sheetID), slog.Int("row", row.row), slog.Any("err", err))
+			continue
+		}
+		records = append(records, &storage.TransactionRecord{
+			Transaction: transaction,
+			Place:       fmt.Sprintf("%s row %d", row.sheetID, row.row),
+		})
+	}
+	return records
+}
+
+// transactionRow is a not empty row of a transaction sheet.
+type transactionRow struct {
+	sheetID   string
+	row       int
+	messageID string
+	chatID    string // "" for the rows written before the chat was kept
+	data      []interface{}
+	deleted   bool
+	deletedAt time.Time // zero if deleted_at is not a date
+}
+
+func (trr *TransactionStorage) readTransactionRows(ctx context.Context, sheetID string) ([]*transactionRow, error) {
+	values, err := trr.gsheetclient.ReadRangeUnformatted(ctx, makeTransactionAppendRange(sheetID))
+	if err != nil {
+		return nil, convertGSheetError(err)
+	}
+	return trr.parseTransactionRows(sheetID, transactionFirstDataRow, values), nil
+}
+
+// parseTransactionRows makes the rows of the values read from firstRow on, the empty ones are skipped.
+func (trr *TransactionStorage) parseTransactionRows(sheetID string, firstRow int, values [][]interface{}) []*transactionRow {
+	rows := make([]*transactionRow, 0, len(values))
+	for i, data := range values {
+		row := &transactionRow{
+			sheetID:   sheetID,
+			row:       firstRow + i,
+			messageID: "",
+			chatID:    "",
+			data:      data,
+			deleted:   false,
+			deletedAt: time.Time{},
+		}
+		if len(data) > transactionMessageIDIdx {
+			row.messageID = formatCell(data[transactionMessageIDIdx])
+		}
+		if row.messageID == "" {
+			continue
+		}
+		if len(data) > transactionChatIDIdx {
+			row.chatID = formatCell(data[transactionChatIDIdx])
+		}
+		if len(data) > transactionDeletedAtIdx && data[transactionDeletedAtIdx] != nil && formatCell(data[transactionDeletedAtIdx]) != "" {
+			row.deleted = true
+			if serial, err := strconv.ParseFloat(formatCell(data[transactionDeletedAtIdx]), 64); err == nil {
+				row.deletedAt = gsheetclient.FromDateSerial(serial, trr.location)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// findTransactionRow returns the row of the transaction that is not deleted, the index tells where it is. The sheets
+// are read again if the index is not loaded, doesn't have the key as the row might be added by hand, the row moved
+// or the row was appended and the index doesn't know where.
+// A row without the chat id is returned only if the chat has no row with the message id.
+func (trr *TransactionStorage) findTransactionRow(ctx context.Context, chatID string, messageID string) (*transactionRow, error) {
+	return trr.findRow(ctx, chatID, messageID, false)
+}
+
+// findRowForInsert is findTransactionRow trusting the loaded index without the key: the message of an insert is new
+// but for a replay, so the inserts don't read all the sheets. The rows added by hand get there on ListRecords.
+func (trr *TransactionStorage) findRowForInsert(ctx context.Context, chatID string, messageID string) (*transactionRow, error) {
+	return trr.findRow(ctx, chatID, messageID, true)
+}
+
+func (trr *TransactionStorage) findRow(ctx context.Context, chatID string, messageID string, trustMiss bool) (*transactionRow, error) {
+	ref, key, ok := trr.index.lookup(chatID, messageID)
+	if ok && ref == nil && trustMiss {
+		return nil, storage.ErrTransactionNotFound
+	}
+	if ok && ref != nil && ref.row > 0 {
+		values, err := trr.gsheetclient.ReadRangeUnformatted(ctx, makeTransactionRowRange(ref.sheetID, ref.row))
+		if err != nil {
+			return nil, convertGSheetError(err)
+		}
+		for _, row := range trr.parseTransactionRows(ref.sheetID, ref.row, values) {
+			if row.messageID == messageID && row.chatID == key.ChatID && !row.deleted {
+				return row, nil
+			}
+		}
+	}
+
+	rows, err := trr.loadIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var legacy *transactionRow
+	for _, row := range rows {
+		if row.messageID != messageID || row.deleted {
+			continue
+		}
+		if row.chatID == chatID {
+			return row, nil
+		}
+		if row.chatID == "" && legacy == nil {
+			legacy = row
+		}
+	}
+	if legacy != nil {
+		return legacy, nil
+	}
+	return nil, storage.ErrTransactionNotFound
+}
+
+// loadIndex reads the rows of all transaction sheets to the index and returns them.
+func (trr *TransactionStorage) loadIndex(ctx context.Context) ([]*transactionRow, error) {
+	generation := trr.index.startLoad()
+	sheetIDs, err := trr.sheetsForLookup(ctx)
+	if err != nil {
+		return nil, convertGSheetError(err)
+	}
+
+	var rows []*transactionRow
+	for _, sheetID := range sheetIDs {
+		sheetRows, err := trr.readTransactionRows(ctx, sheetID)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, sheetRows...)
+	}
+	trr.index.load(generation, rows)
+	return rows, nil
+}
+
+// findDeletedTransactionRow returns the row of the transaction deleted last, the rows without the chat id
+// are taken only if the chat has no deleted row with the message id.
+func (trr *TransactionStorage) findDeletedTransactionRow(ctx context.Context, chatID string, messageID string) (*transactionRow, error) {
+	sheetIDs, err := trr.sheetsForLookup(ctx)
+	if err != nil {
+		return nil, convertGSheetError(err)
+	}
+
+	var found, legacy *transactionRow
+	for _, sheetID := range sheetIDs {
+		rows, err := trr.readTransactionRows(ctx, sheetID)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if row.messageID != messageID || !row.deleted {
+				continue
+			}
+			switch {
+			case row.chatID == chatID && (found == nil || !row.deletedAt.Before(found.deletedAt)):
+				found = row
+			case row.chatID == "" && (legacy == nil || !row.deletedAt.Before(legacy.deletedAt)):
+				legacy = row
+			}
+		}
+	}
+	if found == nil {
+		found = legacy
+	}
+	if found == nil {
+		return nil, storage.ErrTransactionNotFound
+	}
+	return found, nil
+}
+
+func makeSheetRange(
+	sheetID string,
 	leftTop *gsheetclient.A1Location,
 	rightBottom *gsheetclient.A1Location,
 ) *gsheetclient.A1Range {
 	return &gsheetclient.A1Range{
-		SheetID:     trr.transactionSheetID,
+		SheetID:     sheetID,
 		LeftTop:     leftTop,
 		RightBottom: rightBottom,
 	}
 }
 
-func (trr *TransactionStorage) makeTransactionAppendRange() *gsheetclient.A1Range {
-	return trr.makeSheetRange(
+func makeTransactionAppendRange(sheetID string) *gsheetclient.A1Range {
+	return makeSheetRange(
+		sheetID,
 		&gsheetclient.A1Location{
-			Column: "A", // TODO: add schema mapping
-			Row:    3,   //nolint:gomnd // fix
+			Column: transactionFirstColumn,
+			Row:    transactionFirstDataRow,
 		}, &gsheetclient.A1Location{
-			Column: "F",
+			Column: transactionLastColumn,
 			Row:    0,
 		},
 	)
 }
 
-func (trr *TransactionStorage) makeTransactionMessageIDScanRange() *gsheetclient.A1Range {
-	return trr.makeSheetRange(&gsheetclient.A1Location{
-		Column: "B", // TODO: add schema mapping
-		Row:    3,   //nolint:gomnd // fix
+func makeTransactionRowsRange(sheetID string, firstRow int, lastRow int) *gsheetclient.A1Range {
+	return makeSheetRange(sheetID, &gsheetclient.A1Location{
+		Column: transactionFirstColumn,
+		Row:    firstRow,
+	}, &gsheetclient.A1Location{
+		Column: transactionLastColumn,
+		Row:    lastRow,
+	})
+}
+
+func makeTransactionRowRange(sheetID string, row int) *gsheetclient.A1Range {
+	return makeSheetRange(sheetID, &gsheetclient.A1Location{
+		Column: transactionFirstColumn,
+		Row:    row,
 	}, &gsheetclient.A1Location{
-		Column: "B",
-		Row:    0,
+		Column: transactionLastColumn,
+		Row:    row,
 	})
 }
 
-func (trr *TransactionStorage) makeTransactionRowRangeFromLocation(location *gsheetclient.A1Location) *gsheetclient.A1Range {
-	return trr.makeSheetRange(&gsheetclient.A1Location{
-		Column: "A", // TODO: add schema mapping
-		Row:    location.Row,
+func makeTransactionDeletedAtRange(sheetID string, row int) *gsheetclient.A1Range {
+	return makeSheetRange(sheetID, &gsheetclient.A1Location{
+		Column: transactionDeletedAtColumn,
+		Row:    row,
 	}, &gsheetclient.A1Location{
-		Column: "F",
-		Row:    location.Row,
+		Column: transactionDeletedAtColumn,
+		Row:    row,
 	})
 }
 
-func convertTransactionToDataRow(transaction *model.Transaction) []interface{} {
-	dataRow := make([]interface{}, 6) //nolint:gomnd // a row have 6 elems
+// minUnixTimeInSheet tells the unix time of old rows from date serials: as unix time it is April 1970, as a serial - year 29278
+const minUnixTimeInSheet = 10_000_000
+
+func convertGSheetError(err error) error {
+	if errors.Is(err, gsheetclient.ErrRetryable) {
+		return fmt.Errorf("%w: %w: %w", storage.ErrOperationFailed, storage.ErrTemporarilyUnavailable, err)
+	}
+	return fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+}
+
+func convertTransactionToDataRow(transaction *model.Transaction, location *time.Location) []interface{} {
+	// deleted_at stays nil, so an update doesn't touch it
+	dataRow := make([]interface{}, transactionColumns)
 
-	dataRow[0] = transaction.CreatedAt
+	dataRow[0] = gsheetclient.ToDateSerial(time.Unix(transaction.CreatedAt, 0).In(location))
 	dataRow[1] = transaction.MessageID
 	dataRow[2] = transaction.Amount
 	dataRow[3] = transaction.Category
@@ -122,6 +518,69 @@ func convertTransactionToDataRow(transaction *model.Transaction) []interface{} {
 	if transaction.Comment != nil {
 		dataRow[5] = *transaction.Comment
 	}
+	dataRow[transactionChatIDIdx] = transaction.ChatID
+	dataRow[transactionUserIDIdx] = transaction.UserID
 
 	return dataRow
 }
+
+// convertDataRowToTransaction parses an unformatted row, nil is for an empty (removed) row.
+func convertDataRowToTransaction(dataRow []interface{}, location *time.Location) (*model.Transaction, error) {
+	cell := func(idx int) interface{} {
+		if idx < len(dataRow) && dataRow[idx] != nil {
+			return dataRow[idx]
+		}
+		return ""
+	}
+
+	messageID := formatCell(cell(1))
+	if messageID == "" {
+		return nil, nil //nolint:nilnil // an empty row is not an error
+	}
+	createdAt, err := parseCreatedAt(cell(0), location)
+	if err != nil {
+		return nil, fmt.Errorf("created_at: %w", err)
+	}
+	amount, err := strconv.ParseFloat(formatCell(cell(2)), 64)
+	if err != nil {
+		return nil, fmt.Errorf("amount: %w", err)
+	}
+
+	transaction := &model.Transaction{
+		CreatedAt: createdAt,
+		MessageID: messageID,
+		ChatID:    formatCell(cell(transactionChatIDIdx)),
+		UserID:    formatCell(cell(transactionUserIDIdx)),
+		Amount:    amount,
+		Category:  formatCell(cell(3)),
+		Tags:      nil,
+		Comment:   nil,
+	}
+	if tagsStr := formatCell(cell(4)); tagsStr != "" {
+		transaction.Tags = strings.Split(tagsStr, ",")
+	}
+	if comment := formatCell(cell(5)); comment != "" {
+		transaction.Comment = &comment
+	}
+	return transaction, nil
+}
+
+// parseCreatedAt reads a date serial, old rows have the unix time there instead.
+func parseCreatedAt(value interface{}, location *time.Location) (int64, error) {
+	number, err := strconv.ParseFloat(formatCell(value), 64)
+	if err != nil {
+		return 0, err
+	}
+	if number >= minUnixTimeInSheet {
+		return int64(number), nil
+	}
+	return gsheetclient.FromDateSerial(number, location).Unix(), nil
+}
+
+// formatCell turns an unformatted value into the string it was written as.
+func formatCell(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
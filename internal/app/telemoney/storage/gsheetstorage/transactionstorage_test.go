@@ -0,0 +1,336 @@
+package gsheetstorage_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/gsheetstorage"
+	"github.com/mitrkos/telemoney/internal/model"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient/gsheetfake"
+)
+
+const (
+	testSpreadsheetID = "1DNP3yNOA03Qd52u6HPAw4uGQLSpQac2o5JaaI-9JjGs"
+	testSheetID       = "transaction_test"
+	testChatID        = "7"
+	testUserID        = "8"
+)
+
+func makeStringPtrInPlace(v string) *string { return &v }
+
+func newTestStorage(t *testing.T) (*gsheetstorage.TransactionStorage, *gsheetfake.Server) {
+	gsc, server := newTestClient(t)
+	return gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: testSheetID, MonthlyPartitions: false}), server
+}
+
+func newTestClient(t *testing.T) (*gsheetclient.GSheetsClient, *gsheetfake.Server) {
+	server := gsheetfake.New(testSpreadsheetID)
+	t.Cleanup(server.Close)
+
+	seed, err := os.ReadFile("../../../../../msg_examples/gsheets_response.json")
+	require.NoError(t, err)
+	require.NoError(t, server.SeedFromBatchGetResponse(seed))
+
+	gsc, err := gsheetclient.New(context.Background(), &gsheetclient.Config{
+		CredentialsSource: "",
+		AuthToken:         "",
+		CredentialsFile:   "",
+		OAuthTokenFile:    "",
+		SpreadsheetID:     testSpreadsheetID,
+		RequestTimeout:    time.Second,
+		Retry:             gsheetclient.RetryConfig{MaxAttempts: 1, InitialBackoff: 0, MaxBackoff: 0},
+		BatchWindow:       0,
+		BatchMaxSize:      0,
+		Endpoint:          server.URL(),
+		HTTPClient:        server.Client(),
+	})
+	require.NoError(t, err)
+	return gsc, server
+}
+
+func TestTransactionStorage_Insert(t *testing.T) {
+	trr, server := newTestStorage(t)
+
+	err := trr.Insert(context.Background(), &model.Transaction{
+		CreatedAt: 1710000000,
+		MessageID: "200",
+		ChatID:    testChatID,
+		UserID:    testUserID,
+		Amount:    9.5,
+		Category:  "lunch",
+		Tags:      []string{"grenka", "dumplings"},
+		Comment:   makeStringPtrInPlace("I need food!"),
+	})
+	require.NoError(t, err)
+
+	rows := server.Rows(testSheetID)
+	require.Len(t, rows, 28) // 27 seeded rows
+	createdAt := gsheetclient.ToDateSerial(time.Unix(1710000000, 0).UTC())
+	require.Equal(t, []interface{}{createdAt, float64(200), 9.5, "lunch", "grenka,dumplings", "I need food!", nil, float64(7), float64(8)}, rows[27])
+}
+
+func TestTransactionStorage_InsertIsIdempotent(t *testing.T) {
+	trr, server := newTestStorage(t)
+
+	// the seeded row 92 has no chat, it may be of another chat and is left as it is
+	transaction := &model.Transaction{
+		CreatedAt: 1710000000,
+		MessageID: "92",
+		ChatID:    testChatID,
+		UserID:    testUserID,
+		Amount:    95,
+		Category:  "lunch",
+		Tags:      nil,
+		Comment:   nil,
+	}
+	require.NoError(t, trr.Insert(context.Background(), transaction))
+	transaction.Amount = 96
+	require.NoError(t, trr.Insert(context.Background(), transaction))
+
+	rows := server.Rows(testSheetID)
+	require.Len(t, rows, 28)
+	require.Equal(t, []interface{}{nil, float64(92)}, rows[7])
+	createdAt := gsheetclient.ToDateSerial(time.Unix(1710000000, 0).UTC())
+	require.Equal(t, []interface{}{createdAt, float64(92), float64(96), "lunch", nil, nil, nil, float64(7), float64(8)}, rows[27])
+}
+
+func TestTransactionStorage_InsertReadsTheSheetOnce(t *testing.T) {
+	trr, server := newTestStorage(t)
+
+	for i := 200; i < 205; i++ {
+		transaction := &model.Transaction{CreatedAt: 1710000000, MessageID: strconv.Itoa(i), ChatID: testChatID, UserID: testUserID, Amount: 1, Category: "lunch", Tags: nil, Comment: nil}
+		require.NoError(t, trr.Insert(context.Background(), transaction))
+	}
+	require.Equal(t, 1, server.RequestCount("values.get"))
+	require.Len(t, server.Rows(testSheetID), 32)
+
+	// the row of the appended transaction is found by reading the sheet again, then it is read alone
+	transaction := &model.Transaction{CreatedAt: 1710000000, MessageID: "202", ChatID: testChatID, UserID: testUserID, Amount: 2, Category: "lunch", Tags: nil, Comment: nil}
+	require.NoError(t, trr.Update(context.Background(), transaction))
+	require.Equal(t, 2, server.RequestCount("values.get"))
+	require.NoError(t, trr.Update(context.Background(), transaction))
+	require.Equal(t, 3, server.RequestCount("values.get"))
+	require.Equal(t, float64(2), server.Rows(testSheetID)[29][2])
+}
+
+func TestTransactionStorage_ConcurrentInsertsOfAKeyAppendOnce(t *testing.T) {
+	trr, server := newTestStorage(t)
+	transaction := &model.Transaction{CreatedAt: 1710000000, MessageID: "200", ChatID: testChatID, UserID: testUserID, Amount: 9.5, Category: "lunch", Tags: nil, Comment: nil}
+
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			errs <- trr.Insert(context.Background(), transaction)
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, <-errs)
+	}
+
+	require.Len(t, server.Rows(testSheetID), 28)
+}
+
+func TestTransactionStorage_FindsARowMovedByHand(t *testing.T) {
+	trr, server := newTestStorage(t)
+	transaction := &model.Transaction{CreatedAt: 1710000000, MessageID: "95", ChatID: testChatID, UserID: testUserID, Amount: 9.5, Category: "lunch", Tags: nil, Comment: nil}
+	require.NoError(t, trr.Update(context.Background(), transaction))
+
+	// a row above is removed in the sheet, the rows below move up
+	rows := server.Rows(testSheetID)
+	server.SetRows(testSheetID, append(rows[:1:1], rows[2:]...))
+
+	transaction.Amount = 10
+	require.NoError(t, trr.Update(context.Background(), transaction))
+	require.Equal(t, float64(10), server.Rows(testSheetID)[7][2])
+}
+
+func TestTransactionStorage_FindsARowAddedAfterTheIndexIsLoaded(t *testing.T) {
+	trr, server := newTestStorage(t)
+	transaction := &model.Transaction{CreatedAt: 1710000000, MessageID: "200", ChatID: testChatID, UserID: testUserID, Amount: 9.5, Category: "lunch", Tags: nil, Comment: nil}
+	require.NoError(t, trr.Insert(context.Background(), transaction))
+
+	// rows are added by hand, like the ones of the old messages migrate writes
+	rows := server.Rows(testSheetID)
+	added := func(messageID float64) []interface{} {
+		row := append([]interface{}(nil), rows[len(rows)-1]...)
+		row[1] = messageID
+		return row
+	}
+	server.SetRows(testSheetID, append(rows, added(300), added(301)))
+
+	transaction.MessageID, transaction.Amount = "300", 3
+	require.NoError(t, trr.Update(context.Background(), transaction))
+	// an insert trusts the index till the sheets are listed
+	_, err := trr.ListRecords(context.Background())
+	require.NoError(t, err)
+	transaction.MessageID, transaction.Amount = "301", 4
+	require.NoError(t, trr.Insert(context.Background(), transaction))
+
+	rows = server.Rows(testSheetID)
+	require.Len(t, rows, 30)
+	require.Equal(t, float64(3), rows[28][2])
+	require.Equal(t, float64(4), rows[29][2])
+}
+
+func TestTransactionStorage_UpdateAndDelete(t *testing.T) {
+	trr, server := newTestStorage(t)
+
+	err := trr.Update(context.Background(), &model.Transaction{
+		CreatedAt: 1710000000,
+		MessageID: "92",
+		ChatID:    testChatID,
+		UserID:    testUserID,
+		Amount:    95,
+		Category:  "lunch",
+		Tags:      nil,
+		Comment:   nil,
+	})
+	require.NoError(t, err)
+
+	err = trr.DeleteByMessageID(context.Background(), testChatID, "95")
+	require.NoError(t, err)
+
+	rows := server.Rows(testSheetID)
+	createdAt := gsheetclient.ToDateSerial(time.Unix(1710000000, 0).UTC())
+	require.Equal(t, []interface{}{createdAt, float64(92), float64(95), "lunch", nil, nil, nil, float64(7), float64(8)}, rows[7])
+	require.IsType(t, float64(0), rows[8][6]) // deleted_at
+}
+
+func TestTransactionStorage_SoftDelete(t *testing.T) {
+	trr, server := newTestStorage(t)
+	transaction := &model.Transaction{CreatedAt: 1710000000, MessageID: "200", ChatID: testChatID, UserID: testUserID, Amount: 9.5, Category: "lunch", Tags: nil, Comment: nil}
+	require.NoError(t, trr.Insert(context.Background(), transaction))
+	before, err := trr.List(context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, trr.DeleteByMessageID(context.Background(), testChatID, "200"))
+	require.ErrorIs(t, trr.DeleteByMessageID(context.Background(), testChatID, "200"), storage.ErrTransactionNotFound)
+	require.ErrorIs(t, trr.Update(context.Background(), transaction), storage.ErrTransactionNotFound)
+	transactions, err := trr.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, transactions, len(before)-1)
+
+	restored, err := trr.Restore(context.Background(), testChatID, "200")
+	require.NoError(t, err)
+	require.Equal(t, transaction, restored)
+	_, err = trr.Restore(context.Background(), testChatID, "200")
+	require.ErrorIs(t, err, storage.ErrTransactionNotFound)
+	transactions, err = trr.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, transactions, len(before))
+
+	require.NoError(t, trr.DeleteByMessageID(context.Background(), testChatID, "200"))
+	purged, err := trr.Purge(context.Background(), time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, purged)
+	purged, err = trr.Purge(context.Background(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	require.Equal(t, []interface{}{nil, nil, nil, nil, nil, nil, nil, nil, nil}, server.Rows(testSheetID)[27])
+}
+
+func TestTransactionStorage_PurgeClearsTheRowsAtOnce(t *testing.T) {
+	trr, server := newTestStorage(t)
+	for _, messageID := range []string{"200", "201", "202"} {
+		transaction := &model.Transaction{CreatedAt: 1710000000, MessageID: messageID, ChatID: testChatID, UserID: testUserID, Amount: 1, Category: "tea", Tags: nil, Comment: nil}
+		require.NoError(t, trr.Insert(context.Background(), transaction))
+		require.NoError(t, trr.DeleteByMessageID(context.Background(), testChatID, messageID))
+	}
+
+	purged, err := trr.Purge(context.Background(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 3, purged)
+	require.Equal(t, 1, server.RequestCount("values.batchClear"))
+	require.Equal(t, 0, server.RequestCount("values.clear"))
+}
+
+func TestTransactionStorage_NotFound(t *testing.T) {
+	trr, _ := newTestStorage(t)
+
+	err := trr.Update(context.Background(), &model.Transaction{
+		CreatedAt: 1710000000,
+		MessageID: "404",
+		ChatID:    testChatID,
+		UserID:    testUserID,
+		Amount:    1,
+		Category:  "lunch",
+		Tags:      nil,
+		Comment:   nil,
+	})
+	require.ErrorIs(t, err, storage.ErrTransactionNotFound)
+
+	err = trr.DeleteByMessageID(context.Background(), testChatID, "404")
+	require.ErrorIs(t, err, storage.ErrTransactionNotFound)
+}
+
+func TestTransactionStorage_ListReadsDatesAndOldUnixRows(t *testing.T) {
+	gsc, server := newTestClient(t)
+	location := time.FixedZone("UTC+3", 3*60*60)
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{
+		TransactionSheetID: "transaction",
+		MonthlyPartitions:  false,
+		Location:           location,
+		AmountFormat:       "",
+	})
+	server.SetRows("transaction", [][]interface{}{
+		nil,
+		{"created_at", "message_id", "amount", "category", "tags", "comment"},
+		{"1710000000", "1", "2", "old"},
+	})
+
+	require.NoError(t, trr.Insert(context.Background(), &model.Transaction{
+		CreatedAt: 1710000000,
+		MessageID: "2",
+		ChatID:    testChatID,
+		UserID:    testUserID,
+		Amount:    3,
+		Category:  "new",
+		Tags:      nil,
+		Comment:   nil,
+	}))
+	// 2024-03-09 16:00:00 UTC is 19:00 on the wall clock in the sheet
+	require.InDelta(t, 45360.791666, server.Rows("transaction")[3][0], 0.000001)
+
+	transactions, err := trr.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+	require.Equal(t, int64(1710000000), transactions[0].CreatedAt)
+	require.Equal(t, int64(1710000000), transactions[1].CreatedAt)
+}
+
+func TestTransactionStorage_ListPages(t *testing.T) {
+	gsc, server := newTestClient(t)
+	trr := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: "transaction", MonthlyPartitions: false})
+	rows := [][]interface{}{nil, {"created_at", "message_id", "amount", "category", "tags", "comment", "deleted_at", "chat_id", "user_id"}}
+	for i := 1; i <= 25; i++ {
+		deletedAt := ""
+		if i == 12 {
+			deletedAt = "45360"
+		}
+		rows = append(rows, []interface{}{"45360", strconv.Itoa(i), "1", "tea", "", "", deletedAt, testChatID, testUserID})
+	}
+	server.SetRows("transaction", rows)
+
+	all, err := trr.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, all, 24)
+
+	var paged []*model.Transaction
+	pages := 0
+	err = trr.ListPages(context.Background(), 10, func(transactions []*model.Transaction) error {
+		require.LessOrEqual(t, len(transactions), 10)
+		paged = append(paged, transactions...)
+		pages++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, all, paged)
+	require.Equal(t, 3, pages)
+}
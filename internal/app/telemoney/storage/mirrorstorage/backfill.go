@@ -0,0 +1,38 @@
+package mirrorstorage
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+)
+
+type BackfillResult struct {
+	Written   int // missing or different in the target
+	Removed   int // only the target had them
+	Unchanged int
+}
+
+// Backfill makes the target a copy of the source: the transactions the target misses or has different are written,
+// the ones only the target has are removed.
+func Backfill(ctx context.Context, source storage.TransactionStorage, target storage.TransactionStorage) (*BackfillResult, error) {
+	synced, err := storage.Sync(ctx, source, target, nil)
+	if synced == nil {
+		return nil, err
+	}
+	result := &BackfillResult{Written: synced.Inserted + synced.Updated, Removed: 0, Unchanged: synced.Unchanged}
+	if err != nil {
+		return result, err
+	}
+
+	for _, key := range synced.Extra {
+		err = target.DeleteByMessageID(ctx, key.ChatID, key.MessageID)
+		if err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	slog.Info("backfill is done", slog.Int("written", result.Written), slog.Int("removed", result.Removed), slog.Int("unchanged", result.Unchanged))
+	return result, nil
+}
@@ -0,0 +1,289 @@
+// Package mirrorstorage fans the transaction writes out to a primary storage and its secondaries.
+package mirrorstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+type Consistency string
+
+const (
+	// ConsistencyPrimary returns when the primary is written, the secondaries are written in the background.
+	ConsistencyPrimary Consistency = "primary"
+	// ConsistencyAll returns when the primary and all secondaries are written. A secondary that is not written
+	// fails the write with ErrSecondariesNotWritten, the primary is written then: the write is not to be repeated.
+	ConsistencyAll Consistency = "all"
+)
+
+// ErrSecondariesNotWritten comes together with storage.ErrOperationFailed when the primary is written
+// but a secondary is not, a backfill brings it back in sync.
+var ErrSecondariesNotWritten = errors.New("the primary is written, the secondaries are not")
+
+type Config struct {
+	Consistency   Consistency   // "" - ConsistencyPrimary
+	RetryAttempts int           // attempts of a secondary write, 0 - 1
+	RetryInterval time.Duration // pause between the attempts
+	QueueSize     int           // background writes waiting per secondary, the new ones are dropped when it is full; 0 - 1000
+}
+
+const defaultQueueSize = 1000
+
+// Secondary is a storage the writes are repeated to.
+type Secondary struct {
+	Name    string
+	Storage storage.TransactionStorage
+}
+
+// TransactionStorage writes to the primary first, its error is the result of the write. Reads go to the primary.
+// A secondary that failed all attempts is logged as diverged, a backfill brings it back in sync.
+type TransactionStorage struct {
+	config      *Config
+	primary     storage.TransactionStorage
+	secondaries []*secondary
+}
+
+type secondary struct {
+	Secondary
+	queue   chan *operation
+	pending sync.WaitGroup // queued and not applied yet
+}
+
+type operationKind string
+
+const (
+	operationInsert  operationKind = "insert"
+	operationUpdate  operationKind = "update"
+	operationDelete  operationKind = "delete"
+	operationRestore operationKind = "restore"
+)
+
+type operation struct {
+	kind        operationKind
+	transaction *model.Transaction // nil for operationDelete
+	key         model.TransactionKey
+}
+
+// New starts the background writers of the secondaries, ctx bounds their lifetime.
+func New(ctx context.Context, config *Config, primary storage.TransactionStorage, secondaries []Secondary) *TransactionStorage {
+	s := &TransactionStorage{
+		config:      config,
+		primary:     primary,
+		secondaries: make([]*secondary, 0, len(secondaries)),
+	}
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	for _, sec := range secondaries {
+		mirror := &secondary{Secondary: sec, queue: make(chan *operation, queueSize), pending: sync.WaitGroup{}}
+		s.secondaries = append(s.secondaries, mirror)
+		if config.Consistency != ConsistencyAll {
+			go s.runSecondary(ctx, mirror)
+		}
+	}
+	return s
+}
+
+func (s *TransactionStorage) Insert(ctx context.Context, transaction *model.Transaction) error {
+	err := s.primary.Insert(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	return s.mirror(ctx, &operation{kind: operationInsert, transaction: transaction, key: model.KeyOf(transaction)})
+}
+
+func (s *TransactionStorage) Update(ctx context.Context, transaction *model.Transaction) error {
+	err := s.primary.Update(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	return s.mirror(ctx, &operation{kind: operationUpdate, transaction: transaction, key: model.KeyOf(transaction)})
+}
+
+func (s *TransactionStorage) DeleteByMessageID(ctx context.Context, chatID string, messageID string) error {
+	err := s.primary.DeleteByMessageID(ctx, chatID, messageID)
+	if err != nil {
+		return err
+	}
+	key := model.TransactionKey{ChatID: chatID, MessageID: messageID}
+	return s.mirror(ctx, &operation{kind: operationDelete, transaction: nil, key: key})
+}
+
+func (s *TransactionStorage) Restore(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	transaction, err := s.primary.Restore(ctx, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	key := model.TransactionKey{ChatID: chatID, MessageID: messageID}
+	return transaction, s.mirror(ctx, &operation{kind: operationRestore, transaction: transaction, key: key})
+}
+
+// Purge purges the primary and then the secondaries right away, a secondary failure is only logged.
+func (s *TransactionStorage) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	purged, err := s.primary.Purge(ctx, deletedBefore)
+	if err != nil {
+		return purged, err
+	}
+	for _, sec := range s.secondaries {
+		_, err = sec.Storage.Purge(ctx, deletedBefore)
+		if err != nil {
+			slog.Error("secondary storage purge failed", slog.String("secondary", sec.Name), slog.Any("err", err))
+		}
+	}
+	return purged, nil
+}
+
+func (s *TransactionStorage) Get(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	return s.primary.Get(ctx, chatID, messageID)
+}
+
+func (s *TransactionStorage) List(ctx context.Context) ([]*model.Transaction, error) {
+	return s.primary.List(ctx)
+}
+
+func (s *TransactionStorage) ListPages(ctx context.Context, size int, page func([]*model.Transaction) error) error {
+	return storage.ListPages(ctx, s.primary, size, page)
+}
+
+// Secondaries returns the storages the writes are repeated to.
+func (s *TransactionStorage) Secondaries() []Secondary {
+	result := make([]Secondary, 0, len(s.secondaries))
+	for _, sec := range s.secondaries {
+		result = append(result, sec.Secondary)
+	}
+	return result
+}
+
+// Flush waits until the background writes queued so far are applied, the writes are expected to be stopped.
+func (s *TransactionStorage) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	go func() {
+		for _, sec := range s.secondaries {
+			sec.pending.Wait()
+		}
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		for _, sec := range s.secondaries {
+			if len(sec.queue) > 0 {
+				slog.Warn("secondary storage writes are not flushed", slog.String("secondary", sec.Name), slog.Int("count", len(sec.queue)))
+			}
+		}
+		return fmt.Errorf("secondary storages are not flushed: %w", ctx.Err())
+	}
+}
+
+func (s *TransactionStorage) mirror(ctx context.Context, op *operation) error {
+	if s.config.Consistency != ConsistencyAll {
+		for _, sec := range s.secondaries {
+			sec.pending.Add(1)
+			select {
+			case sec.queue <- op:
+			default:
+				sec.pending.Done()
+				logDivergence(sec, op, errors.New("the queue is full"))
+			}
+		}
+		return nil
+	}
+
+	var errs []error
+	for _, sec := range s.secondaries {
+		err := s.applyWithRetry(ctx, sec, op)
+		if err != nil {
+			logDivergence(sec, op, err)
+			errs = append(errs, fmt.Errorf("%s: %w", sec.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		// the errors of the secondaries are not wrapped: a temporary one would make the caller repeat the whole write
+		return fmt.Errorf("%w: %w: %v", storage.ErrOperationFailed, ErrSecondariesNotWritten, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (s *TransactionStorage) runSecondary(ctx context.Context, sec *secondary) {
+	for {
+		select {
+		case <-ctx.Done():
+			if len(sec.queue) > 0 {
+				slog.Warn("secondary storage writes are dropped on exit", slog.String("secondary", sec.Name), slog.Int("count", len(sec.queue)))
+			}
+			return
+		case op := <-sec.queue:
+			err := s.applyWithRetry(ctx, sec, op)
+			if err != nil {
+				logDivergence(sec, op, err)
+			}
+			sec.pending.Done()
+		}
+	}
+}
+
+func (s *TransactionStorage) applyWithRetry(ctx context.Context, sec *secondary, op *operation) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = applyOperation(ctx, sec.Storage, op)
+		if err == nil || attempt >= s.config.RetryAttempts {
+			return err
+		}
+
+		slog.Warn("secondary storage write failed, retrying",
+			slog.String("secondary", sec.Name), slog.Int("attempt", attempt), slog.Any("err", err))
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(s.config.RetryInterval):
+		}
+	}
+}
+
+// applyOperation repeats the write, a secondary missing the transaction or having it already is not an error.
+func applyOperation(ctx context.Context, target storage.TransactionStorage, op *operation) error {
+	switch op.kind {
+	case operationInsert:
+		return target.Insert(ctx, op.transaction)
+	case operationUpdate:
+		err := target.Update(ctx, op.transaction)
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			slog.Warn("secondary storage misses the updated transaction, inserting it", slog.Any("key", op.key))
+			return target.Insert(ctx, op.transaction)
+		}
+		return err
+	case operationDelete:
+		err := target.DeleteByMessageID(ctx, op.key.ChatID, op.key.MessageID)
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			slog.Warn("secondary storage misses the removed transaction", slog.Any("key", op.key))
+			return nil
+		}
+		return err
+	case operationRestore:
+		_, err := target.Restore(ctx, op.key.ChatID, op.key.MessageID)
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			slog.Warn("secondary storage misses the restored transaction, inserting it", slog.Any("key", op.key))
+			return target.Insert(ctx, op.transaction)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown operation %q", op.kind)
+}
+
+func logDivergence(sec *secondary, op *operation, err error) {
+	slog.Error("secondary storage diverged from the primary, run the backfill",
+		slog.String("secondary", sec.Name),
+		slog.String("operation", string(op.kind)),
+		slog.Any("key", op.key),
+		slog.Any("err", err))
+}
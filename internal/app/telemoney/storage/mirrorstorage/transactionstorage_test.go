@@ -0,0 +1,115 @@
+package mirrorstorage_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/mirrorstorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/sqlitestorage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// failingStorage fails the first failures writes as temporarily unavailable.
+type failingStorage struct {
+	storage.TransactionStorage
+	failures int
+}
+
+func (s *failingStorage) Insert(ctx context.Context, transaction *model.Transaction) error {
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("%w: %w", storage.ErrOperationFailed, storage.ErrTemporarilyUnavailable)
+	}
+	return s.TransactionStorage.Insert(ctx, transaction)
+}
+
+func newSQLiteStorage(t *testing.T, name string) *sqlitestorage.TransactionStorage {
+	s, err := sqlitestorage.New(&sqlitestorage.Config{Path: filepath.Join(t.TempDir(), name)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func makeTransaction(messageID string, amount float64) *model.Transaction {
+	return &model.Transaction{CreatedAt: 1710000000, MessageID: messageID, ChatID: "7", UserID: "8", Amount: amount, Category: "lunch", Tags: nil, Comment: nil}
+}
+
+func TestTransactionStorage_ConsistencyAll(t *testing.T) {
+	primary := newSQLiteStorage(t, "primary.db")
+	secondary := &failingStorage{TransactionStorage: newSQLiteStorage(t, "secondary.db"), failures: 1}
+	s := mirrorstorage.New(context.Background(), &mirrorstorage.Config{
+		Consistency:   mirrorstorage.ConsistencyAll,
+		RetryAttempts: 2,
+		RetryInterval: time.Millisecond,
+		QueueSize:     0,
+	}, primary, []mirrorstorage.Secondary{{Name: "secondary", Storage: secondary}})
+
+	// the first attempt fails, the retry succeeds
+	require.NoError(t, s.Insert(context.Background(), makeTransaction("1", 9.5)))
+	transactions, err := secondary.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+
+	secondary.failures = 2
+	err = s.Insert(context.Background(), makeTransaction("2", 3))
+	require.ErrorIs(t, err, storage.ErrOperationFailed)
+	require.ErrorIs(t, err, mirrorstorage.ErrSecondariesNotWritten)
+	// the primary is written, the write is not to be repeated
+	require.NotErrorIs(t, err, storage.ErrTemporarilyUnavailable)
+	_, err = primary.Get(context.Background(), "7", "2")
+	require.NoError(t, err)
+
+	// the secondary missing the transaction gets it on the update
+	require.NoError(t, s.Update(context.Background(), makeTransaction("2", 4)))
+	transactions, err = secondary.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{makeTransaction("1", 9.5), makeTransaction("2", 4)}, transactions)
+}
+
+func TestTransactionStorage_ConsistencyPrimary(t *testing.T) {
+	primary := newSQLiteStorage(t, "primary.db")
+	secondary := newSQLiteStorage(t, "secondary.db")
+	s := mirrorstorage.New(context.Background(), &mirrorstorage.Config{
+		Consistency:   mirrorstorage.ConsistencyPrimary,
+		RetryAttempts: 1,
+		RetryInterval: 0,
+		QueueSize:     10,
+	}, primary, []mirrorstorage.Secondary{{Name: "secondary", Storage: secondary}})
+
+	require.ErrorIs(t, s.DeleteByMessageID(context.Background(), "7", "1"), storage.ErrTransactionNotFound)
+	require.NoError(t, s.Insert(context.Background(), makeTransaction("1", 9.5)))
+	require.NoError(t, s.Update(context.Background(), makeTransaction("1", 10)))
+
+	// the background writes are applied when Flush returns
+	require.NoError(t, s.Flush(context.Background()))
+	transactions, err := secondary.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{makeTransaction("1", 10)}, transactions)
+}
+
+func TestBackfill(t *testing.T) {
+	primary := newSQLiteStorage(t, "primary.db")
+	secondary := newSQLiteStorage(t, "secondary.db")
+	for _, transaction := range []*model.Transaction{makeTransaction("1", 1), makeTransaction("2", 2), makeTransaction("3", 3)} {
+		require.NoError(t, primary.Insert(context.Background(), transaction))
+	}
+	require.NoError(t, secondary.Insert(context.Background(), makeTransaction("1", 1)))
+	require.NoError(t, secondary.Insert(context.Background(), makeTransaction("2", 20)))
+	require.NoError(t, secondary.Insert(context.Background(), makeTransaction("4", 4)))
+
+	result, err := mirrorstorage.Backfill(context.Background(), primary, secondary)
+	require.NoError(t, err)
+	require.Equal(t, &mirrorstorage.BackfillResult{Written: 2, Removed: 1, Unchanged: 1}, result)
+
+	transactions, err := secondary.List(context.Background())
+	require.NoError(t, err)
+	expected, err := primary.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, expected, transactions)
+}
@@ -0,0 +1,282 @@
+// Package sqlitestorage keeps transactions in a SQLite file, for analytics next to the spreadsheet.
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // the driver
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS transactions (
+	chat_id    TEXT NOT NULL DEFAULT '',
+	message_id TEXT NOT NULL,
+	user_id    TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	amount     REAL NOT NULL,
+	category   TEXT NOT NULL,
+	tags       TEXT NOT NULL DEFAULT '',
+	comment    TEXT,
+	deleted_at INTEGER,
+	PRIMARY KEY (chat_id, message_id)
+);
+CREATE INDEX IF NOT EXISTS transactions_created_at ON transactions (created_at);
+`
+
+// migrations bring the databases created by the older versions to the schema, they are safe to repeat
+var migrations = []struct {
+	check string // returns a row if the migration is applied
+	apply string
+}{
+	{
+		check: `SELECT 1 FROM pragma_table_info('transactions') WHERE name = 'deleted_at'`,
+		apply: `ALTER TABLE transactions ADD COLUMN deleted_at INTEGER`,
+	},
+	{
+		// the key becomes (chat_id, message_id), the table is rebuilt for that
+		check: `SELECT 1 FROM pragma_table_info('transactions') WHERE name = 'chat_id'`,
+		apply: `
+			BEGIN;
+			CREATE TABLE transactions_chat (
+				chat_id    TEXT NOT NULL DEFAULT '',
+				message_id TEXT NOT NULL,
+				user_id    TEXT NOT NULL DEFAULT '',
+				created_at INTEGER NOT NULL,
+				amount     REAL NOT NULL,
+				category   TEXT NOT NULL,
+				tags       TEXT NOT NULL DEFAULT '',
+				comment    TEXT,
+				deleted_at INTEGER,
+				PRIMARY KEY (chat_id, message_id)
+			);
+			INSERT INTO transactions_chat (message_id, created_at, amount, category, tags, comment, deleted_at)
+				SELECT message_id, created_at, amount, category, tags, comment, deleted_at FROM transactions;
+			DROP TABLE transactions;
+			ALTER TABLE transactions_chat RENAME TO transactions;
+			CREATE INDEX transactions_created_at ON transactions (created_at);
+			COMMIT;`,
+	},
+}
+
+type Config struct {
+	Path string
+}
+
+type TransactionStorage struct {
+	db *sql.DB
+}
+
+// New opens the database file, it is created with the schema if missing.
+func New(config *Config) (*TransactionStorage, error) {
+	err := os.MkdirAll(filepath.Dir(config.Path), 0o750) //nolint:gomnd // rwx for the owner, rx for the group
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+config.Path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite takes one writer at a time anyway
+	db.SetMaxOpenConns(1)
+
+	err = migrate(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &TransactionStorage{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(schema)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		var applied int
+		err = db.QueryRow(migration.check).Scan(&applied)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		_, err = db.Exec(migration.apply)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TransactionStorage) Close() error {
+	return s.db.Close()
+}
+
+// keyCondition selects the transaction with the chat id and the message id, the one without the chat id
+// if the chat has none, the args are the chat id and the message id.
+const keyCondition = `rowid = (
+	SELECT rowid FROM transactions WHERE chat_id IN (?1, '') AND message_id = ?2 AND %s ORDER BY chat_id = '' LIMIT 1)`
+
+// Insert writes the transaction, a transaction with the same chat and message id is replaced, so repeating an insert
+// is safe. A deleted transaction is replaced too and isn't deleted anymore.
+func (s *TransactionStorage) Insert(ctx context.Context, transaction *model.Transaction) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO transactions (chat_id, message_id, user_id, created_at, amount, category, tags, comment)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chat_id, message_id) DO UPDATE SET
+			user_id = excluded.user_id, created_at = excluded.created_at, amount = excluded.amount,
+			category = excluded.category, tags = excluded.tags, comment = excluded.comment, deleted_at = NULL`,
+		transaction.ChatID, transaction.MessageID, transaction.UserID, transaction.CreatedAt, transaction.Amount,
+		transaction.Category, strings.Join(transaction.Tags, ","), transaction.Comment)
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+	return nil
+}
+
+// Update changes the transaction, one without the chat id gets it.
+func (s *TransactionStorage) Update(ctx context.Context, transaction *model.Transaction) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE transactions SET chat_id = ?3, user_id = ?4, created_at = ?5, amount = ?6, category = ?7, tags = ?8, comment = ?9
+		WHERE `+fmt.Sprintf(keyCondition, `deleted_at IS NULL`),
+		transaction.ChatID, transaction.MessageID, transaction.ChatID, transaction.UserID, transaction.CreatedAt,
+		transaction.Amount, transaction.Category, strings.Join(transaction.Tags, ","), transaction.Comment)
+	return checkAffected(result, err)
+}
+
+func (s *TransactionStorage) DeleteByMessageID(ctx context.Context, chatID string, messageID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE transactions SET deleted_at = ?3 WHERE `+fmt.Sprintf(keyCondition, `deleted_at IS NULL`),
+		chatID, messageID, time.Now().Unix())
+	return checkAffected(result, err)
+}
+
+func (s *TransactionStorage) Restore(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	var rowID int64
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE transactions SET deleted_at = NULL WHERE `+fmt.Sprintf(keyCondition, `deleted_at IS NOT NULL`)+`
+		RETURNING rowid`, chatID, messageID).Scan(&rowID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+
+	transactions, err := s.query(ctx, `WHERE rowid = ?`, 0, rowID)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, storage.ErrTransactionNotFound
+	}
+	return transactions[0], nil
+}
+
+func (s *TransactionStorage) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM transactions WHERE deleted_at < ?`, deletedBefore.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+	return int(purged), nil
+}
+
+func (s *TransactionStorage) Get(ctx context.Context, chatID string, messageID string) (*model.Transaction, error) {
+	transactions, err := s.query(ctx, `WHERE `+fmt.Sprintf(keyCondition, `deleted_at IS NULL`), 0, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, storage.ErrTransactionNotFound
+	}
+	return transactions[0], nil
+}
+
+// List returns the transactions that are not deleted ordered by the date.
+func (s *TransactionStorage) List(ctx context.Context) ([]*model.Transaction, error) {
+	return s.query(ctx, `WHERE deleted_at IS NULL`, 0)
+}
+
+// ListPages lists the transactions that are not deleted ordered by the date, a page starts after the last
+// transaction of the previous one.
+func (s *TransactionStorage) ListPages(ctx context.Context, size int, page func([]*model.Transaction) error) error {
+	transactions, err := s.query(ctx, `WHERE deleted_at IS NULL`, size)
+	for err == nil && len(transactions) > 0 {
+		err = page(transactions)
+		if err != nil || len(transactions) < size {
+			return err
+		}
+		last := transactions[len(transactions)-1]
+		transactions, err = s.query(ctx, `WHERE deleted_at IS NULL AND (created_at, chat_id, message_id) > (?, ?, ?)`, size,
+			last.CreatedAt, last.ChatID, last.MessageID)
+	}
+	return err
+}
+
+// query returns the transactions matching where ordered by the date, up to limit of them (0 - all).
+func (s *TransactionStorage) query(ctx context.Context, where string, limit int, args ...interface{}) ([]*model.Transaction, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chat_id, message_id, user_id, created_at, amount, category, tags, comment FROM transactions `+where+`
+		ORDER BY created_at, chat_id, message_id`+limitClause(limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+	defer rows.Close()
+
+	var transactions []*model.Transaction
+	for rows.Next() {
+		var transaction model.Transaction
+		var tags string
+		err = rows.Scan(&transaction.ChatID, &transaction.MessageID, &transaction.UserID, &transaction.CreatedAt,
+			&transaction.Amount, &transaction.Category, &tags, &transaction.Comment)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+		}
+		if tags != "" {
+			transaction.Tags = strings.Split(tags, ",")
+		}
+		transactions = append(transactions, &transaction)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+	return transactions, nil
+}
+
+func limitClause(limit int) string {
+	if limit == 0 {
+		return ""
+	}
+	return ` LIMIT ` + strconv.Itoa(limit)
+}
+
+func checkAffected(result sql.Result, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrOperationFailed, err)
+	}
+	if affected == 0 {
+		return storage.ErrTransactionNotFound
+	}
+	return nil
+}
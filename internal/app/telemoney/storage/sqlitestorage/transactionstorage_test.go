@@ -0,0 +1,105 @@
+package sqlitestorage_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/sqlitestorage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+func TestTransactionStorage(t *testing.T) {
+	s, err := sqlitestorage.New(&sqlitestorage.Config{Path: filepath.Join(t.TempDir(), "telemoney.db")})
+	require.NoError(t, err)
+	defer s.Close()
+
+	comment := "I need food!"
+	lunch := &model.Transaction{CreatedAt: 1710000000, MessageID: "1", ChatID: "10", UserID: "20", Amount: 9.5, Category: "lunch", Tags: []string{"a", "b"}, Comment: &comment}
+	coffee := &model.Transaction{CreatedAt: 1710000100, MessageID: "2", ChatID: "10", UserID: "20", Amount: 3, Category: "coffee", Tags: nil, Comment: nil}
+	otherChat := &model.Transaction{CreatedAt: 1710000200, MessageID: "2", ChatID: "11", UserID: "21", Amount: 4, Category: "tea", Tags: nil, Comment: nil}
+	require.NoError(t, s.Insert(context.Background(), lunch))
+	require.NoError(t, s.Insert(context.Background(), coffee))
+	require.NoError(t, s.Insert(context.Background(), coffee)) // repeating is fine
+	require.NoError(t, s.Insert(context.Background(), otherChat))
+
+	lunch.Amount = 95
+	require.NoError(t, s.Update(context.Background(), lunch))
+	require.NoError(t, s.DeleteByMessageID(context.Background(), "10", "2"))
+	require.ErrorIs(t, s.DeleteByMessageID(context.Background(), "10", "2"), storage.ErrTransactionNotFound)
+	require.ErrorIs(t, s.Update(context.Background(), coffee), storage.ErrTransactionNotFound)
+
+	transactions, err := s.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{lunch, otherChat}, transactions)
+
+	restored, err := s.Restore(context.Background(), "10", "2")
+	require.NoError(t, err)
+	require.Equal(t, coffee, restored)
+	_, err = s.Restore(context.Background(), "10", "2")
+	require.ErrorIs(t, err, storage.ErrTransactionNotFound)
+
+	require.NoError(t, s.DeleteByMessageID(context.Background(), "10", "2"))
+	purged, err := s.Purge(context.Background(), time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, purged)
+	purged, err = s.Purge(context.Background(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	_, err = s.Restore(context.Background(), "10", "2")
+	require.ErrorIs(t, err, storage.ErrTransactionNotFound)
+}
+
+func TestTransactionStorage_MigratesToChatKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemoney.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE transactions (
+			message_id TEXT PRIMARY KEY, created_at INTEGER NOT NULL, amount REAL NOT NULL, category TEXT NOT NULL,
+			tags TEXT NOT NULL DEFAULT '', comment TEXT, deleted_at INTEGER);
+		INSERT INTO transactions (message_id, created_at, amount, category) VALUES ('1', 1710000000, 9.5, 'lunch');`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	s, err := sqlitestorage.New(&sqlitestorage.Config{Path: path})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// the old transaction has no chat, it is found from any chat and gets the chat with the update
+	lunch := &model.Transaction{CreatedAt: 1710000000, MessageID: "1", ChatID: "10", UserID: "20", Amount: 95, Category: "lunch", Tags: nil, Comment: nil}
+	require.NoError(t, s.Update(context.Background(), lunch))
+	transactions, err := s.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []*model.Transaction{lunch}, transactions)
+}
+
+func TestTransactionStorage_ListPages(t *testing.T) {
+	s, err := sqlitestorage.New(&sqlitestorage.Config{Path: filepath.Join(t.TempDir(), "telemoney.db")})
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i, messageID := range []string{"1", "2", "3", "4", "5"} {
+		require.NoError(t, s.Insert(context.Background(), &model.Transaction{
+			CreatedAt: 1710000000 + int64(i/2), MessageID: messageID, ChatID: "10", UserID: "20", Amount: 1, Category: "tea", Tags: nil, Comment: nil,
+		}))
+	}
+	require.NoError(t, s.DeleteByMessageID(context.Background(), "10", "3"))
+
+	var pages [][]string
+	err = s.ListPages(context.Background(), 2, func(transactions []*model.Transaction) error {
+		var page []string
+		for _, transaction := range transactions {
+			page = append(page, transaction.MessageID)
+		}
+		pages = append(pages, page)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"1", "2"}, {"4", "5"}}, pages)
+}
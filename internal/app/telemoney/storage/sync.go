@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"slices"
+
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// SyncPageSize is how many transactions of the source Sync holds at a time.
+const SyncPageSize = 500
+
+// TransactionPager lists the transactions in parts, the storages that can read them so implement it.
+type TransactionPager interface {
+	// ListPages calls page with the transactions that are not deleted, up to size of them at a time
+	ListPages(ctx context.Context, size int, page func([]*model.Transaction) error) error
+}
+
+// ListPages lists the transactions of the storage page by page, a storage that is not a TransactionPager
+// is listed at once and split.
+func ListPages(ctx context.Context, s TransactionStorage, size int, page func([]*model.Transaction) error) error {
+	if pager, ok := s.(TransactionPager); ok {
+		return pager.ListPages(ctx, size, page)
+	}
+
+	transactions, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for len(transactions) > 0 {
+		n := min(size, len(transactions))
+		err = page(transactions[:n])
+		if err != nil {
+			return err
+		}
+		transactions = transactions[n:]
+	}
+	return nil
+}
+
+type SyncResult struct {
+	Inserted  int // missing in the target
+	Updated   int // different in the target, the source wins
+	Unchanged int
+	Extra     []model.TransactionKey // only the target has them, Sync leaves them as they are
+}
+
+// Sync writes the transactions of the source the target misses or has different to the target. The source is read
+// page by page, the target is kept as a map of its transactions meanwhile. visit, if set, is called with every
+// transaction of the source once it is in the target.
+func Sync(
+	ctx context.Context,
+	source TransactionStorage,
+	target TransactionStorage,
+	visit func(*model.Transaction),
+) (*SyncResult, error) {
+	targetTransactions, err := target.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[model.TransactionKey]*model.Transaction, len(targetTransactions))
+	for _, transaction := range targetTransactions {
+		existing[model.KeyOf(transaction)] = transaction
+	}
+
+	result := &SyncResult{Inserted: 0, Updated: 0, Unchanged: 0, Extra: nil}
+	err = ListPages(ctx, source, SyncPageSize, func(transactions []*model.Transaction) error {
+		for _, transaction := range transactions {
+			known, ok := existing[model.KeyOf(transaction)]
+			delete(existing, model.KeyOf(transaction))
+			switch {
+			case !ok:
+				err := target.Insert(ctx, transaction)
+				if err != nil {
+					return err
+				}
+				result.Inserted++
+			case !EqualTransactions(known, transaction):
+				err := target.Update(ctx, transaction)
+				if err != nil {
+					return err
+				}
+				result.Updated++
+			default:
+				result.Unchanged++
+			}
+			if visit != nil {
+				visit(transaction)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	for key := range existing {
+		result.Extra = append(result.Extra, key)
+	}
+	return result, nil
+}
+
+// EqualTransactions tells if the transactions are the same as the storages keep them: a missing comment equals
+// an empty one and missing tags equal no tags, the sheets can't tell them apart.
+func EqualTransactions(a, b *model.Transaction) bool {
+	return a.CreatedAt == b.CreatedAt &&
+		a.ChatID == b.ChatID &&
+		a.MessageID == b.MessageID &&
+		a.UserID == b.UserID &&
+		a.Amount == b.Amount &&
+		a.Category == b.Category &&
+		slices.Equal(a.Tags, b.Tags) &&
+		commentOf(a) == commentOf(b)
+}
+
+func commentOf(transaction *model.Transaction) string {
+	if transaction.Comment == nil {
+		return ""
+	}
+	return *transaction.Comment
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/mitrkos/telemoney/internal/model"
 )
@@ -9,8 +11,35 @@ import (
 var ErrTransactionNotFound = errors.New("transaction not found")
 var ErrOperationFailed = errors.New("operation failed")
 
+// ErrTemporarilyUnavailable comes together with ErrOperationFailed when repeating the operation later may succeed.
+var ErrTemporarilyUnavailable = errors.New("storage is temporarily unavailable")
+
+// TransactionStorage keeps the transactions by the chat id and the message id. The transactions stored before
+// the chat was kept have no chat id, they are found by the message id alone if the chat has no such transaction.
 type TransactionStorage interface {
-	Insert(*model.Transaction) error
-	Update(*model.Transaction) error
-	DeleteByMessageID(string) error
+	// Insert is idempotent, a transaction with the same chat and message id that is not deleted is replaced
+	Insert(context.Context, *model.Transaction) error
+	Update(context.Context, *model.Transaction) error
+	// DeleteByMessageID marks the transaction with the chat id and the message id deleted,
+	// it is kept until purged and can be restored till then
+	DeleteByMessageID(ctx context.Context, chatID string, messageID string) error
+	// Restore brings back the last deleted transaction with the chat id and the message id
+	Restore(ctx context.Context, chatID string, messageID string) (*model.Transaction, error)
+	// Purge removes the transactions deleted before the time for good, returns how many were removed
+	Purge(context.Context, time.Time) (int, error)
+	// Get returns the transaction with the chat id and the message id that is not deleted
+	Get(ctx context.Context, chatID string, messageID string) (*model.Transaction, error)
+	// List returns all stored transactions that are not deleted in no particular order
+	List(context.Context) ([]*model.Transaction, error)
+}
+
+// TransactionRecord is a stored transaction together with where it is kept, e.g. "transaction row 42".
+type TransactionRecord struct {
+	Transaction *model.Transaction
+	Place       string
+}
+
+// TransactionSource lists the transactions as they are stored, including the changes made by hand.
+type TransactionSource interface {
+	ListRecords(context.Context) ([]*TransactionRecord, error)
 }
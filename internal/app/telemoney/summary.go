@@ -0,0 +1,100 @@
+package telemoney
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/summary"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+func (t *Telemoney) handleSummaryCommand(ctx context.Context, call *model.CommandToHandle) {
+	msg := call.Message
+	settings := t.chatSettings.Get(msg.ChatID)
+	location := settings.LocationOr(t.config.Location)
+	ctx, cancel := t.withHandlerTimeout(ctx)
+	defer cancel()
+
+	transactions, err := t.refreshSummary(ctx, t.ledgers.LedgerOf(msg.ChatID))
+	if err != nil {
+		t.markMessageHandledFailure(msg)
+		return
+	}
+
+	now := time.Now().In(location)
+	s := summary.Build(chatTransactions(transactions, msg.ChatID), now)
+	_, _ = t.api.SendMessage(&model.MessageToSend{
+		ChatID: msg.ChatID,
+		Text:   formatMonthSummary(s, summary.MonthOf(now.Unix(), location), settings),
+	})
+}
+
+// refreshSummaryPeriodically rewrites the summaries of the ledgers every SummaryRefreshInterval until ctx is done.
+func (t *Telemoney) refreshSummaryPeriodically(ctx context.Context) {
+	if t.config.SummaryRefreshInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(t.config.SummaryRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, ledger := range t.ledgers.Ledgers() {
+				_, _ = t.refreshSummary(ctx, ledger)
+			}
+		}
+	}
+}
+
+// refreshSummary rewrites the summary of the ledger and returns the transactions of the ledger.
+func (t *Telemoney) refreshSummary(ctx context.Context, ledger Ledger) ([]*model.Transaction, error) {
+	transactions, err := ledger.List(ctx)
+	if err != nil {
+		slog.Error("can't list transactions for the summary", slog.Any("err", err))
+		return nil, err
+	}
+
+	err = ledger.WriteSummary(ctx, summary.Build(transactions, time.Now().In(t.config.Location)))
+	if err != nil {
+		slog.Error("can't write the summary", slog.Any("err", err))
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// chatTransactions returns the transactions of the chat, a ledger can keep several chats.
+func chatTransactions(transactions []*model.Transaction, chatID string) []*model.Transaction {
+	result := make([]*model.Transaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		if transaction.ChatID == chatID {
+			result = append(result, transaction)
+		}
+	}
+	return result
+}
+
+// formatMonthSummary makes the reply to /summary: the month total and the categories of the month.
+func formatMonthSummary(s *summary.Summary, month string, settings *chatsettings.Settings) string {
+	monthTotal := 0.0
+	for _, balance := range s.Balance {
+		if balance.Month == month {
+			monthTotal = balance.Amount
+		}
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s: %s\n", month, settings.FormatAmount(monthTotal))
+	for _, total := range s.Categories {
+		if amount, ok := total.ByMonth[month]; ok {
+			fmt.Fprintf(&text, "%s: %s\n", total.Name, settings.FormatAmount(amount))
+		}
+	}
+	return strings.TrimSuffix(text.String(), "\n")
+}
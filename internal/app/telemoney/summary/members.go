@@ -0,0 +1,113 @@
+package summary
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// settleEpsilon is the balance taken for settled, less than half a cent.
+const settleEpsilon = 0.005
+
+// MemberTotal is what a member of a shared chat paid.
+type MemberTotal struct {
+	UserID string
+	Amount float64
+	Count  int
+}
+
+// Transfer is a payment evening out the members, From pays Amount to To.
+type Transfer struct {
+	From   string
+	To     string
+	Amount float64
+}
+
+// MemberTotals sums the transactions matching inPeriod per sender. The senders of the other transactions are there
+// with 0, so they share the costs too. The transactions without the sender are left out.
+// Sorted by the amount from the biggest, then by the user id.
+func MemberTotals(transactions []*model.Transaction, inPeriod func(*model.Transaction) bool) []*MemberTotal {
+	totals := make(map[string]*MemberTotal)
+	for _, transaction := range transactions {
+		if transaction.UserID == "" {
+			continue
+		}
+		total, ok := totals[transaction.UserID]
+		if !ok {
+			total = &MemberTotal{UserID: transaction.UserID, Amount: 0, Count: 0}
+			totals[transaction.UserID] = total
+		}
+		if inPeriod(transaction) {
+			total.Amount += transaction.Amount
+			total.Count++
+		}
+	}
+
+	result := make([]*MemberTotal, 0, len(totals))
+	for _, total := range totals {
+		result = append(result, total)
+	}
+	slices.SortFunc(result, func(a, b *MemberTotal) int {
+		if a.Amount != b.Amount {
+			return cmp.Compare(b.Amount, a.Amount)
+		}
+		return cmp.Compare(a.UserID, b.UserID)
+	})
+	return result
+}
+
+// SettleUp splits the total equally between the members and returns the transfers evening them out:
+// the one owing the most pays the one owed the most until everyone is even, so there are at most n-1 transfers.
+// The amounts are rounded to cents.
+func SettleUp(totals []*MemberTotal) []*Transfer {
+	if len(totals) < 2 { //nolint:gomnd // nobody to settle with
+		return nil
+	}
+
+	sum := 0.0
+	for _, total := range totals {
+		sum += total.Amount
+	}
+	share := sum / float64(len(totals))
+
+	type balance struct {
+		userID string
+		amount float64 // > 0 - owed to the member, < 0 - the member owes
+	}
+	var creditors, debtors []*balance
+	for _, total := range totals {
+		amount := total.Amount - share
+		switch {
+		case amount > settleEpsilon:
+			creditors = append(creditors, &balance{userID: total.UserID, amount: amount})
+		case amount < -settleEpsilon:
+			debtors = append(debtors, &balance{userID: total.UserID, amount: -amount})
+		}
+	}
+	byAmount := func(a, b *balance) int {
+		if a.amount != b.amount {
+			return cmp.Compare(b.amount, a.amount)
+		}
+		return cmp.Compare(a.userID, b.userID)
+	}
+	slices.SortFunc(creditors, byAmount)
+	slices.SortFunc(debtors, byAmount)
+
+	var result []*Transfer
+	for len(creditors) > 0 && len(debtors) > 0 {
+		creditor, debtor := creditors[0], debtors[0]
+		amount := math.Min(creditor.amount, debtor.amount)
+		result = append(result, &Transfer{From: debtor.userID, To: creditor.userID, Amount: math.Round(amount*100) / 100}) //nolint:gomnd // cents
+		creditor.amount -= amount
+		debtor.amount -= amount
+		if creditor.amount <= settleEpsilon {
+			creditors = creditors[1:]
+		}
+		if debtor.amount <= settleEpsilon {
+			debtors = debtors[1:]
+		}
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+// Package summary aggregates transactions into totals for the dashboard and the bot replies.
+package summary
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+const monthLayout = "2006-01"
+
+// Writer keeps a readable summary of the transactions next to them.
+type Writer interface {
+	WriteSummary(context.Context, *Summary) error
+}
+
+type Summary struct {
+	GeneratedAt time.Time
+	Months      []string // "2006-01" in the location of GeneratedAt, from the oldest
+
+	Categories []*Total // sorted by the amount, from the biggest
+	Tags       []*Total // sorted by the amount, from the biggest
+	Balance    []*MonthBalance
+}
+
+type Total struct {
+	Name    string
+	Amount  float64
+	Count   int
+	ByMonth map[string]float64 // month -> amount
+}
+
+type MonthBalance struct {
+	Month   string
+	Amount  float64
+	Running float64 // the sum of the month and all months before
+}
+
+// Build aggregates the transactions per category per month, per tag and per month.
+// The months are in the location of now.
+func Build(transactions []*model.Transaction, now time.Time) *Summary {
+	categories := make(map[string]*Total)
+	tags := make(map[string]*Total)
+	months := make(map[string]float64)
+
+	for _, transaction := range transactions {
+		month := MonthOf(transaction.CreatedAt, now.Location())
+		months[month] += transaction.Amount
+		addToTotal(categories, transaction.Category, month, transaction.Amount)
+		for _, tag := range transaction.Tags {
+			addToTotal(tags, tag, month, transaction.Amount)
+		}
+	}
+
+	result := &Summary{
+		GeneratedAt: now,
+		Months:      make([]string, 0, len(months)),
+		Categories:  sortTotals(categories),
+		Tags:        sortTotals(tags),
+		Balance:     make([]*MonthBalance, 0, len(months)),
+	}
+	for month := range months {
+		result.Months = append(result.Months, month)
+	}
+	slices.Sort(result.Months)
+
+	running := 0.0
+	for _, month := range result.Months {
+		running += months[month]
+		result.Balance = append(result.Balance, &MonthBalance{Month: month, Amount: months[month], Running: running})
+	}
+	return result
+}
+
+// MonthOf returns the month of the unix time in the location as it is in the summary.
+func MonthOf(unixTime int64, location *time.Location) string {
+	return time.Unix(unixTime, 0).In(location).Format(monthLayout)
+}
+
+func addToTotal(totals map[string]*Total, name string, month string, amount float64) {
+	total, ok := totals[name]
+	if !ok {
+		total = &Total{Name: name, Amount: 0, Count: 0, ByMonth: make(map[string]float64)}
+		totals[name] = total
+	}
+	total.Amount += amount
+	total.Count++
+	total.ByMonth[month] += amount
+}
+
+func sortTotals(totals map[string]*Total) []*Total {
+	result := make([]*Total, 0, len(totals))
+	for _, total := range totals {
+		result = append(result, total)
+	}
+	slices.SortFunc(result, func(a, b *Total) int {
+		if a.Amount != b.Amount {
+			return cmp.Compare(b.Amount, a.Amount)
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return result
+}
@@ -0,0 +1,62 @@
+package summary_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/summary"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+func TestBuild(t *testing.T) {
+	transactions := []*model.Transaction{
+		{CreatedAt: 1710000000, MessageID: "1", Amount: 10, Category: "lunch", Tags: []string{"work"}, Comment: nil},
+		{CreatedAt: 1710000100, MessageID: "2", Amount: 5, Category: "coffee", Tags: []string{"work"}, Comment: nil},
+		{CreatedAt: 1712000000, MessageID: "3", Amount: 20, Category: "lunch", Tags: nil, Comment: nil},
+	}
+
+	s := summary.Build(transactions, time.Unix(1712000000, 0).UTC())
+
+	require.Equal(t, []string{"2024-03", "2024-04"}, s.Months)
+
+	require.Len(t, s.Categories, 2)
+	require.Equal(t, "lunch", s.Categories[0].Name)
+	require.InDelta(t, 30, s.Categories[0].Amount, 0.001)
+	require.Equal(t, map[string]float64{"2024-03": 10, "2024-04": 20}, s.Categories[0].ByMonth)
+
+	require.Len(t, s.Tags, 1)
+	require.Equal(t, 2, s.Tags[0].Count)
+	require.InDelta(t, 15, s.Tags[0].Amount, 0.001)
+
+	require.Equal(t, []*summary.MonthBalance{
+		{Month: "2024-03", Amount: 15, Running: 15},
+		{Month: "2024-04", Amount: 20, Running: 35},
+	}, s.Balance)
+}
+
+func TestMemberTotalsAndSettleUp(t *testing.T) {
+	transactions := []*model.Transaction{
+		{CreatedAt: 1710000000, MessageID: "1", ChatID: "-100", UserID: "1", Amount: 90, Category: "food", Tags: nil, Comment: nil},
+		{CreatedAt: 1710000100, MessageID: "2", ChatID: "-100", UserID: "2", Amount: 30, Category: "taxi", Tags: nil, Comment: nil},
+		{CreatedAt: 1710000200, MessageID: "3", ChatID: "-100", UserID: "", Amount: 100, Category: "rent", Tags: nil, Comment: nil},
+		// out of the period, its sender still shares the costs
+		{CreatedAt: 1700000000, MessageID: "4", ChatID: "-100", UserID: "3", Amount: 40, Category: "food", Tags: nil, Comment: nil},
+	}
+
+	totals := summary.MemberTotals(transactions, func(transaction *model.Transaction) bool {
+		return transaction.CreatedAt >= 1710000000
+	})
+	require.Equal(t, []*summary.MemberTotal{
+		{UserID: "1", Amount: 90, Count: 1},
+		{UserID: "2", Amount: 30, Count: 1},
+		{UserID: "3", Amount: 0, Count: 0},
+	}, totals)
+
+	require.Equal(t, []*summary.Transfer{
+		{From: "3", To: "1", Amount: 40},
+		{From: "2", To: "1", Amount: 10},
+	}, summary.SettleUp(totals))
+	require.Empty(t, summary.SettleUp(totals[:1]))
+}
@@ -0,0 +1,67 @@
+package telemoney
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/reconcile"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// handleTransactionsChanged reports the changes made by hand to the sync chat.
+func (t *Telemoney) handleTransactionsChanged(_ context.Context, events []*reconcile.Event) {
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		lines = append(lines, formatChangeEvent(event, t.config.Location))
+	}
+
+	_, _ = t.api.SendMessage(&model.MessageToSend{
+		ChatID: t.config.SyncNotifyChatID,
+		Text:   strings.Join(lines, "\n"),
+	})
+}
+
+// formatChangeEvent makes a line like "transaction row 42 changed: amount 9.5 → 95".
+func formatChangeEvent(event *reconcile.Event, location *time.Location) string {
+	switch event.Kind {
+	case reconcile.ChangeAdded:
+		return fmt.Sprintf("%s added: %s", event.After.Place, formatTransaction(event.After.Transaction))
+	case reconcile.ChangeRemoved:
+		return fmt.Sprintf("%s removed: %s", event.Before.Place, formatTransaction(event.Before.Transaction))
+	case reconcile.ChangeUpdated:
+		return fmt.Sprintf("%s changed: %s", event.After.Place, strings.Join(diffTransactions(event.Before.Transaction, event.After.Transaction, location), ", "))
+	}
+	return ""
+}
+
+func formatTransaction(transaction *model.Transaction) string {
+	return fmt.Sprintf("%v %s", transaction.Amount, transaction.Category)
+}
+
+func diffTransactions(before, after *model.Transaction, location *time.Location) []string {
+	var changes []string
+	addChange := func(field string, beforeValue, afterValue interface{}) {
+		if fmt.Sprint(beforeValue) != fmt.Sprint(afterValue) {
+			changes = append(changes, fmt.Sprintf("%s %v → %v", field, beforeValue, afterValue))
+		}
+	}
+	addChange("amount", before.Amount, after.Amount)
+	addChange("category", before.Category, after.Category)
+	addChange("tags", strings.Join(before.Tags, ","), strings.Join(after.Tags, ","))
+	addChange("comment", derefComment(before.Comment), derefComment(after.Comment))
+	addChange("date", formatDate(before.CreatedAt, location), formatDate(after.CreatedAt, location))
+	return changes
+}
+
+func formatDate(unixTime int64, location *time.Location) string {
+	return time.Unix(unixTime, 0).In(location).Format(time.DateTime)
+}
+
+func derefComment(comment *string) string {
+	if comment == nil {
+		return ""
+	}
+	return *comment
+}
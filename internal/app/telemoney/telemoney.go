@@ -1,11 +1,18 @@
 package telemoney
 
 import (
-	"errors"
+	"context"
 	"log/slog"
+	"sync"
 
+	"github.com/mitrkos/telemoney/internal/app/telemoney/access"
 	"github.com/mitrkos/telemoney/internal/app/telemoney/apihandler"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/outbox"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/reconcile"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/removals"
 	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/usernames"
 	"github.com/mitrkos/telemoney/internal/model"
 	parsing "github.com/mitrkos/telemoney/internal/pkg/parser"
 )
@@ -14,100 +21,234 @@ type Telemoney struct {
 	config             *Config
 	api                apihandler.MessageHandler
 	transactionStorage storage.TransactionStorage
+	ledgers            Ledgers
+	outbox             *outbox.Outbox
+	reconciler         *reconcile.Reconciler
 	parser             *parsing.Parser
+	access             *access.Control
+	chatSettings       *chatsettings.Store
+	spreadsheetLinker  SpreadsheetLinker
+	removals           *removals.Store
+	userNames          *usernames.Store
+	commands           []*command // the registry, see makeCommands
+
+	refusedMu sync.Mutex
+	refused   map[chatUser]bool // the senders refused already, see refuse
+
+	onboardingsMu sync.Mutex
+	onboardings   map[string]*onboarding // chat id -> its /start conversation
 }
 
 func New(
 	config *Config,
 	api apihandler.MessageHandler,
 	storage storage.TransactionStorage,
+	ledgers Ledgers,
+	outbox *outbox.Outbox,
+	reconciler *reconcile.Reconciler,
 	parser *parsing.Parser,
+	accessControl *access.Control,
+	chatSettings *chatsettings.Store,
+	spreadsheetLinker SpreadsheetLinker,
+	removals *removals.Store,
+	userNames *usernames.Store,
 ) *Telemoney {
 	t := Telemoney{
 		config:             config,
 		api:                api,
 		transactionStorage: storage,
+		ledgers:            ledgers,
+		outbox:             outbox,
+		reconciler:         reconciler,
 		parser:             parser,
+		access:             accessControl,
+		chatSettings:       chatSettings,
+		spreadsheetLinker:  spreadsheetLinker,
+		commands:           nil,
+		removals:           removals,
+		userNames:          userNames,
+		refusedMu:          sync.Mutex{},
+		refused:            make(map[chatUser]bool),
+		onboardingsMu:      sync.Mutex{},
+		onboardings:        make(map[string]*onboarding),
+	}
+
+	t.outbox.SetDeliveryHandlers(t.handleOutboxEntryDelivered, t.handleOutboxEntryFailed)
+	t.reconciler.AddChangeHandler(t.recordSyncChanges)
+	if t.config.SyncNotifyChatID != "" {
+		t.reconciler.AddChangeHandler(t.handleTransactionsChanged)
 	}
 
-	t.api.SetUpdateHandlerStartCommand(t.handleStartCommand)
-	t.api.SetUpdateHandlerRemoveMessageCommand(t.handleRemoveMessageCommand)
-	t.api.SetUpdateHandlerEditedMessage(t.handleEditedMessage)
-	t.api.SetUpdateHandlerMessage(t.handleMessage)
+	t.commands = t.makeCommands()
+	t.routeCommands()
+	t.api.SetUpdateHandlerEditedMessage(t.authorized(access.ActionWrite, t.handleEditedMessage))
+	t.api.SetUpdateHandlerMessage(t.authorized(access.ActionWrite, t.handleMessage))
 
 	return &t
 }
 
-func (t *Telemoney) Start() error {
-	err := t.api.ListenToUpdates()
+// Start listens to updates until ctx is done. Then it stops taking updates, lets the handlers in flight finish
+// (within Config.ShutdownTimeout) and returns when the background jobs are stopped too.
+func (t *Telemoney) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var background sync.WaitGroup
+	runInBackground := func(job func(context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			job(ctx)
+		}()
+	}
+	runInBackground(t.outbox.Run)
+	runInBackground(t.refreshSummaryPeriodically)
+	runInBackground(t.purgePeriodically)
+	if t.config.SyncInterval > 0 {
+		runInBackground(t.reconciler.Run)
+	}
+
+	t.registerCommands()
+	err := t.api.ListenToUpdates(ctx)
+	cancel()
+	background.Wait()
+	if queued := t.outbox.Len(); queued > 0 {
+		slog.Info("outbox entries are left for the next start", slog.Int("count", queued))
+	}
 
 	if err != nil {
 		slog.Error("problem with listening to tg", slog.Any("err", err))
 		return err
 	}
+	slog.Info("telemoney is stopped")
 	return nil
 }
 
-func (t *Telemoney) handleStartCommand() {
-	// TODO
-}
+// handleRemoveMessageCommand deletes the transaction of the message the command replies to.
+func (t *Telemoney) handleRemoveMessageCommand(ctx context.Context, call *model.CommandToHandle) {
+	msg := call.ReplyTo
 
-func (t *Telemoney) handleRemoveMessageCommand(msg *model.MessageToHandle) {
-	if msg == nil {
-		// TODO: info msg
-		// t.api.SendMessage(&model.MessageToSend{
-		// 	ChatID: msg.ChatID,
-		// 	Text:   "/remove command should be a reply to a message",
-		// })
-		// t.markMessageHandledFailure(msg)
-		return
-	}
+	ctx, cancel := t.withHandlerTimeout(ctx)
+	defer cancel()
 
-	err := t.transactionStorage.DeleteByMessageID(msg.MessageID)
+	entry := &outbox.Entry{
+		Kind:        outbox.OperationDelete,
+		Transaction: nil,
+		MessageID:   msg.MessageID,
+		ChatID:      msg.ChatID,
+		UserID:      call.Message.UserID,
+		Before:      nil,
+	}
+	queued, err := t.outbox.Submit(ctx, entry)
 	if err != nil {
+		slog.Error("can't remove the transaction", slog.Any("err", err), slog.String("messageID", msg.MessageID))
+		t.markMessageHandledFailure(call.Message)
+		return
+	}
+	if queued {
+		t.markMessageHandleQueued(msg.ChatID, msg.MessageID)
 		return
 	}
 
-	_ = t.api.RemoveMessage(&model.MessageToInteract{
-		ChatID:    msg.ChatID,
-		MessageID: msg.MessageID,
-	})
+	t.recordEntry(ctx, entry)
+	t.addRemoval(msg.ChatID, msg.MessageID)
+	t.removeMessage(msg.ChatID, msg.MessageID)
 }
 
-func (t *Telemoney) handleEditedMessage(msg *model.MessageToHandle) {
+func (t *Telemoney) handleEditedMessage(ctx context.Context, msg *model.MessageToHandle) {
 	transaction, err := convertMessageIntoTransaction(t.parser, msg)
 	if err != nil {
 		t.markMessageHandledFailure(msg)
 		return
 	}
 
-	err = t.transactionStorage.Update(transaction)
-	if errors.Is(err, storage.ErrTransactionNotFound) {
-		err = t.transactionStorage.Insert(transaction)
+	ctx, cancel := t.withHandlerTimeout(ctx)
+	defer cancel()
+
+	t.submitTransaction(ctx, outbox.OperationUpsert, transaction, msg)
+}
+
+func (t *Telemoney) handleMessage(ctx context.Context, msg *model.MessageToHandle) {
+	if t.answerOnboarding(ctx, msg) {
+		return
 	}
 
+	transaction, err := convertMessageIntoTransaction(t.parser, msg)
 	if err != nil {
 		t.markMessageHandledFailure(msg)
 		return
 	}
 
-	t.markMessageHandleSuccess(msg)
+	ctx, cancel := t.withHandlerTimeout(ctx)
+	defer cancel()
+
+	t.submitTransaction(ctx, outbox.OperationInsert, transaction, msg)
 }
 
-func (t *Telemoney) handleMessage(msg *model.MessageToHandle) {
-	transaction, err := convertMessageIntoTransaction(t.parser, msg)
-	if err != nil {
+func (t *Telemoney) submitTransaction(
+	ctx context.Context,
+	kind outbox.OperationKind,
+	transaction *model.Transaction,
+	msg *model.MessageToHandle,
+) {
+	entry := &outbox.Entry{
+		Kind:        kind,
+		Transaction: transaction,
+		MessageID:   msg.MessageID,
+		ChatID:      msg.ChatID,
+		UserID:      msg.UserID,
+		Before:      nil,
+	}
+	queued, err := t.outbox.Submit(ctx, entry)
+	switch {
+	case err != nil:
 		t.markMessageHandledFailure(msg)
-		return
+	case queued:
+		t.markMessageHandleQueued(msg.ChatID, msg.MessageID)
+	default:
+		t.recordEntry(ctx, entry)
+		t.markMessageHandleSuccess(msg)
 	}
+}
 
-	err = t.transactionStorage.Insert(transaction)
-	if err != nil {
-		t.markMessageHandledFailure(msg)
-		return
+func (t *Telemoney) handleOutboxEntryDelivered(ctx context.Context, entry *outbox.Entry) {
+	switch entry.Kind {
+	case outbox.OperationInsert, outbox.OperationUpsert:
+		t.recordEntry(ctx, entry)
+		_ = t.api.MarkMessageProcessedOK(&model.MessageToInteract{
+			ChatID:    entry.ChatID,
+			MessageID: entry.MessageID,
+		})
+	case outbox.OperationDelete:
+		t.recordEntry(ctx, entry)
+		t.addRemoval(entry.ChatID, entry.MessageID)
+		t.removeMessage(entry.ChatID, entry.MessageID)
+	case outbox.OperationRestore:
+		t.postRestored(ctx, entry)
+	case outbox.OperationMove:
+		// postRestored has recorded it with the restore
 	}
+}
+
+func (t *Telemoney) handleOutboxEntryFailed(_ context.Context, entry *outbox.Entry, _ error) {
+	_ = t.api.MarkMessageProcessedFail(&model.MessageToInteract{
+		ChatID:    entry.ChatID,
+		MessageID: entry.MessageID,
+	})
+}
+
+func (t *Telemoney) removeMessage(chatID string, messageID string) {
+	_ = t.api.RemoveMessage(&model.MessageToInteract{
+		ChatID:    chatID,
+		MessageID: messageID,
+	})
+}
 
-	t.markMessageHandleSuccess(msg)
+func (t *Telemoney) withHandlerTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.config.HandlerTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, t.config.HandlerTimeout)
 }
 
 func (t *Telemoney) markMessageHandleSuccess(msg *model.MessageToHandle) {
@@ -117,6 +258,13 @@ func (t *Telemoney) markMessageHandleSuccess(msg *model.MessageToHandle) {
 	})
 }
 
+func (t *Telemoney) markMessageHandleQueued(chatID string, messageID string) {
+	_ = t.api.MarkMessageProcessedQueued(&model.MessageToInteract{
+		ChatID:    chatID,
+		MessageID: messageID,
+	})
+}
+
 func (t *Telemoney) markMessageHandledFailure(msg *model.MessageToHandle) {
 	_ = t.api.MarkMessageProcessedFail(&model.MessageToInteract{
 		ChatID:    msg.ChatID,
@@ -137,6 +285,8 @@ func convertMessageIntoTransaction(parser *parsing.Parser, msg *model.MessageToH
 	return &model.Transaction{
 		CreatedAt: msg.CreatedAt,
 		MessageID: msg.MessageID,
+		ChatID:    msg.ChatID,
+		UserID:    msg.UserID,
 		Amount:    userInputData.Amount,
 		Category:  userInputData.Category,
 		Tags:      userInputData.Tags,
@@ -0,0 +1,284 @@
+package telemoney_test
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/access"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/chatsettings"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/outbox"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/reconcile"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/removals"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage/gsheetstorage"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/usernames"
+	"github.com/mitrkos/telemoney/internal/model"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient/gsheetfake"
+	parsing "github.com/mitrkos/telemoney/internal/pkg/parser"
+)
+
+const (
+	testSpreadsheetID = "1DNP3yNOA03Qd52u6HPAw4uGQLSpQac2o5JaaI-9JjGs"
+	testSheetID       = "transaction_test"
+	ownerID           = "1"
+	memberID          = "2"
+	readOnlyID        = "4"
+	groupChatID       = "-100"
+)
+
+// fakeAPI keeps the handlers the bot sets and what it sends, the tests call the handlers as tgbot would.
+type fakeAPI struct {
+	mu       sync.Mutex
+	commands map[string]func(context.Context, *model.CommandToHandle)
+	message  func(context.Context, *model.MessageToHandle)
+	edited   func(context.Context, *model.MessageToHandle)
+	sent     []*model.MessageToSend
+	ok       []*model.MessageToInteract
+	failed   []*model.MessageToInteract
+	removed  []*model.MessageToInteract
+	nextID   int
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{
+		mu:       sync.Mutex{},
+		commands: make(map[string]func(context.Context, *model.CommandToHandle)),
+		message:  nil,
+		edited:   nil,
+		sent:     nil,
+		ok:       nil,
+		failed:   nil,
+		removed:  nil,
+		nextID:   1000,
+	}
+}
+
+func (a *fakeAPI) SetUpdateHandlerCommand(name string, handler func(context.Context, *model.CommandToHandle)) {
+	a.commands[name] = handler
+}
+
+func (a *fakeAPI) SetUpdateHandlerMessage(handler func(context.Context, *model.MessageToHandle)) {
+	a.message = handler
+}
+
+func (a *fakeAPI) SetUpdateHandlerEditedMessage(handler func(context.Context, *model.MessageToHandle)) {
+	a.edited = handler
+}
+
+func (a *fakeAPI) SendMessage(msg *model.MessageToSend) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sent = append(a.sent, msg)
+	a.nextID++
+	return strconv.Itoa(a.nextID), nil
+}
+
+func (a *fakeAPI) SetCommands(model.CommandScope, string, []*model.Command) error {
+	return nil
+}
+
+func (a *fakeAPI) RemoveMessage(msg *model.MessageToInteract) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removed = append(a.removed, msg)
+	return nil
+}
+
+func (a *fakeAPI) MarkMessageProcessedOK(msg *model.MessageToInteract) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ok = append(a.ok, msg)
+	return nil
+}
+
+func (a *fakeAPI) MarkMessageProcessedFail(msg *model.MessageToInteract) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failed = append(a.failed, msg)
+	return nil
+}
+
+func (a *fakeAPI) MarkMessageProcessedQueued(*model.MessageToInteract) error {
+	return nil
+}
+
+func (a *fakeAPI) ListenToUpdates(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// lastSent returns the text of the last message sent to the chat, "" if none.
+func (a *fakeAPI) lastSent(chatID string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.sent) - 1; i >= 0; i-- {
+		if a.sent[i].ChatID == chatID {
+			return a.sent[i].Text
+		}
+	}
+	return ""
+}
+
+func (a *fakeAPI) sentCount(chatID string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	count := 0
+	for _, msg := range a.sent {
+		if msg.ChatID == chatID {
+			count++
+		}
+	}
+	return count
+}
+
+// testLedgers keeps the chats in the ledger, but the ones in chats.
+type testLedgers struct {
+	ledger *gsheetstorage.TransactionStorage
+	chats  map[string]*gsheetstorage.TransactionStorage
+}
+
+func (l *testLedgers) LedgerOf(chatID string) telemoney.Ledger {
+	if ledger, ok := l.chats[chatID]; ok {
+		return ledger
+	}
+	return l.ledger
+}
+
+func (l *testLedgers) Ledgers() []telemoney.Ledger {
+	ledgers := []telemoney.Ledger{l.ledger}
+	for _, ledger := range l.chats {
+		ledgers = append(ledgers, ledger)
+	}
+	return ledgers
+}
+
+// fakeLinker fails the links to the spreadsheets in fail.
+type fakeLinker struct {
+	mu     sync.Mutex
+	fail   map[string]error
+	linked map[string]string // chat id -> spreadsheet id
+}
+
+func (l *fakeLinker) LinkSpreadsheet(_ context.Context, chatID string, spreadsheetID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.fail[spreadsheetID]; err != nil {
+		return err
+	}
+	l.linked[chatID] = spreadsheetID
+	return nil
+}
+
+type testBot struct {
+	bot          *telemoney.Telemoney
+	api          *fakeAPI
+	sheets       *gsheetfake.Server
+	ledger       *gsheetstorage.TransactionStorage
+	ledgers      *testLedgers
+	gsc          *gsheetclient.GSheetsClient
+	chatSettings *chatsettings.Store
+	linker       *fakeLinker
+	nextID       int
+}
+
+// newTestBot makes the bot over the fake sheets, the owner, the member and the read-only user are let in the group chat.
+func newTestBot(t *testing.T, config *telemoney.Config) *testBot {
+	server := gsheetfake.New(testSpreadsheetID)
+	t.Cleanup(server.Close)
+	gsc, err := gsheetclient.New(context.Background(), &gsheetclient.Config{
+		CredentialsSource: "",
+		AuthToken:         "",
+		CredentialsFile:   "",
+		OAuthTokenFile:    "",
+		SpreadsheetID:     testSpreadsheetID,
+		RequestTimeout:    time.Second,
+		Retry:             gsheetclient.RetryConfig{MaxAttempts: 1, InitialBackoff: 0, MaxBackoff: 0},
+		BatchWindow:       0,
+		BatchMaxSize:      0,
+		Endpoint:          server.URL(),
+		HTTPClient:        server.Client(),
+	})
+	require.NoError(t, err)
+	ledger := newTestLedger(t, gsc, testSheetID)
+	ledgers := &testLedgers{ledger: ledger, chats: make(map[string]*gsheetstorage.TransactionStorage)}
+
+	reconciler := reconcile.New(&reconcile.Config{Interval: 0}, ledger)
+	transactionOutbox, err := outbox.New(&outbox.Config{Path: filepath.Join(t.TempDir(), "outbox.jsonl"), RetryInterval: time.Second}, reconcile.NewObservedStorage(ledger, reconciler))
+	require.NoError(t, err)
+	accessControl, err := access.New(&access.Config{
+		Enabled:    true,
+		Owners:     []string{ownerID},
+		Members:    []string{memberID},
+		ReadOnly:   []string{readOnlyID},
+		Chats:      []string{groupChatID},
+		GrantsPath: "",
+	})
+	require.NoError(t, err)
+	chatSettings, err := chatsettings.New(&chatsettings.Config{Path: ""})
+	require.NoError(t, err)
+	removalStore, err := removals.New(&removals.Config{Path: "", Window: time.Hour})
+	require.NoError(t, err)
+	userNames, err := usernames.New(&usernames.Config{Path: ""})
+	require.NoError(t, err)
+	linker := &fakeLinker{mu: sync.Mutex{}, fail: make(map[string]error), linked: make(map[string]string)}
+
+	if config.Location == nil {
+		config.Location = time.UTC
+	}
+	api := newFakeAPI()
+	bot := telemoney.New(config, api, reconcile.NewObservedStorage(ledger, reconciler), ledgers, transactionOutbox,
+		reconciler, parsing.New(), accessControl, chatSettings, linker, removalStore, userNames)
+	return &testBot{bot: bot, api: api, sheets: server, ledger: ledger, ledgers: ledgers, gsc: gsc, chatSettings: chatSettings, linker: linker, nextID: 1}
+}
+
+func newTestLedger(t *testing.T, gsc *gsheetclient.GSheetsClient, sheetID string) *gsheetstorage.TransactionStorage {
+	ledger := gsheetstorage.New(gsc, &gsheetstorage.Config{TransactionSheetID: sheetID, MonthlyPartitions: false, Location: nil, AmountFormat: ""})
+	require.NoError(t, ledger.Bootstrap(context.Background()))
+	return ledger
+}
+
+// giveOwnLedger keeps the chat in a ledger of its own, on the sheet.
+func (b *testBot) giveOwnLedger(t *testing.T, chatID string, sheetID string) *gsheetstorage.TransactionStorage {
+	ledger := newTestLedger(t, b.gsc, sheetID)
+	b.ledgers.chats[chatID] = ledger
+	return ledger
+}
+
+func (b *testBot) makeMessage(chatID string, userID string, text string) *model.MessageToHandle {
+	b.nextID++
+	return &model.MessageToHandle{
+		CreatedAt: 1710000000,
+		MessageID: strconv.Itoa(b.nextID),
+		ChatID:    chatID,
+		UserID:    userID,
+		UserName:  "user" + userID,
+		Text:      text,
+	}
+}
+
+// send hands the text to the bot as tgbot does: a routed command to its handler, the rest to the message handler.
+func (b *testBot) send(chatID string, userID string, text string) *model.MessageToHandle {
+	return b.sendReply(chatID, userID, text, nil)
+}
+
+func (b *testBot) sendReply(chatID string, userID string, text string, replyTo *model.MessageToHandle) *model.MessageToHandle {
+	msg := b.makeMessage(chatID, userID, text)
+	if strings.HasPrefix(text, "/") {
+		fields := strings.Fields(text)
+		name, _, _ := strings.Cut(strings.TrimPrefix(fields[0], "/"), "@")
+		if handler, ok := b.api.commands[strings.ToLower(name)]; ok {
+			handler(context.Background(), &model.CommandToHandle{Name: strings.ToLower(name), Args: fields[1:], Message: msg, ReplyTo: replyTo})
+			return msg
+		}
+	}
+	b.api.message(context.Background(), msg)
+	return msg
+}
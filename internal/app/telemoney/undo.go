@@ -0,0 +1,154 @@
+package telemoney
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/audit"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/outbox"
+	"github.com/mitrkos/telemoney/internal/app/telemoney/storage"
+	"github.com/mitrkos/telemoney/internal/model"
+)
+
+// addRemoval keeps the removal for /undo, a failure is logged only: the transaction is removed anyway.
+func (t *Telemoney) addRemoval(chatID string, messageID string) {
+	err := t.removals.Add(chatID, messageID)
+	if err != nil {
+		slog.Error("can't keep the removal for /undo", slog.Any("err", err), slog.String("messageID", messageID))
+	}
+}
+
+// handleUndoCommand restores the transaction removed last in the chat and posts its text again. The removed
+// message itself can't be brought back, so the transaction moves to the posted one: /remove and /history
+// work in reply to it. The removal is forgotten once the restore is applied or queued, a failed /undo can be sent again.
+func (t *Telemoney) handleUndoCommand(ctx context.Context, call *model.CommandToHandle) {
+	msg := call.Message
+	ctx, cancel := t.withHandlerTimeout(ctx)
+	defer cancel()
+
+	last := t.removals.Last(msg.ChatID)
+	if last == nil {
+		_, _ = t.api.SendMessage(&model.MessageToSend{
+			ChatID: msg.ChatID,
+			Text:   "nothing to undo",
+		})
+		return
+	}
+
+	entry := &outbox.Entry{
+		Kind:        outbox.OperationRestore,
+		Transaction: nil,
+		MessageID:   last.MessageID,
+		ChatID:      msg.ChatID,
+		UserID:      msg.UserID,
+		Before:      nil,
+	}
+	queued, err := t.outbox.Submit(ctx, entry)
+	if err != nil {
+		slog.Error("can't restore the transaction", slog.Any("err", err), slog.String("messageID", last.MessageID))
+		if errors.Is(err, storage.ErrTransactionNotFound) {
+			// purged or restored by hand, there is nothing to try again
+			t.dropRemoval(msg.ChatID, last.MessageID)
+		}
+		t.markMessageHandledFailure(msg)
+		return
+	}
+	t.dropRemoval(msg.ChatID, last.MessageID)
+	if queued {
+		t.markMessageHandleQueued(msg.ChatID, msg.MessageID)
+		return
+	}
+	t.postRestored(ctx, entry)
+	t.markMessageHandleSuccess(msg)
+}
+
+// dropRemoval forgets the removal taken back, a failure is logged only: /undo of it finds nothing to restore then.
+func (t *Telemoney) dropRemoval(chatID string, messageID string) {
+	err := t.removals.Drop(chatID, messageID)
+	if err != nil {
+		slog.Error("can't forget the removal taken back", slog.Any("err", err), slog.String("messageID", messageID))
+	}
+}
+
+// postRestored posts the text of the restored transaction and moves the transaction to the posted message.
+func (t *Telemoney) postRestored(ctx context.Context, entry *outbox.Entry) {
+	transaction := entry.Transaction
+	messageID, err := t.api.SendMessage(&model.MessageToSend{
+		ChatID: entry.ChatID,
+		Text:   formatTransactionText(transaction),
+	})
+	if err == nil {
+		transaction = t.moveTransaction(ctx, transaction, messageID)
+	}
+	t.appendAuditRecord(ctx, &audit.Record{
+		At:        time.Now(),
+		MessageID: transaction.MessageID,
+		Operation: audit.OperationRestore,
+		Trigger:   audit.TriggerUndo,
+		ChatID:    entry.ChatID,
+		UserID:    entry.UserID,
+		Before:    nil,
+		After:     transaction,
+	})
+}
+
+// moveTransaction keys the transaction by the message, the one under the old message is removed.
+// Returns the transaction as it is stored after all, or as it will be once the queued move is applied.
+func (t *Telemoney) moveTransaction(ctx context.Context, transaction *model.Transaction, messageID string) *model.Transaction {
+	moved := *transaction
+	moved.MessageID = messageID
+	_, err := t.outbox.Submit(ctx, &outbox.Entry{
+		Kind:        outbox.OperationMove,
+		Transaction: &moved,
+		MessageID:   transaction.MessageID,
+		ChatID:      transaction.ChatID,
+		UserID:      transaction.UserID,
+		Before:      nil,
+	})
+	if err != nil {
+		slog.Error("can't move the restored transaction to the posted message", slog.Any("err", err),
+			slog.String("messageID", transaction.MessageID))
+		return transaction
+	}
+	return &moved
+}
+
+// purgePeriodically removes the transactions deleted more than PurgeAfter ago every PurgeInterval until ctx is done.
+func (t *Telemoney) purgePeriodically(ctx context.Context) {
+	if t.config.PurgeAfter <= 0 || t.config.PurgeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(t.config.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := t.transactionStorage.Purge(ctx, time.Now().Add(-t.config.PurgeAfter))
+		if err != nil {
+			slog.Error("can't purge the removed transactions", slog.Any("err", err))
+		} else if purged > 0 {
+			slog.Info("removed transactions are purged", slog.Int("count", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// formatTransactionText makes the message text the transaction is parsed from, like "9.5 lunch (grenka, dumplings) I need food!".
+func formatTransactionText(transaction *model.Transaction) string {
+	text := strconv.FormatFloat(transaction.Amount, 'f', -1, 64) + " " + transaction.Category
+	if len(transaction.Tags) > 0 {
+		text += " (" + strings.Join(transaction.Tags, ", ") + ")"
+	}
+	if transaction.Comment != nil {
+		text += " " + *transaction.Comment
+	}
+	return text
+}
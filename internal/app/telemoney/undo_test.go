@@ -0,0 +1,54 @@
+package telemoney_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient/gsheetfake"
+)
+
+func TestUndo_RestoresTheRemovedExpense(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+	expense := b.send(groupChatID, memberID, "9.5 lunch (grenka)")
+	_, err := b.ledger.Get(context.Background(), groupChatID, expense.MessageID)
+	require.NoError(t, err)
+	b.sendReply(groupChatID, memberID, "/remove", expense)
+	_, err = b.ledger.Get(context.Background(), groupChatID, expense.MessageID)
+	require.Error(t, err)
+
+	b.send(groupChatID, memberID, "/undo")
+
+	// the expense moves to the message posted again
+	require.Equal(t, "9.5 lunch (grenka)", b.api.lastSent(groupChatID))
+	posted := strconv.Itoa(b.api.nextID)
+	transaction, err := b.ledger.Get(context.Background(), groupChatID, posted)
+	require.NoError(t, err)
+	require.Equal(t, 9.5, transaction.Amount)
+	require.Equal(t, []string{"grenka"}, transaction.Tags)
+	_, err = b.ledger.Get(context.Background(), groupChatID, expense.MessageID)
+	require.Error(t, err)
+
+	b.send(groupChatID, memberID, "/undo")
+	require.Equal(t, "nothing to undo", b.api.lastSent(groupChatID))
+}
+
+func TestUndo_FailedRestoreCanBeSentAgain(t *testing.T) {
+	b := newTestBot(t, &telemoney.Config{})
+	expense := b.send(groupChatID, memberID, "9.5 lunch")
+	b.sendReply(groupChatID, memberID, "/remove", expense)
+
+	b.sheets.FailNext(gsheetfake.Failure{StatusCode: http.StatusBadRequest, RetryAfter: 0})
+	undo := b.send(groupChatID, memberID, "/undo")
+	require.Equal(t, undo.MessageID, b.api.failed[len(b.api.failed)-1].MessageID)
+
+	b.send(groupChatID, memberID, "/undo")
+	require.Equal(t, "9.5 lunch", b.api.lastSent(groupChatID))
+	transaction, err := b.ledger.Get(context.Background(), groupChatID, strconv.Itoa(b.api.nextID))
+	require.NoError(t, err)
+	require.Equal(t, 9.5, transaction.Amount)
+}
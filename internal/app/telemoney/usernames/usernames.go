@@ -0,0 +1,97 @@
+// Package usernames keeps the names of the senders the replies show instead of their ids, so they survive a restart.
+package usernames
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/mitrkos/telemoney/internal/utils"
+)
+
+type Config struct {
+	Path string // the file the names are kept in, "" - in memory only
+}
+
+type Store struct {
+	config *Config
+
+	mu    sync.Mutex
+	names map[string]string // user id -> the name last seen
+}
+
+func New(config *Config) (*Store, error) {
+	names, err := readNames(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		config: config,
+		mu:     sync.Mutex{},
+		names:  names,
+	}, nil
+}
+
+// Remember keeps the name of the user, the file is written only if the name is new.
+func (s *Store) Remember(userID string, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.names[userID] == name {
+		return nil
+	}
+	updated := make(map[string]string, len(s.names)+1)
+	for id, known := range s.names {
+		updated[id] = known
+	}
+	updated[userID] = name
+
+	err := writeNames(s.config.Path, updated)
+	if err != nil {
+		return err
+	}
+	s.names = updated
+	return nil
+}
+
+// Name returns the name of the user last seen, false if the user was never seen.
+func (s *Store) Name(userID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, ok := s.names[userID]
+	return name, ok
+}
+
+func readNames(path string) (map[string]string, error) {
+	names := make(map[string]string)
+	if path == "" {
+		return names, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &names)
+	if err != nil {
+		return nil, fmt.Errorf("bad user names file %s: %w", path, err)
+	}
+	return names, nil
+}
+
+func writeNames(path string, names map[string]string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data)
+}
@@ -0,0 +1,40 @@
+package usernames_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/app/telemoney/usernames"
+)
+
+func TestStore_NamesAreKept(t *testing.T) {
+	config := &usernames.Config{Path: filepath.Join(t.TempDir(), "data", "user_names.json")}
+	store, err := usernames.New(config)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Remember("8", "@alice"))
+	require.NoError(t, store.Remember("9", "@bob"))
+	require.NoError(t, store.Remember("8", "@alice_new"))
+
+	reopened, err := usernames.New(config)
+	require.NoError(t, err)
+	name, ok := reopened.Name("8")
+	require.True(t, ok)
+	require.Equal(t, "@alice_new", name)
+	_, ok = reopened.Name("10")
+	require.False(t, ok)
+}
+
+func TestStore_SameNameIsNotWrittenAgain(t *testing.T) {
+	config := &usernames.Config{Path: filepath.Join(t.TempDir(), "user_names.json")}
+	store, err := usernames.New(config)
+	require.NoError(t, err)
+	require.NoError(t, store.Remember("8", "@alice"))
+	require.NoError(t, os.Remove(config.Path))
+
+	require.NoError(t, store.Remember("8", "@alice"))
+	require.NoFileExists(t, config.Path)
+}
@@ -4,6 +4,8 @@ type MessageToHandle struct {
 	CreatedAt int64
 	MessageID string
 	ChatID    string
+	UserID    string // the sender, for a command on a reply it is the sender of the command
+	UserName  string // the sender's @username or name, for showing only
 	Text      string
 }
 
@@ -12,6 +14,28 @@ type MessageToSend struct {
 	ChatID string
 }
 
+// CommandToHandle is a command sent to the bot, like "/approve 42 member".
+type CommandToHandle struct {
+	Name    string           // lower case, without the slash and the bot mention
+	Args    []string         // the words after the command
+	Message *MessageToHandle // the message with the command
+	ReplyTo *MessageToHandle // the message the command replies to, nil if none
+}
+
+// Command is an entry of the command menu of the bot.
+type Command struct {
+	Name        string // without the slash, like "summary"
+	Description string
+}
+
+// CommandScope is the kind of chats a command menu is shown in.
+type CommandScope string
+
+const (
+	CommandScopePrivate CommandScope = "private"
+	CommandScopeGroup   CommandScope = "group"
+)
+
 type MessageToInteract struct {
 	MessageID string
 	ChatID    string
@@ -20,8 +44,20 @@ type MessageToInteract struct {
 type Transaction struct {
 	CreatedAt int64
 	MessageID string
+	ChatID    string // "" for the transactions stored before the chat was kept
+	UserID    string // the sender, "" if unknown
 	Amount    float64
 	Category  string
 	Tags      []string
 	Comment   *string
 }
+
+// TransactionKey identifies a transaction, message ids are unique only within a chat.
+type TransactionKey struct {
+	ChatID    string
+	MessageID string
+}
+
+func KeyOf(transaction *Transaction) TransactionKey {
+	return TransactionKey{ChatID: transaction.ChatID, MessageID: transaction.MessageID}
+}
@@ -0,0 +1,205 @@
+package gsheetclient
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+type batchOpKind int
+
+const (
+	batchOpAppend batchOpKind = iota
+	batchOpUpdate
+	batchOpClear
+)
+
+type batchOp struct {
+	kind     batchOpKind
+	opRange  *A1Range
+	dataRows [][]interface{}
+	result   chan error
+}
+
+// batcher collects write operations for a short window and sends them to gsheets in as few requests as possible:
+// appends to the same range become one append call, updates and clears become one values:batchUpdate call.
+type batcher struct {
+	gsc *GSheetsClient
+
+	window  time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	pending []*batchOp
+	timer   *time.Timer
+}
+
+func newBatcher(gsc *GSheetsClient, window time.Duration, maxSize int) *batcher {
+	return &batcher{
+		gsc:     gsc,
+		window:  window,
+		maxSize: maxSize,
+		mu:      sync.Mutex{},
+		pending: nil,
+		timer:   nil,
+	}
+}
+
+// submit queues the op and blocks until the batch it was put in is sent or ctx is done.
+// The op stays in the batch even if the caller gave up waiting, the caller gets a retryable error then.
+func (b *batcher) submit(ctx context.Context, op *batchOp) error {
+	op.result = make(chan error, 1)
+
+	b.mu.Lock()
+	b.pending = append(b.pending, op)
+	var ops []*batchOp
+	switch {
+	case b.maxSize > 0 && len(b.pending) >= b.maxSize:
+		ops = b.takePendingLocked()
+	case b.timer == nil:
+		b.timer = time.AfterFunc(b.window, func() { b.flush(b.gsc.ctx) })
+	}
+	b.mu.Unlock()
+
+	if ops != nil {
+		// the batch is shared by several callers, so it is bound to the client lifetime, not to this caller
+		b.send(b.gsc.ctx, ops)
+	}
+
+	select {
+	case err := <-op.result:
+		return err
+	case <-ctx.Done():
+		// the batch may apply the op still, so it is retryable as a request that timed out: a retry is idempotent
+		return &APIError{StatusCode: 0, RetryAfter: 0, retryable: true, quota: false, err: ctx.Err()}
+	}
+}
+
+// flush sends everything that is queued right now.
+func (b *batcher) flush(ctx context.Context) {
+	b.mu.Lock()
+	ops := b.takePendingLocked()
+	b.mu.Unlock()
+
+	if len(ops) > 0 {
+		b.send(ctx, ops)
+	}
+}
+
+func (b *batcher) takePendingLocked() []*batchOp {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	ops := b.pending
+	b.pending = nil
+	return ops
+}
+
+func (b *batcher) send(ctx context.Context, ops []*batchOp) {
+	var appendRangeOrder []string
+	appendsByRange := make(map[string][]*batchOp)
+	var valueOps []*batchOp
+
+	for _, op := range ops {
+		switch op.kind {
+		case batchOpAppend:
+			key := op.opRange.String()
+			if _, ok := appendsByRange[key]; !ok {
+				appendRangeOrder = append(appendRangeOrder, key)
+			}
+			appendsByRange[key] = append(appendsByRange[key], op)
+		case batchOpUpdate, batchOpClear:
+			valueOps = append(valueOps, op)
+		}
+	}
+
+	for _, key := range appendRangeOrder {
+		appendOps := appendsByRange[key]
+		var dataRows [][]interface{}
+		for _, op := range appendOps {
+			dataRows = append(dataRows, op.dataRows...)
+		}
+		err := b.gsc.appendDataRowsToRange(ctx, appendOps[0].opRange, dataRows)
+		b.resolve(ctx, appendOps, err)
+	}
+
+	if len(valueOps) > 0 {
+		err := b.sendValueOps(ctx, valueOps)
+		b.resolve(ctx, valueOps, err)
+	}
+
+	slog.Info("gsheets batch sent", slog.Int("ops", len(ops)), slog.Int("appendRanges", len(appendRangeOrder)))
+}
+
+func (b *batcher) sendValueOps(ctx context.Context, ops []*batchOp) error {
+	data := make([]*sheets.ValueRange, 0, len(ops))
+	for _, op := range ops {
+		dataRows := op.dataRows
+		if op.kind == batchOpClear {
+			rows, columns, ok := op.opRange.size()
+			if !ok {
+				// an open range can't be cleared by writing empty values, so it goes alone
+				if err := b.gsc.clearRange(ctx, op.opRange); err != nil {
+					return err
+				}
+				continue
+			}
+			dataRows = makeEmptyDataRows(rows, columns)
+		}
+
+		data = append(data, &sheets.ValueRange{ //nolint:exhaustruct // ok way to use the lib
+			Range:  op.opRange.String(),
+			Values: dataRows,
+		})
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	return b.gsc.batchUpdateValues(ctx, data)
+}
+
+// resolve hands the result of a request to the ops sent in it. A request rejected as a whole, e.g. for one bad
+// range, is sent again op by op, so only the callers of the bad ops get the error. Nothing of a rejected request
+// is applied, so the appends are not written twice; the retryable errors are the same for every op and are kept.
+func (b *batcher) resolve(ctx context.Context, ops []*batchOp, err error) {
+	if err == nil || len(ops) == 1 || !errors.Is(err, ErrPermanent) {
+		for _, op := range ops {
+			op.result <- err
+		}
+		return
+	}
+
+	slog.Warn("gsheets batch is rejected, sending its ops one by one", slog.Int("ops", len(ops)), slog.Any("err", err))
+	for _, op := range ops {
+		op.result <- b.sendOne(ctx, op)
+	}
+}
+
+func (b *batcher) sendOne(ctx context.Context, op *batchOp) error {
+	switch op.kind {
+	case batchOpAppend:
+		return b.gsc.appendDataRowsToRange(ctx, op.opRange, op.dataRows)
+	case batchOpUpdate:
+		return b.gsc.updateDataRange(ctx, op.opRange, op.dataRows)
+	case batchOpClear:
+		return b.gsc.clearRange(ctx, op.opRange)
+	}
+	return nil
+}
+
+func makeEmptyDataRows(rows int, columns int) [][]interface{} {
+	dataRows := make([][]interface{}, rows)
+	for i := range dataRows {
+		dataRows[i] = make([]interface{}, columns)
+		for j := range dataRows[i] {
+			dataRows[i][j] = ""
+		}
+	}
+	return dataRows
+}
@@ -0,0 +1,127 @@
+package gsheetclient_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient/gsheetfake"
+)
+
+func TestBatcher_CoalescesWrites(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	server.SetRows("sheet", [][]interface{}{{"h1", "h2"}, {"old", "1"}, {"to clear", "2"}})
+	gsc := newTestClient(t, server, 50*time.Millisecond)
+
+	appendRange := &gsheetclient.A1Range{
+		SheetID:     "sheet",
+		LeftTop:     &gsheetclient.A1Location{Column: "A", Row: 2},
+		RightBottom: &gsheetclient.A1Location{Column: "B", Row: 0},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for _, value := range []string{"x", "y", "z"} {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			errs <- gsc.AppendDataToRange(context.Background(), appendRange, []interface{}{value, "3"})
+		}(value)
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs <- gsc.UpdateDataRange(context.Background(), makeRowRange(2), []interface{}{"new", "1"})
+	}()
+	go func() {
+		defer wg.Done()
+		errs <- gsc.ClearRange(context.Background(), makeRowRange(3))
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 1, server.RequestCount("values.append"))
+	require.Equal(t, 1, server.RequestCount("values.batchUpdate"))
+	require.Equal(t, 0, server.RequestCount("values.update"))
+	require.Equal(t, 0, server.RequestCount("values.clear"))
+
+	rows := server.Rows("sheet")
+	require.Len(t, rows, 6)
+	require.Equal(t, []interface{}{"new", float64(1)}, rows[1])
+	require.Equal(t, []interface{}{nil, nil}, rows[2])
+	require.ElementsMatch(t, []interface{}{"x", "y", "z"}, []interface{}{rows[3][0], rows[4][0], rows[5][0]})
+}
+
+func TestBatcher_FlushSendsPendingWrites(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	server.AddSheet("sheet")
+	gsc := newTestClient(t, server, time.Hour)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- gsc.UpdateDataRange(context.Background(), makeRowRange(1), []interface{}{"a", "b"})
+	}()
+	require.Eventually(t, func() bool {
+		gsc.Flush(context.Background())
+		return server.RequestCount("values.batchUpdate") > 0
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, <-errs)
+	require.Equal(t, [][]interface{}{{"a", "b"}}, server.Rows("sheet"))
+}
+
+func TestBatcher_CallerGivingUpGetsRetryableError(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	server.AddSheet("sheet")
+	gsc := newTestClient(t, server, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := gsc.UpdateDataRange(ctx, makeRowRange(1), []interface{}{"a", "b"})
+	require.ErrorIs(t, err, gsheetclient.ErrRetryable)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the write is applied with the batch anyway
+	gsc.Flush(context.Background())
+	require.Equal(t, [][]interface{}{{"a", "b"}}, server.Rows("sheet"))
+}
+
+func TestBatcher_SplitsRejectedBatch(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	server.AddSheet("sheet")
+	gsc := newTestClient(t, server, 50*time.Millisecond)
+
+	badRange := &gsheetclient.A1Range{
+		SheetID:     "missing",
+		LeftTop:     &gsheetclient.A1Location{Column: "A", Row: 1},
+		RightBottom: &gsheetclient.A1Location{Column: "B", Row: 1},
+	}
+	var wg sync.WaitGroup
+	var goodErr, badErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		goodErr = gsc.UpdateDataRange(context.Background(), makeRowRange(1), []interface{}{"a", "b"})
+	}()
+	go func() {
+		defer wg.Done()
+		badErr = gsc.UpdateDataRange(context.Background(), badRange, []interface{}{"c", "d"})
+	}()
+	wg.Wait()
+
+	require.NoError(t, goodErr)
+	require.ErrorIs(t, badErr, gsheetclient.ErrPermanent)
+	require.Equal(t, 1, server.RequestCount("values.batchUpdate"))
+	require.Equal(t, 2, server.RequestCount("values.update"))
+	require.Equal(t, [][]interface{}{{"a", "b"}}, server.Rows("sheet"))
+}
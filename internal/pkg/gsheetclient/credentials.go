@@ -0,0 +1,226 @@
+package gsheetclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/mitrkos/telemoney/internal/utils"
+)
+
+const spreadsheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+type CredentialsSource string
+
+const (
+	// CredentialsSourceToken is a base64 encoded service account key in Config.AuthToken.
+	CredentialsSourceToken CredentialsSource = "token"
+	// CredentialsSourceFile is a service account or authorized user key file at Config.CredentialsFile.
+	CredentialsSourceFile CredentialsSource = "file"
+	// CredentialsSourceADC is Application Default Credentials: GOOGLE_APPLICATION_CREDENTIALS, gcloud, metadata server.
+	CredentialsSourceADC CredentialsSource = "adc"
+	// CredentialsSourceOAuth is the installed app flow with the OAuth client at Config.CredentialsFile,
+	// the user's token is kept at Config.OAuthTokenFile.
+	CredentialsSourceOAuth CredentialsSource = "oauth"
+)
+
+const oauthFlowTimeout = 5 * time.Minute
+
+func newAuthorizedHTTPClient(ctx context.Context, config *Config) (*http.Client, error) {
+	switch config.CredentialsSource {
+	case CredentialsSourceToken, "":
+		return newServiceAccountClient(ctx, config.AuthToken)
+	case CredentialsSourceFile:
+		return newKeyFileClient(ctx, config.CredentialsFile)
+	case CredentialsSourceADC:
+		credentials, err := google.FindDefaultCredentials(ctx, spreadsheetsScope)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("gsheets credentials loaded", slog.String("source", string(CredentialsSourceADC)))
+		return oauth2.NewClient(ctx, credentials.TokenSource), nil
+	case CredentialsSourceOAuth:
+		return newOAuthUserClient(ctx, config.CredentialsFile, config.OAuthTokenFile)
+	}
+	return nil, fmt.Errorf("unknown gsheets credentials source: %q", config.CredentialsSource)
+}
+
+func newServiceAccountClient(ctx context.Context, authToken string) (*http.Client, error) {
+	credBytes, err := base64.StdEncoding.DecodeString(authToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// authenticate and get configuration
+	jwtConfig, err := google.JWTConfigFromJSON(credBytes, spreadsheetsScope)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("gsheets credentials loaded", slog.String("source", string(CredentialsSourceToken)), slog.String("email", jwtConfig.Email))
+
+	// create client with config and context
+	return jwtConfig.Client(ctx), nil
+}
+
+func newKeyFileClient(ctx context.Context, keyFile string) (*http.Client, error) {
+	key, err := utils.GetTokenFromFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := google.CredentialsFromJSON(ctx, []byte(key), spreadsheetsScope)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("gsheets credentials loaded", slog.String("source", string(CredentialsSourceFile)), slog.String("file", keyFile))
+	return oauth2.NewClient(ctx, credentials.TokenSource), nil
+}
+
+// newOAuthUserClient acts as the user who granted access. The first run asks for the grant in a browser,
+// the refresh token is saved to tokenFile and used afterwards.
+func newOAuthUserClient(ctx context.Context, clientFile string, tokenFile string) (*http.Client, error) {
+	clientSecret, err := utils.GetTokenFromFile(clientFile)
+	if err != nil {
+		return nil, err
+	}
+	oauthConfig, err := google.ConfigFromJSON([]byte(clientSecret), spreadsheetsScope)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := readOAuthToken(tokenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		token, err = runOAuthInstalledAppFlow(ctx, oauthConfig)
+		if err == nil {
+			err = writeOAuthToken(tokenFile, token)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("gsheets credentials loaded", slog.String("source", string(CredentialsSourceOAuth)), slog.String("tokenFile", tokenFile))
+
+	tokenSource := &persistingTokenSource{
+		mu:        sync.Mutex{},
+		base:      oauthConfig.TokenSource(ctx, token),
+		tokenFile: tokenFile,
+		last:      token,
+	}
+	return oauth2.NewClient(ctx, tokenSource), nil
+}
+
+// runOAuthInstalledAppFlow asks the user to open the consent page and catches the code on a loopback redirect.
+func runOAuthInstalledAppFlow(ctx context.Context, oauthConfig *oauth2.Config) (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, oauthFlowTimeout)
+	defer cancel()
+
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "tcp", "127.0.0.1:0") //nolint:exhaustruct // defaults are fine
+	if err != nil {
+		return nil, err
+	}
+	oauthConfig.RedirectURL = "http://" + listener.Addr().String() + "/"
+
+	state, err := makeOAuthState()
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make(chan string, 1)
+	server := &http.Server{ //nolint:exhaustruct // defaults are fine for a one shot local server
+		ReadHeaderTimeout: time.Minute,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("state") != state || r.URL.Query().Get("code") == "" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			_, _ = fmt.Fprintln(w, "telemoney got access to the spreadsheets, the tab can be closed")
+			select {
+			case codes <- r.URL.Query().Get("code"):
+			default:
+			}
+		}),
+	}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	authURL := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+	slog.Warn("gsheets access is not granted yet, open the link to grant it", slog.String("url", authURL))
+
+	select {
+	case code := <-codes:
+		return oauthConfig.Exchange(ctx, code)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func makeOAuthState() (string, error) {
+	stateBytes := make([]byte, 16) //nolint:gomnd // 128 bit is enough for csrf protection
+	if _, err := rand.Read(stateBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(stateBytes), nil
+}
+
+// persistingTokenSource saves the token every time the base source refreshes it.
+type persistingTokenSource struct {
+	mu        sync.Mutex
+	base      oauth2.TokenSource
+	tokenFile string
+	last      *oauth2.Token
+}
+
+func (ts *persistingTokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	token, err := ts.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	if ts.last == nil || token.AccessToken != ts.last.AccessToken {
+		if err = writeOAuthToken(ts.tokenFile, token); err != nil {
+			slog.Error("can't save the refreshed gsheets token", slog.Any("err", err))
+		}
+		ts.last = token
+	}
+	return token, nil
+}
+
+func readOAuthToken(tokenFile string) (*oauth2.Token, error) {
+	tokenJSON, err := utils.GetTokenFromFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	err = json.Unmarshal([]byte(tokenJSON), &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func writeOAuthToken(tokenFile string, token *oauth2.Token) error {
+	tokenJSON, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(tokenFile), 0o750) //nolint:gomnd // the directory is private to the owner
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tokenFile, tokenJSON, 0o600) //nolint:gomnd // the token is readable only by the owner
+}
@@ -0,0 +1,170 @@
+package gsheetclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient/gsheetfake"
+)
+
+// newTokenServer answers the token requests of the OAuth client with access tokens "access-1", "access-2", ...
+func newTokenServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	issued := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		issued++
+		accessToken := "access-" + strconv.Itoa(issued)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  accessToken,
+			"token_type":    "Bearer",
+			"refresh_token": "refresh",
+			"expires_in":    3600,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeOAuthClientFile(t *testing.T, tokenURL string) string {
+	clientJSON, err := json.Marshal(map[string]interface{}{
+		"installed": map[string]interface{}{
+			"client_id":     "client",
+			"client_secret": "secret",
+			"auth_uri":      "https://accounts.example.com/auth",
+			"token_uri":     tokenURL,
+			"redirect_uris": []string{"http://localhost"},
+		},
+	})
+	require.NoError(t, err)
+	clientFile := filepath.Join(t.TempDir(), "client.json")
+	require.NoError(t, os.WriteFile(clientFile, clientJSON, 0o600))
+	return clientFile
+}
+
+func newOAuthConfig(server *gsheetfake.Server, clientFile string, tokenFile string) *gsheetclient.Config {
+	return &gsheetclient.Config{
+		CredentialsSource: gsheetclient.CredentialsSourceOAuth,
+		AuthToken:         "",
+		CredentialsFile:   clientFile,
+		OAuthTokenFile:    tokenFile,
+		SpreadsheetID:     testSpreadsheetID,
+		RequestTimeout:    time.Second,
+		Retry:             gsheetclient.RetryConfig{MaxAttempts: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		BatchWindow:       0,
+		BatchMaxSize:      0,
+		Endpoint:          server.URL(),
+		HTTPClient:        nil,
+	}
+}
+
+func readToken(t *testing.T, tokenFile string) *oauth2.Token {
+	tokenJSON, err := os.ReadFile(tokenFile)
+	require.NoError(t, err)
+	var token oauth2.Token
+	require.NoError(t, json.Unmarshal(tokenJSON, &token))
+	return &token
+}
+
+// urlCatcher is a slog handler passing on the "url" attributes of the records.
+type urlCatcher struct {
+	urls chan string
+}
+
+func (c *urlCatcher) Enabled(context.Context, slog.Level) bool { return true }
+func (c *urlCatcher) WithAttrs([]slog.Attr) slog.Handler       { return c }
+func (c *urlCatcher) WithGroup(string) slog.Handler            { return c }
+
+func (c *urlCatcher) Handle(_ context.Context, record slog.Record) error {
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "url" {
+			c.urls <- attr.Value.String()
+		}
+		return true
+	})
+	return nil
+}
+
+func TestNew_OAuthFlowSavesTheTokenToANewDirectory(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	tokenServer := newTokenServer(t)
+	clientFile := writeOAuthClientFile(t, tokenServer.URL)
+	tokenFile := filepath.Join(t.TempDir(), "data", "gsheets_token.json")
+
+	catcher := &urlCatcher{urls: make(chan string, 1)}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(catcher))
+	defer slog.SetDefault(defaultLogger)
+
+	// the user opens the link and grants the access
+	go func() {
+		authURL, err := url.Parse(<-catcher.urls)
+		if err != nil {
+			return
+		}
+		redirect, err := url.Parse(authURL.Query().Get("redirect_uri"))
+		if err != nil {
+			return
+		}
+		redirect.RawQuery = url.Values{"state": {authURL.Query().Get("state")}, "code": {"code"}}.Encode()
+		response, err := http.Get(redirect.String()) //nolint:noctx // the test waits for New anyway
+		if err == nil {
+			response.Body.Close()
+		}
+	}()
+
+	_, err := gsheetclient.New(context.Background(), newOAuthConfig(server, clientFile, tokenFile))
+	require.NoError(t, err)
+	require.Equal(t, "access-1", readToken(t, tokenFile).AccessToken)
+}
+
+func TestNew_OAuthSavesTheRefreshedToken(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	server.AddSheet("sheet")
+	tokenServer := newTokenServer(t)
+	clientFile := writeOAuthClientFile(t, tokenServer.URL)
+
+	tokenFile := filepath.Join(t.TempDir(), "gsheets_token.json")
+	expired, err := json.Marshal(&oauth2.Token{
+		AccessToken:  "expired",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Hour),
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(tokenFile, expired, 0o600))
+
+	gsc, err := gsheetclient.New(context.Background(), newOAuthConfig(server, clientFile, tokenFile))
+	require.NoError(t, err)
+	_, err = gsc.GetSheets(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "access-1", readToken(t, tokenFile).AccessToken)
+}
+
+func TestNew_RejectsUnknownCredentialsSource(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	config := newOAuthConfig(server, "", "")
+	config.CredentialsSource = "magic"
+
+	_, err := gsheetclient.New(context.Background(), config)
+	require.ErrorContains(t, err, "unknown gsheets credentials source")
+}
@@ -0,0 +1,26 @@
+package gsheetclient
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	secondsPerDay = 24 * 60 * 60
+	// unixEpochSerial is 1970-01-01 as a date serial, the serials count days from 1899-12-30
+	unixEpochSerial = 25569
+)
+
+// ToDateSerial converts the time to a sheets date serial: days since 1899-12-30 with the time of day as the fraction.
+// Sheets have no timezones, so the serial is the wall clock time of t in its location.
+func ToDateSerial(t time.Time) float64 {
+	_, offset := t.Zone()
+	return float64(t.Unix()+int64(offset))/secondsPerDay + unixEpochSerial
+}
+
+// FromDateSerial converts a sheets date serial to the time, the serial is the wall clock time in the location.
+func FromDateSerial(serial float64, location *time.Location) time.Time {
+	wallSeconds := int64(math.Round((serial - unixEpochSerial) * secondsPerDay))
+	wall := time.Unix(wallSeconds, 0).UTC()
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, location)
+}
@@ -0,0 +1,21 @@
+package gsheetclient_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+)
+
+func TestDateSerial(t *testing.T) {
+	require.InDelta(t, 25569, gsheetclient.ToDateSerial(time.Unix(0, 0).UTC()), 0)
+	require.InDelta(t, 45292.5, gsheetclient.ToDateSerial(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)), 0)
+
+	location := time.FixedZone("UTC-5", -5*60*60)
+	createdAt := time.Date(2024, 3, 9, 23, 59, 59, 0, location)
+	serial := gsheetclient.ToDateSerial(createdAt)
+	require.InDelta(t, 45360.999988, serial, 0.000001)
+	require.Equal(t, createdAt.Unix(), gsheetclient.FromDateSerial(serial, location).Unix())
+}
@@ -0,0 +1,133 @@
+package gsheetclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// ErrRetryable is matched by errors that may go away on their own: quota, 5xx, network problems.
+	ErrRetryable = errors.New("gsheets retryable error")
+	// ErrPermanent is matched by errors that repeating the same request won't fix: 400, 403, 404, ...
+	ErrPermanent = errors.New("gsheets permanent error")
+	// ErrQuotaExceeded is matched by rate limit errors, they are retryable as well.
+	ErrQuotaExceeded = errors.New("gsheets quota exceeded")
+)
+
+// APIError is a classified gsheets error, check the class with errors.Is(err, ErrRetryable) / ErrPermanent.
+type APIError struct {
+	StatusCode int           // 0 - the request didn't get a response
+	RetryAfter time.Duration // 0 - the server didn't ask for a delay
+	retryable  bool
+	quota      bool
+	err        error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gsheets api error (status %d): %v", e.StatusCode, e.err)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target { //nolint:errorlint // sentinel comparison is the point here
+	case ErrRetryable:
+		return e.retryable
+	case ErrPermanent:
+		return !e.retryable
+	case ErrQuotaExceeded:
+		return e.quota
+	}
+	return false
+}
+
+func classifyGSheetAPIError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return err
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		quota := isQuotaError(googleErr)
+		return &APIError{
+			StatusCode: googleErr.Code,
+			RetryAfter: parseRetryAfter(googleErr.Header),
+			retryable:  quota || isRetryableStatusCode(googleErr.Code),
+			quota:      quota,
+			err:        err,
+		}
+	}
+
+	// the request timeout of a single attempt is retryable, the caller's context is checked by the retry loop
+	var netErr net.Error
+	retryable := errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+	return &APIError{
+		StatusCode: 0,
+		RetryAfter: 0,
+		retryable:  retryable,
+		quota:      false,
+		err:        err,
+	}
+}
+
+func checkGSheetAPIStatus(httpStatusCode int) error {
+	if httpStatusCode == http.StatusOK {
+		return nil
+	}
+	return &APIError{
+		StatusCode: httpStatusCode,
+		RetryAfter: 0,
+		retryable:  isRetryableStatusCode(httpStatusCode),
+		quota:      httpStatusCode == http.StatusTooManyRequests,
+		err:        fmt.Errorf("gsheet connection error: %d", httpStatusCode),
+	}
+}
+
+func isRetryableStatusCode(httpStatusCode int) bool {
+	return httpStatusCode == http.StatusTooManyRequests ||
+		httpStatusCode == http.StatusRequestTimeout ||
+		httpStatusCode >= http.StatusInternalServerError
+}
+
+func isQuotaError(googleErr *googleapi.Error) bool {
+	if googleErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	// older quota errors come as 403 with a rate limit reason
+	for _, item := range googleErr.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,223 @@
+package gsheetfake
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// a1Range is a parsed A1 range with 0-based indexes, -1 in the end means the range is open.
+type a1Range struct {
+	sheetTitle  string
+	startRow    int
+	startColumn int
+	endRow      int
+	endColumn   int
+}
+
+func parseA1Range(rawRange string) (*a1Range, error) {
+	title, cells, hasCells := strings.Cut(rawRange, "!")
+	if strings.HasPrefix(title, "'") && strings.HasSuffix(title, "'") && len(title) > 1 {
+		title = strings.ReplaceAll(title[1:len(title)-1], "''", "'")
+	}
+
+	result := &a1Range{sheetTitle: title, startRow: 0, startColumn: 0, endRow: -1, endColumn: -1}
+	if !hasCells {
+		return result, nil
+	}
+
+	start, end, hasEnd := strings.Cut(cells, ":")
+	startColumn, startRow, err := parseA1Cell(start)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse range: %s", rawRange) //nolint:stylecheck // the api message
+	}
+	result.startColumn = max(startColumn, 0)
+	result.startRow = max(startRow, 0)
+
+	if !hasEnd {
+		// a single cell
+		result.endColumn = startColumn
+		result.endRow = startRow
+		return result, nil
+	}
+
+	endColumn, endRow, err := parseA1Cell(end)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse range: %s", rawRange) //nolint:stylecheck // the api message
+	}
+	result.endColumn = endColumn
+	result.endRow = endRow
+	return result, nil
+}
+
+// parseA1Cell parses "B3" into 1, 2; a missing part is -1: "B" is 1, -1.
+func parseA1Cell(cell string) (int, int, error) {
+	digitsStart := strings.IndexFunc(cell, func(r rune) bool { return r >= '0' && r <= '9' })
+	letters, digits := cell, ""
+	if digitsStart != -1 {
+		letters, digits = cell[:digitsStart], cell[digitsStart:]
+	}
+
+	column := -1
+	if letters != "" {
+		column = 0
+		for _, r := range strings.ToUpper(letters) {
+			if r < 'A' || r > 'Z' {
+				return 0, 0, fmt.Errorf("bad column %q", letters)
+			}
+			column = column*26 + int(r-'A') + 1
+		}
+		column--
+	}
+
+	row := -1
+	if digits != "" {
+		parsedRow, err := strconv.Atoi(digits)
+		if err != nil || parsedRow < 1 {
+			return 0, 0, fmt.Errorf("bad row %q", digits)
+		}
+		row = parsedRow - 1
+	}
+
+	if column == -1 && row == -1 {
+		return 0, 0, fmt.Errorf("bad cell %q", cell)
+	}
+	return column, row, nil
+}
+
+func columnName(column int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name
+}
+
+func writeCells(sh *sheet, startRow int, startColumn int, values [][]interface{}, userEntered bool) {
+	for rowOffset, row := range values {
+		rowIdx := startRow + rowOffset
+		for len(sh.rows) <= rowIdx {
+			sh.rows = append(sh.rows, nil)
+		}
+		for columnOffset, value := range row {
+			columnIdx := startColumn + columnOffset
+			for len(sh.rows[rowIdx]) <= columnIdx {
+				sh.rows[rowIdx] = append(sh.rows[rowIdx], nil)
+			}
+			sh.rows[rowIdx][columnIdx] = convertInputValue(value, userEntered)
+		}
+	}
+}
+
+// convertInputValue stores the value like sheets does: with USER_ENTERED numeric strings become numbers.
+func convertInputValue(value interface{}, userEntered bool) interface{} {
+	str, ok := value.(string)
+	if !ok {
+		return value
+	}
+	if str == "" {
+		return nil
+	}
+	if userEntered {
+		if number, err := strconv.ParseFloat(str, 64); err == nil {
+			return number
+		}
+	}
+	return str
+}
+
+func convertExtendedValue(value *sheets.ExtendedValue) interface{} {
+	switch {
+	case value == nil:
+		return nil
+	case value.NumberValue != nil:
+		return *value.NumberValue
+	case value.BoolValue != nil:
+		return *value.BoolValue
+	case value.StringValue != nil:
+		return *value.StringValue
+	}
+	return nil
+}
+
+func clearCells(sh *sheet, a1 *a1Range) {
+	for rowIdx := a1.startRow; rowIdx < len(sh.rows) && (a1.endRow == -1 || rowIdx <= a1.endRow); rowIdx++ {
+		row := sh.rows[rowIdx]
+		for columnIdx := a1.startColumn; columnIdx < len(row) && (a1.endColumn == -1 || columnIdx <= a1.endColumn); columnIdx++ {
+			row[columnIdx] = nil
+		}
+	}
+}
+
+// readCells returns the values in the range like the api: trailing empty rows and cells are dropped.
+func readCells(sh *sheet, a1 *a1Range, formatted bool) [][]interface{} {
+	var result [][]interface{}
+	for rowIdx := a1.startRow; rowIdx < len(sh.rows) && (a1.endRow == -1 || rowIdx <= a1.endRow); rowIdx++ {
+		row := sh.rows[rowIdx]
+		resultRow := []interface{}{}
+		for columnIdx := a1.startColumn; columnIdx < len(row) && (a1.endColumn == -1 || columnIdx <= a1.endColumn); columnIdx++ {
+			resultRow = append(resultRow, convertOutputValue(row[columnIdx], formatted))
+		}
+		for len(resultRow) > 0 && resultRow[len(resultRow)-1] == "" {
+			resultRow = resultRow[:len(resultRow)-1]
+		}
+		result = append(result, resultRow)
+	}
+	for len(result) > 0 && len(result[len(result)-1]) == 0 {
+		result = result[:len(result)-1]
+	}
+	return result
+}
+
+func convertOutputValue(value interface{}, formatted bool) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		if formatted {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return v
+	case bool:
+		if formatted {
+			return strings.ToUpper(strconv.FormatBool(v))
+		}
+		return v
+	}
+	return value
+}
+
+func isEmptyRow(row []interface{}) bool {
+	for _, value := range row {
+		if value != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func maxRowLen(rows [][]interface{}) int {
+	result := 0
+	for _, row := range rows {
+		result = max(result, len(row))
+	}
+	return result
+}
+
+func transpose(columns [][]interface{}) [][]interface{} {
+	var rows [][]interface{}
+	for columnIdx, column := range columns {
+		for rowIdx, value := range column {
+			for len(rows) <= rowIdx {
+				rows = append(rows, nil)
+			}
+			for len(rows[rowIdx]) <= columnIdx {
+				rows[rowIdx] = append(rows[rowIdx], "")
+			}
+			rows[rowIdx][columnIdx] = value
+		}
+	}
+	return rows
+}
@@ -0,0 +1,523 @@
+// Package gsheetfake is an in-memory fake of the Sheets v4 endpoints used by gsheetclient, for hermetic tests.
+package gsheetfake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// Failure is a response the server gives instead of handling a request.
+type Failure struct {
+	StatusCode int
+	RetryAfter time.Duration // 0 - no Retry-After header
+}
+
+type sheet struct {
+	properties *sheets.SheetProperties
+	rows       [][]interface{}
+}
+
+// Server keeps sheets of one spreadsheet in memory and serves the values and batchUpdate endpoints.
+type Server struct {
+	spreadsheetID string
+	httpServer    *httptest.Server
+
+	mu          sync.Mutex
+	sheets      []*sheet
+	nextSheetID int64
+	failures    []Failure
+	requests    map[string]int
+	updates     []*sheets.Request
+}
+
+func New(spreadsheetID string) *Server {
+	s := &Server{
+		spreadsheetID: spreadsheetID,
+		httpServer:    nil,
+		mu:            sync.Mutex{},
+		sheets:        nil,
+		nextSheetID:   1,
+		failures:      nil,
+		requests:      make(map[string]int),
+		updates:       nil,
+	}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL is the endpoint for gsheetclient.Config.
+func (s *Server) URL() string {
+	return s.httpServer.URL + "/"
+}
+
+// Client is the http client for gsheetclient.Config.
+func (s *Server) Client() *http.Client {
+	return s.httpServer.Client()
+}
+
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// AddSheet creates an empty sheet, it does nothing if the sheet exists.
+func (s *Server) AddSheet(title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addSheetLocked(title)
+}
+
+// SetRows replaces the content of the sheet, rows[0] is row 1, the sheet is created if needed.
+func (s *Server) SetRows(title string, rows [][]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh := s.addSheetLocked(title)
+	sh.rows = nil
+	writeCells(sh, 0, 0, rows, true)
+}
+
+// Rows returns the content of the sheet as stored, nil cells are empty.
+func (s *Server) Rows(title string) [][]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh := s.findSheetLocked(title)
+	if sh == nil {
+		return nil
+	}
+	rows := make([][]interface{}, len(sh.rows))
+	for i, row := range sh.rows {
+		rows[i] = append([]interface{}(nil), row...)
+	}
+	return rows
+}
+
+// SheetProperties returns the properties of the sheet, nil if there is no such sheet.
+func (s *Server) SheetProperties(title string) *sheets.SheetProperties {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh := s.findSheetLocked(title)
+	if sh == nil {
+		return nil
+	}
+	properties := *sh.properties
+	return &properties
+}
+
+// SeedFromBatchGetResponse loads values from a values:batchGetByDataFilter response,
+// like msg_examples/gsheets_response.json.
+func (s *Server) SeedFromBatchGetResponse(data []byte) error {
+	var response sheets.BatchGetValuesByDataFilterResponse
+	err := json.Unmarshal(data, &response)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, matched := range response.ValueRanges {
+		if matched.ValueRange == nil {
+			continue
+		}
+		a1, err := parseA1Range(matched.ValueRange.Range)
+		if err != nil {
+			return err
+		}
+		rows := matched.ValueRange.Values
+		if matched.ValueRange.MajorDimension == "COLUMNS" {
+			rows = transpose(rows)
+		}
+		writeCells(s.addSheetLocked(a1.sheetTitle), a1.startRow, a1.startColumn, rows, true)
+	}
+	return nil
+}
+
+// FailNext makes the next requests fail, one failure per request.
+func (s *Server) FailNext(failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failures...)
+}
+
+// RequestCount returns how many requests came to the method, e.g. "values.append", "values.batchUpdate".
+func (s *Server) RequestCount(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method]
+}
+
+// BatchUpdateRequests returns all requests that came in spreadsheets.batchUpdate calls.
+func (s *Server) BatchUpdateRequests() []*sheets.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*sheets.Request(nil), s.updates...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.Path, "/v4/spreadsheets/")
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+	spreadsheetID, rest, _ := strings.Cut(path, "/")
+	spreadsheetID, action, _ := strings.Cut(spreadsheetID, ":")
+	if spreadsheetID != s.spreadsheetID {
+		writeError(w, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+
+	method, handler := s.route(r.Method, action, rest)
+	if handler == nil {
+		writeError(w, http.StatusNotFound, "unknown method "+r.Method+" "+r.URL.Path)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[method]++
+	if len(s.failures) > 0 {
+		failure := s.failures[0]
+		s.failures = s.failures[1:]
+		if failure.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(failure.RetryAfter.Seconds())))
+		}
+		writeError(w, failure.StatusCode, "injected failure")
+		return
+	}
+
+	response, status, err := handler(r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+type handlerFunc func(r *http.Request) (interface{}, int, error)
+
+func (s *Server) route(httpMethod string, action string, rest string) (string, handlerFunc) {
+	switch {
+	case rest == "" && action == "" && httpMethod == http.MethodGet:
+		return "get", s.handleGet
+	case rest == "" && action == "batchUpdate" && httpMethod == http.MethodPost:
+		return "batchUpdate", s.handleBatchUpdate
+	case rest == "values:batchUpdate" && httpMethod == http.MethodPost:
+		return "values.batchUpdate", s.handleValuesBatchUpdate
+	case rest == "values:batchClear" && httpMethod == http.MethodPost:
+		return "values.batchClear", s.handleValuesBatchClear
+	}
+
+	a1Range, ok := strings.CutPrefix(rest, "values/")
+	if !ok {
+		return "", nil
+	}
+	if a1Range, ok = strings.CutSuffix(a1Range, ":append"); ok && httpMethod == http.MethodPost {
+		return "values.append", func(r *http.Request) (interface{}, int, error) { return s.handleValuesAppend(r, a1Range) }
+	}
+	if a1Range, ok = strings.CutSuffix(a1Range, ":clear"); ok && httpMethod == http.MethodPost {
+		return "values.clear", func(_ *http.Request) (interface{}, int, error) { return s.handleValuesClear(a1Range) }
+	}
+	switch httpMethod {
+	case http.MethodGet:
+		return "values.get", func(r *http.Request) (interface{}, int, error) { return s.handleValuesGet(r, a1Range) }
+	case http.MethodPut:
+		return "values.update", func(r *http.Request) (interface{}, int, error) { return s.handleValuesUpdate(r, a1Range) }
+	}
+	return "", nil
+}
+
+func (s *Server) handleGet(_ *http.Request) (interface{}, int, error) {
+	spreadsheet := &sheets.Spreadsheet{ //nolint:exhaustruct // only what the client reads
+		SpreadsheetId: s.spreadsheetID,
+	}
+	for _, sh := range s.sheets {
+		s.refreshGridPropertiesLocked(sh)
+		properties := *sh.properties
+		spreadsheet.Sheets = append(spreadsheet.Sheets, &sheets.Sheet{ //nolint:exhaustruct // only what the client reads
+			Properties: &properties,
+		})
+	}
+	return spreadsheet, http.StatusOK, nil
+}
+
+func (s *Server) handleValuesGet(r *http.Request, rawRange string) (interface{}, int, error) {
+	a1, sh, err := s.resolveRangeLocked(rawRange)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	formatted := r.URL.Query().Get("valueRenderOption") != "UNFORMATTED_VALUE"
+	return &sheets.ValueRange{ //nolint:exhaustruct // ok way to use the lib
+		MajorDimension: "ROWS",
+		Range:          rawRange,
+		Values:         readCells(sh, a1, formatted),
+	}, http.StatusOK, nil
+}
+
+func (s *Server) handleValuesUpdate(r *http.Request, rawRange string) (interface{}, int, error) {
+	var valueRange sheets.ValueRange
+	if err := json.NewDecoder(r.Body).Decode(&valueRange); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	a1, sh, err := s.resolveRangeLocked(rawRange)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	writeCells(sh, a1.startRow, a1.startColumn, valueRange.Values, isUserEntered(r))
+	return &sheets.UpdateValuesResponse{ //nolint:exhaustruct // ok way to use the lib
+		SpreadsheetId: s.spreadsheetID,
+		UpdatedRange:  rawRange,
+		UpdatedRows:   int64(len(valueRange.Values)),
+	}, http.StatusOK, nil
+}
+
+func (s *Server) handleValuesAppend(r *http.Request, rawRange string) (interface{}, int, error) {
+	var valueRange sheets.ValueRange
+	if err := json.NewDecoder(r.Body).Decode(&valueRange); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	a1, sh, err := s.resolveRangeLocked(rawRange)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	// the table is everything from the range start, new rows go right after its last non empty row
+	appendRow := a1.startRow
+	for rowIdx := len(sh.rows) - 1; rowIdx >= a1.startRow; rowIdx-- {
+		if !isEmptyRow(sh.rows[rowIdx]) {
+			appendRow = rowIdx + 1
+			break
+		}
+	}
+	writeCells(sh, appendRow, a1.startColumn, valueRange.Values, isUserEntered(r))
+
+	updatedRange := fmt.Sprintf("%s!%s%d:%s%d", sh.properties.Title,
+		columnName(a1.startColumn), appendRow+1,
+		columnName(a1.startColumn+maxRowLen(valueRange.Values)-1), appendRow+len(valueRange.Values))
+	return &sheets.AppendValuesResponse{ //nolint:exhaustruct // ok way to use the lib
+		SpreadsheetId: s.spreadsheetID,
+		Updates: &sheets.UpdateValuesResponse{ //nolint:exhaustruct // ok way to use the lib
+			SpreadsheetId: s.spreadsheetID,
+			UpdatedRange:  updatedRange,
+			UpdatedRows:   int64(len(valueRange.Values)),
+		},
+	}, http.StatusOK, nil
+}
+
+func (s *Server) handleValuesClear(rawRange string) (interface{}, int, error) {
+	a1, sh, err := s.resolveRangeLocked(rawRange)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	clearCells(sh, a1)
+	return &sheets.ClearValuesResponse{ //nolint:exhaustruct // ok way to use the lib
+		SpreadsheetId: s.spreadsheetID,
+		ClearedRange:  rawRange,
+	}, http.StatusOK, nil
+}
+
+func (s *Server) handleValuesBatchUpdate(r *http.Request) (interface{}, int, error) {
+	var request sheets.BatchUpdateValuesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	for _, valueRange := range request.Data {
+		a1, sh, err := s.resolveRangeLocked(valueRange.Range)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		writeCells(sh, a1.startRow, a1.startColumn, valueRange.Values, request.ValueInputOption == "USER_ENTERED")
+	}
+	return &sheets.BatchUpdateValuesResponse{ //nolint:exhaustruct // ok way to use the lib
+		SpreadsheetId:    s.spreadsheetID,
+		TotalUpdatedRows: int64(len(request.Data)),
+	}, http.StatusOK, nil
+}
+
+func (s *Server) handleValuesBatchClear(r *http.Request) (interface{}, int, error) {
+	var request sheets.BatchClearValuesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	for _, rawRange := range request.Ranges {
+		a1, sh, err := s.resolveRangeLocked(rawRange)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		clearCells(sh, a1)
+	}
+	return &sheets.BatchClearValuesResponse{ //nolint:exhaustruct // ok way to use the lib
+		SpreadsheetId: s.spreadsheetID,
+		ClearedRanges: request.Ranges,
+	}, http.StatusOK, nil
+}
+
+func (s *Server) handleBatchUpdate(r *http.Request) (interface{}, int, error) {
+	var request sheets.BatchUpdateSpreadsheetRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	response := &sheets.BatchUpdateSpreadsheetResponse{ //nolint:exhaustruct // ok way to use the lib
+		SpreadsheetId: s.spreadsheetID,
+	}
+	for _, update := range request.Requests {
+		reply, err := s.applyUpdateLocked(update)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		response.Replies = append(response.Replies, reply)
+		s.updates = append(s.updates, update)
+	}
+	return response, http.StatusOK, nil
+}
+
+func (s *Server) applyUpdateLocked(update *sheets.Request) (*sheets.Response, error) {
+	reply := &sheets.Response{} //nolint:exhaustruct // an empty reply is what the api gives for most requests
+	switch {
+	case update.AddSheet != nil:
+		title := update.AddSheet.Properties.Title
+		if s.findSheetLocked(title) != nil {
+			return nil, fmt.Errorf("a sheet with the name %q already exists", title)
+		}
+		sh := s.addSheetLocked(title)
+		properties := *sh.properties
+		reply.AddSheet = &sheets.AddSheetResponse{Properties: &properties} //nolint:exhaustruct // ok way to use the lib
+	case update.UpdateSheetProperties != nil:
+		sh := s.findSheetByIDLocked(update.UpdateSheetProperties.Properties.SheetId)
+		if sh == nil {
+			return nil, errors.New("no sheet with the id")
+		}
+		if gridProperties := update.UpdateSheetProperties.Properties.GridProperties; gridProperties != nil {
+			sh.properties.GridProperties.FrozenRowCount = gridProperties.FrozenRowCount
+		}
+	case update.UpdateCells != nil:
+		return reply, s.applyUpdateCellsLocked(update.UpdateCells)
+	case update.RepeatCell != nil:
+		if s.findSheetByIDLocked(update.RepeatCell.Range.SheetId) == nil {
+			return nil, errors.New("no sheet with the id")
+		}
+	default:
+		return nil, errors.New("the fake doesn't support the request")
+	}
+	return reply, nil
+}
+
+// applyUpdateCellsLocked supports clearing values of a range and writing values from a cell.
+func (s *Server) applyUpdateCellsLocked(update *sheets.UpdateCellsRequest) error {
+	if update.Fields != "userEnteredValue" {
+		return errors.New("the fake supports only userEnteredValue")
+	}
+
+	if update.Range != nil {
+		sh := s.findSheetByIDLocked(update.Range.SheetId)
+		if sh == nil {
+			return errors.New("no sheet with the id")
+		}
+		a1 := &a1Range{sheetTitle: sh.properties.Title, startRow: int(update.Range.StartRowIndex), startColumn: int(update.Range.StartColumnIndex), endRow: -1, endColumn: -1}
+		if update.Range.EndRowIndex > 0 {
+			a1.endRow = int(update.Range.EndRowIndex) - 1
+		}
+		if update.Range.EndColumnIndex > 0 {
+			a1.endColumn = int(update.Range.EndColumnIndex) - 1
+		}
+		clearCells(sh, a1)
+		return nil
+	}
+
+	sh := s.findSheetByIDLocked(update.Start.SheetId)
+	if sh == nil {
+		return errors.New("no sheet with the id")
+	}
+	values := make([][]interface{}, 0, len(update.Rows))
+	for _, row := range update.Rows {
+		rowValues := make([]interface{}, 0, len(row.Values))
+		for _, cell := range row.Values {
+			rowValues = append(rowValues, convertExtendedValue(cell.UserEnteredValue))
+		}
+		values = append(values, rowValues)
+	}
+	writeCells(sh, int(update.Start.RowIndex), int(update.Start.ColumnIndex), values, false)
+	return nil
+}
+
+func (s *Server) addSheetLocked(title string) *sheet {
+	if sh := s.findSheetLocked(title); sh != nil {
+		return sh
+	}
+	sh := &sheet{
+		properties: &sheets.SheetProperties{ //nolint:exhaustruct // ok way to use the lib
+			SheetId: s.nextSheetID,
+			Title:   title,
+			Index:   int64(len(s.sheets)),
+			GridProperties: &sheets.GridProperties{ //nolint:exhaustruct // ok way to use the lib
+				RowCount:    1000, //nolint:gomnd // the default size of a new sheet
+				ColumnCount: 26,   //nolint:gomnd // the default size of a new sheet
+			},
+		},
+		rows: nil,
+	}
+	s.nextSheetID++
+	s.sheets = append(s.sheets, sh)
+	return sh
+}
+
+func (s *Server) refreshGridPropertiesLocked(sh *sheet) {
+	if rowCount := int64(len(sh.rows)); rowCount > sh.properties.GridProperties.RowCount {
+		sh.properties.GridProperties.RowCount = rowCount
+	}
+}
+
+func (s *Server) findSheetLocked(title string) *sheet {
+	for _, sh := range s.sheets {
+		if sh.properties.Title == title {
+			return sh
+		}
+	}
+	return nil
+}
+
+func (s *Server) findSheetByIDLocked(sheetID int64) *sheet {
+	for _, sh := range s.sheets {
+		if sh.properties.SheetId == sheetID {
+			return sh
+		}
+	}
+	return nil
+}
+
+func (s *Server) resolveRangeLocked(rawRange string) (*a1Range, *sheet, error) {
+	a1, err := parseA1Range(rawRange)
+	if err != nil {
+		return nil, nil, err
+	}
+	sh := s.findSheetLocked(a1.sheetTitle)
+	if sh == nil {
+		return nil, nil, fmt.Errorf("Unable to parse range: %s", rawRange) //nolint:stylecheck // the api message
+	}
+	return a1, sh, nil
+}
+
+func isUserEntered(r *http.Request) bool {
+	return r.URL.Query().Get("valueInputOption") == "USER_ENTERED"
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    statusCode,
+			"message": message,
+		},
+	})
+}
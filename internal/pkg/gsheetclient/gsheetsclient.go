@@ -2,118 +2,261 @@ package gsheetclient
 
 import (
 	"context"
-	"encoding/base64"
-	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"log/slog"
 
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
 type GSheetsClient struct {
 	config *Config
+	ctx    context.Context
 
 	service *sheets.Service
+	batcher *batcher
 }
 
 type Config struct {
-	AuthToken     string
-	SpreadsheetID string
+	CredentialsSource CredentialsSource // "" - CredentialsSourceToken
+	AuthToken         string            // base64 service account key for CredentialsSourceToken
+	CredentialsFile   string            // key file for CredentialsSourceFile, OAuth client file for CredentialsSourceOAuth
+	OAuthTokenFile    string            // where CredentialsSourceOAuth keeps the user's token
+	SpreadsheetID     string
+
+	RequestTimeout time.Duration // 0 - a request is limited only by the caller's context
+	Retry          RetryConfig
+
+	BatchWindow  time.Duration // 0 - every write is sent right away
+	BatchMaxSize int           // 0 - no limit, a batch is sent only when the window ends
+
+	Endpoint   string       // "" - the google endpoint, tests point it to a fake server
+	HTTPClient *http.Client // nil - authorized with AuthToken, if set it is used as is
 }
 
-func New(config *Config) (*GSheetsClient, error) {
-	credBytes, err := base64.StdEncoding.DecodeString(config.AuthToken)
-	if err != nil {
-		return nil, err
+// New connects to gsheets, ctx bounds the lifetime of the client: background batch sends use it.
+func New(ctx context.Context, config *Config) (*GSheetsClient, error) {
+	var clientOptions []option.ClientOption
+	if config.HTTPClient != nil {
+		clientOptions = append(clientOptions, option.WithHTTPClient(config.HTTPClient))
+	} else {
+		httpClient, err := newAuthorizedHTTPClient(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions = append(clientOptions, option.WithHTTPClient(httpClient))
+	}
+	if config.Endpoint != "" {
+		clientOptions = append(clientOptions, option.WithEndpoint(config.Endpoint))
 	}
 
-	// authenticate and get configuration
-	jwtConfig, err := google.JWTConfigFromJSON(credBytes, "https://www.googleapis.com/auth/spreadsheets")
+	// create new service using client
+	service, err := sheets.NewService(ctx, clientOptions...)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context.Background()
+	slog.Info("gsheets connected", slog.Any("service", service))
 
-	// create client with config and context
-	httpClient := jwtConfig.Client(ctx)
+	gsc := &GSheetsClient{config: config, ctx: ctx, service: service, batcher: nil}
+	if config.BatchWindow > 0 {
+		gsc.batcher = newBatcher(gsc, config.BatchWindow, config.BatchMaxSize)
+	}
+	return gsc, nil
+}
 
-	// create new service using client
-	service, err := sheets.NewService(ctx, option.WithHTTPClient(httpClient))
-	if err != nil {
-		return nil, err
+// Flush sends the queued batched writes right away.
+func (gsc *GSheetsClient) Flush(ctx context.Context) {
+	if gsc.batcher != nil {
+		gsc.batcher.flush(ctx)
 	}
+}
 
-	slog.Info("gsheets connected", slog.Any("jwtConfig", jwtConfig), slog.Any("service", service))
+func (gsc *GSheetsClient) AppendDataToRange(ctx context.Context, appendRange *A1Range, dataRow []interface{}) error {
+	if gsc.batcher != nil {
+		return gsc.batcher.submit(ctx, &batchOp{ //nolint:exhaustruct // result is set by the batcher
+			kind:     batchOpAppend,
+			opRange:  appendRange,
+			dataRows: [][]interface{}{dataRow},
+		})
+	}
+	return gsc.appendDataRowsToRange(ctx, appendRange, [][]interface{}{dataRow})
+}
 
-	gsc := &GSheetsClient{config: config, service: service}
-	return gsc, nil
+func (gsc *GSheetsClient) UpdateDataRange(ctx context.Context, updateRange *A1Range, dataRow []interface{}) error {
+	if gsc.batcher != nil {
+		return gsc.batcher.submit(ctx, &batchOp{ //nolint:exhaustruct // result is set by the batcher
+			kind:     batchOpUpdate,
+			opRange:  updateRange,
+			dataRows: [][]interface{}{dataRow},
+		})
+	}
+	return gsc.updateDataRange(ctx, updateRange, [][]interface{}{dataRow})
+}
+
+func (gsc *GSheetsClient) ClearRange(ctx context.Context, deleteRange *A1Range) error {
+	if gsc.batcher != nil {
+		return gsc.batcher.submit(ctx, &batchOp{ //nolint:exhaustruct // result is set by the batcher
+			kind:     batchOpClear,
+			opRange:  deleteRange,
+			dataRows: nil,
+		})
+	}
+	return gsc.clearRange(ctx, deleteRange)
 }
 
-func (gsc *GSheetsClient) AppendDataToRange(appendRange *A1Range, dataRow []interface{}) error {
-	row := &sheets.ValueRange{ //nolint:exhaustruct // ok way to use the lib
-		Values: [][]interface{}{dataRow},
+// ClearRanges clears the ranges in one request, it doesn't wait for the batch window.
+func (gsc *GSheetsClient) ClearRanges(ctx context.Context, deleteRanges []*A1Range) error {
+	if len(deleteRanges) == 0 {
+		return nil
 	}
+	request := &sheets.BatchClearValuesRequest{Ranges: make([]string, 0, len(deleteRanges))}
+	for _, deleteRange := range deleteRanges {
+		request.Ranges = append(request.Ranges, deleteRange.String())
+	}
+
+	var response *sheets.BatchClearValuesResponse
+	err := gsc.withRetry(ctx, "batchClear", func(ctx context.Context) error {
+		ctx, cancel := gsc.withRequestTimeout(ctx)
+		defer cancel()
 
-	response, err := gsc.service.Spreadsheets.Values.
-		Append(gsc.config.SpreadsheetID, appendRange.String(), row).
-		ValueInputOption("USER_ENTERED").InsertDataOption("INSERT_ROWS").
-		Do()
-	if err = parseGSheetAPIError(err, response.HTTPStatusCode); err != nil {
+		var err error
+		response, err = gsc.service.Spreadsheets.Values.BatchClear(gsc.config.SpreadsheetID, request).Context(ctx).Do()
+		if err != nil {
+			return classifyGSheetAPIError(err)
+		}
+		return checkGSheetAPIStatus(response.HTTPStatusCode)
+	})
+	if err != nil {
+		slog.Error("Batch clear data in gseets failed", slog.Any("err", err), slog.Int("ranges", len(deleteRanges)))
+		return err
+	}
+	return nil
+}
+
+func (gsc *GSheetsClient) appendDataRowsToRange(ctx context.Context, appendRange *A1Range, dataRows [][]interface{}) error {
+	rows := &sheets.ValueRange{ //nolint:exhaustruct // ok way to use the lib
+		Values: dataRows,
+	}
+
+	var response *sheets.AppendValuesResponse
+	// an append repeated after a timeout or a 5xx may write the rows twice
+	err := gsc.withRetryOnRejection(ctx, "append", func(ctx context.Context) error {
+		ctx, cancel := gsc.withRequestTimeout(ctx)
+		defer cancel()
+
+		var err error
+		response, err = gsc.service.Spreadsheets.Values.
+			Append(gsc.config.SpreadsheetID, appendRange.String(), rows).
+			ValueInputOption("USER_ENTERED").InsertDataOption("INSERT_ROWS").
+			Context(ctx).Do()
+		if err != nil {
+			return classifyGSheetAPIError(err)
+		}
+		return checkGSheetAPIStatus(response.HTTPStatusCode)
+	})
+	if err != nil {
 		slog.Error(
 			"Append data to gseets failed",
 			slog.Any("err", err),
 			slog.Any("response", response),
-			slog.Any("dataRow", dataRow),
+			slog.Any("dataRows", dataRows),
 		)
 		return err
 	}
 	return nil
 }
 
-func (gsc *GSheetsClient) UpdateDataRange(updateRange *A1Range, dataRow []interface{}) error {
-	row := &sheets.ValueRange{ //nolint:exhaustruct // ok way to use the lib
-		Values: [][]interface{}{dataRow},
+func (gsc *GSheetsClient) updateDataRange(ctx context.Context, updateRange *A1Range, dataRows [][]interface{}) error {
+	rows := &sheets.ValueRange{ //nolint:exhaustruct // ok way to use the lib
+		Values: dataRows,
 	}
 
-	response, err := gsc.service.Spreadsheets.Values.
-		Update(gsc.config.SpreadsheetID, updateRange.String(), row).
-		ValueInputOption("USER_ENTERED").
-		Do()
-	if err = parseGSheetAPIError(err, response.HTTPStatusCode); err != nil {
+	var response *sheets.UpdateValuesResponse
+	err := gsc.withRetry(ctx, "update", func(ctx context.Context) error {
+		ctx, cancel := gsc.withRequestTimeout(ctx)
+		defer cancel()
+
+		var err error
+		response, err = gsc.service.Spreadsheets.Values.
+			Update(gsc.config.SpreadsheetID, updateRange.String(), rows).
+			ValueInputOption("USER_ENTERED").
+			Context(ctx).Do()
+		if err != nil {
+			return classifyGSheetAPIError(err)
+		}
+		return checkGSheetAPIStatus(response.HTTPStatusCode)
+	})
+	if err != nil {
 		slog.Error(
 			"Update data to gseets failed",
-			slog.Any("err", err), slog.Any("response", response), slog.Any("dataRow", dataRow), slog.Any("updateRange", updateRange))
+			slog.Any("err", err), slog.Any("response", response), slog.Any("dataRows", dataRows), slog.Any("updateRange", updateRange))
+		return err
+	}
+	return nil
+}
+
+func (gsc *GSheetsClient) batchUpdateValues(ctx context.Context, data []*sheets.ValueRange) error {
+	request := &sheets.BatchUpdateValuesRequest{ //nolint:exhaustruct // ok way to use the lib
+		Data:             data,
+		ValueInputOption: "USER_ENTERED",
+	}
+
+	var response *sheets.BatchUpdateValuesResponse
+	err := gsc.withRetry(ctx, "batchUpdate", func(ctx context.Context) error {
+		ctx, cancel := gsc.withRequestTimeout(ctx)
+		defer cancel()
+
+		var err error
+		response, err = gsc.service.Spreadsheets.Values.BatchUpdate(gsc.config.SpreadsheetID, request).Context(ctx).Do()
+		if err != nil {
+			return classifyGSheetAPIError(err)
+		}
+		return checkGSheetAPIStatus(response.HTTPStatusCode)
+	})
+	if err != nil {
+		slog.Error("Batch update data in gseets failed", slog.Any("err", err), slog.Any("response", response), slog.Int("ranges", len(data)))
 		return err
 	}
 	return nil
 }
 
-func (gsc *GSheetsClient) ClearRange(deleteRange *A1Range) error {
-	response, err := gsc.service.Spreadsheets.Values.Clear(gsc.config.SpreadsheetID, deleteRange.String(), &sheets.ClearValuesRequest{}).Do()
-	if err = parseGSheetAPIError(err, response.HTTPStatusCode); err != nil {
+func (gsc *GSheetsClient) clearRange(ctx context.Context, deleteRange *A1Range) error {
+	var response *sheets.ClearValuesResponse
+	err := gsc.withRetry(ctx, "clear", func(ctx context.Context) error {
+		ctx, cancel := gsc.withRequestTimeout(ctx)
+		defer cancel()
+
+		var err error
+		response, err = gsc.service.Spreadsheets.Values.
+			Clear(gsc.config.SpreadsheetID, deleteRange.String(), &sheets.ClearValuesRequest{}).
+			Context(ctx).Do()
+		if err != nil {
+			return classifyGSheetAPIError(err)
+		}
+		return checkGSheetAPIStatus(response.HTTPStatusCode)
+	})
+	if err != nil {
 		slog.Error("Clear data in gseets failed", slog.Any("err", err), slog.Any("response", response), slog.Any("deleteRange", deleteRange))
 		return err
 	}
 	return nil
 }
 
-func (gsc *GSheetsClient) FindValueLocation(searchRange *A1Range, searchValue string) (*A1Location, error) {
-	response, err := gsc.service.Spreadsheets.Values.Get(gsc.config.SpreadsheetID, searchRange.String()).Do()
-	if err = parseGSheetAPIError(err, response.HTTPStatusCode); err != nil {
-		slog.Error("Find data in gseets failed", slog.Any("err", err), slog.Any("response", response), slog.Any("searchRange", searchRange))
+func (gsc *GSheetsClient) FindValueLocation(ctx context.Context, searchRange *A1Range, searchValue string) (*A1Location, error) {
+	values, err := gsc.ReadRange(ctx, searchRange)
+	if err != nil {
 		return nil, err
 	}
 
 	searchValueColumnIdx := -1
 	searchValueRowIdx := -1
 
-	for rowIdx, row := range response.Values {
+	for rowIdx, row := range values {
 		for columnIdx, valueRaw := range row {
 			if value, ok := valueRaw.(string); ok && value == searchValue {
 				searchValueColumnIdx = columnIdx
@@ -139,14 +282,11 @@ func (gsc *GSheetsClient) FindValueLocation(searchRange *A1Range, searchValue st
 	}, nil
 }
 
-func parseGSheetAPIError(err error, httpStatusCode int) error {
-	if err != nil {
-		return err
+func (gsc *GSheetsClient) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if gsc.config.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	if httpStatusCode != http.StatusOK {
-		return fmt.Errorf("gsheet connection error: %d", httpStatusCode)
-	}
-	return nil
+	return context.WithTimeout(ctx, gsc.config.RequestTimeout)
 }
 
 type A1Location struct {
@@ -168,6 +308,16 @@ func (l *A1Location) String() string {
 	return result
 }
 
+// size returns the number of rows and columns in the range, ok is false for an open range.
+func (r *A1Range) size() (int, int, bool) {
+	if r.LeftTop == nil || r.RightBottom == nil || r.LeftTop.Row == 0 || r.RightBottom.Row == 0 {
+		return 0, 0, false
+	}
+	rows := r.RightBottom.Row - r.LeftTop.Row + 1
+	columns := toIntAlphabetic(r.RightBottom.Column) - toIntAlphabetic(r.LeftTop.Column) + 1
+	return rows, columns, true
+}
+
 func (r *A1Range) String() string {
 	result := r.SheetID
 	if r.LeftTop != nil {
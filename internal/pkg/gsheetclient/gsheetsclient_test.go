@@ -0,0 +1,99 @@
+package gsheetclient_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient"
+	"github.com/mitrkos/telemoney/internal/pkg/gsheetclient/gsheetfake"
+)
+
+const testSpreadsheetID = "test-spreadsheet"
+
+func newTestClient(t *testing.T, server *gsheetfake.Server, batchWindow time.Duration) *gsheetclient.GSheetsClient {
+	gsc, err := gsheetclient.New(context.Background(), &gsheetclient.Config{
+		CredentialsSource: "",
+		AuthToken:         "",
+		CredentialsFile:   "",
+		OAuthTokenFile:    "",
+		SpreadsheetID:     testSpreadsheetID,
+		RequestTimeout:    time.Second,
+		Retry:             gsheetclient.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond},
+		BatchWindow:       batchWindow,
+		BatchMaxSize:      0,
+		Endpoint:          server.URL(),
+		HTTPClient:        server.Client(),
+	})
+	require.NoError(t, err)
+	return gsc
+}
+
+func makeRowRange(row int) *gsheetclient.A1Range {
+	return &gsheetclient.A1Range{
+		SheetID:     "sheet",
+		LeftTop:     &gsheetclient.A1Location{Column: "A", Row: row},
+		RightBottom: &gsheetclient.A1Location{Column: "B", Row: row},
+	}
+}
+
+func TestGSheetsClient_RetriesRetryableErrors(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	server.AddSheet("sheet")
+	gsc := newTestClient(t, server, 0)
+
+	server.FailNext(
+		gsheetfake.Failure{StatusCode: http.StatusTooManyRequests, RetryAfter: 0},
+		gsheetfake.Failure{StatusCode: http.StatusServiceUnavailable, RetryAfter: 0},
+	)
+	err := gsc.UpdateDataRange(context.Background(), makeRowRange(1), []interface{}{"a", "b"})
+	require.NoError(t, err)
+	require.Equal(t, 3, server.RequestCount("values.update"))
+	require.Equal(t, [][]interface{}{{"a", "b"}}, server.Rows("sheet"))
+}
+
+func TestGSheetsClient_RetriesAppendOnlyWhenRejected(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	server.AddSheet("sheet")
+	gsc := newTestClient(t, server, 0)
+
+	server.FailNext(gsheetfake.Failure{StatusCode: http.StatusTooManyRequests, RetryAfter: 0})
+	err := gsc.AppendDataToRange(context.Background(), makeRowRange(1), []interface{}{"a", "b"})
+	require.NoError(t, err)
+	require.Equal(t, 2, server.RequestCount("values.append"))
+
+	// the append may be applied before a 5xx, so it is not repeated
+	server.FailNext(gsheetfake.Failure{StatusCode: http.StatusServiceUnavailable, RetryAfter: 0})
+	err = gsc.AppendDataToRange(context.Background(), makeRowRange(1), []interface{}{"c", "d"})
+	require.ErrorIs(t, err, gsheetclient.ErrRetryable)
+	require.Equal(t, 3, server.RequestCount("values.append"))
+	require.Equal(t, [][]interface{}{{"a", "b"}}, server.Rows("sheet"))
+}
+
+func TestGSheetsClient_ClassifiesErrors(t *testing.T) {
+	server := gsheetfake.New(testSpreadsheetID)
+	defer server.Close()
+	server.AddSheet("sheet")
+	gsc := newTestClient(t, server, 0)
+
+	server.FailNext(gsheetfake.Failure{StatusCode: http.StatusForbidden, RetryAfter: 0})
+	err := gsc.ClearRange(context.Background(), makeRowRange(1))
+	require.ErrorIs(t, err, gsheetclient.ErrPermanent)
+	require.NotErrorIs(t, err, gsheetclient.ErrRetryable)
+	require.Equal(t, 1, server.RequestCount("values.clear"))
+
+	server.FailNext(
+		gsheetfake.Failure{StatusCode: http.StatusTooManyRequests, RetryAfter: 0},
+		gsheetfake.Failure{StatusCode: http.StatusTooManyRequests, RetryAfter: 0},
+		gsheetfake.Failure{StatusCode: http.StatusTooManyRequests, RetryAfter: 0},
+	)
+	err = gsc.ClearRange(context.Background(), makeRowRange(1))
+	require.ErrorIs(t, err, gsheetclient.ErrRetryable)
+	require.ErrorIs(t, err, gsheetclient.ErrQuotaExceeded)
+	require.Equal(t, 4, server.RequestCount("values.clear"))
+}
@@ -0,0 +1,86 @@
+package gsheetclient
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+type RetryConfig struct {
+	MaxAttempts    int           // 0, 1 - no retries
+	InitialBackoff time.Duration // delay cap of the first retry, it doubles with every next one
+	MaxBackoff     time.Duration // 0 - no cap
+}
+
+// withRetry calls the request until it succeeds, fails permanently, runs out of attempts or ctx is done.
+// Delays use full jitter, a Retry-After from the server wins if it is longer.
+// Only the idempotent requests go here, see withRetryOnRejection for the other ones.
+func (gsc *GSheetsClient) withRetry(ctx context.Context, operation string, request func(ctx context.Context) error) error {
+	return gsc.retry(ctx, operation, isRetryable, request)
+}
+
+// withRetryOnRejection retries the request only when the server surely didn't apply it: on a rate limit.
+// After a timeout or a 5xx the request may be applied already, so a request writing twice when repeated,
+// like an append, fails and the caller checks what is written.
+func (gsc *GSheetsClient) withRetryOnRejection(ctx context.Context, operation string, request func(ctx context.Context) error) error {
+	return gsc.retry(ctx, operation, isRejected, request)
+}
+
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrRetryable)
+}
+
+func isRejected(err error) bool {
+	return errors.Is(err, ErrQuotaExceeded)
+}
+
+func (gsc *GSheetsClient) retry(
+	ctx context.Context,
+	operation string,
+	shouldRetry func(error) bool,
+	request func(ctx context.Context) error,
+) error {
+	retryConfig := gsc.config.Retry
+
+	for attempt := 1; ; attempt++ {
+		err := request(ctx)
+		if err == nil || !shouldRetry(err) || attempt >= retryConfig.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := retryConfig.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+
+		slog.Warn(
+			"gsheets request failed, retrying",
+			slog.String("operation", operation),
+			slog.Int("attempt", attempt),
+			slog.Duration("delay", delay),
+			slog.Any("err", err),
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (rc *RetryConfig) backoff(attempt int) time.Duration {
+	backoffCap := rc.InitialBackoff << (attempt - 1)
+	if backoffCap <= 0 || (rc.MaxBackoff > 0 && backoffCap > rc.MaxBackoff) {
+		backoffCap = rc.MaxBackoff
+	}
+	if backoffCap <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoffCap))) //nolint:gosec // jitter doesn't need crypto rand
+}
@@ -0,0 +1,236 @@
+package gsheetclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+type SheetProperties struct {
+	SheetID        int64 // the numeric id batchUpdate requests use
+	Title          string
+	FrozenRowCount int64
+	RowCount       int64 // the rows of the grid, the empty ones too
+}
+
+// GetSheets returns the sheets (tabs) of the spreadsheet.
+func (gsc *GSheetsClient) GetSheets(ctx context.Context) ([]*SheetProperties, error) {
+	var response *sheets.Spreadsheet
+	err := gsc.withRetry(ctx, "getSpreadsheet", func(ctx context.Context) error {
+		ctx, cancel := gsc.withRequestTimeout(ctx)
+		defer cancel()
+
+		var err error
+		response, err = gsc.service.Spreadsheets.Get(gsc.config.SpreadsheetID).
+			Fields("sheets.properties").
+			Context(ctx).Do()
+		if err != nil {
+			return classifyGSheetAPIError(err)
+		}
+		return checkGSheetAPIStatus(response.HTTPStatusCode)
+	})
+	if err != nil {
+		slog.Error("Get sheets from gseets failed", slog.Any("err", err))
+		return nil, err
+	}
+
+	result := make([]*SheetProperties, 0, len(response.Sheets))
+	for _, sheet := range response.Sheets {
+		result = append(result, convertSheetProperties(sheet.Properties))
+	}
+	return result, nil
+}
+
+// AddSheet creates a sheet (tab) with the title.
+func (gsc *GSheetsClient) AddSheet(ctx context.Context, title string) (*SheetProperties, error) {
+	replies, err := gsc.BatchUpdate(ctx, []*sheets.Request{{ //nolint:exhaustruct // ok way to use the lib
+		AddSheet: &sheets.AddSheetRequest{ //nolint:exhaustruct // ok way to use the lib
+			Properties: &sheets.SheetProperties{Title: title}, //nolint:exhaustruct // ok way to use the lib
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return convertSheetProperties(replies[0].AddSheet.Properties), nil
+}
+
+// EnsureSheet returns the sheet (tab) with the title, it is created if missing.
+func (gsc *GSheetsClient) EnsureSheet(ctx context.Context, title string) (*SheetProperties, error) {
+	sheetList, err := gsc.GetSheets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sheet := range sheetList {
+		if sheet.Title == title {
+			return sheet, nil
+		}
+	}
+	return gsc.AddSheet(ctx, title)
+}
+
+// BatchUpdate sends spreadsheet level requests (formatting, sheets, ...) in one call, the replies go in the same order.
+func (gsc *GSheetsClient) BatchUpdate(ctx context.Context, requests []*sheets.Request) ([]*sheets.Response, error) {
+	request := &sheets.BatchUpdateSpreadsheetRequest{ //nolint:exhaustruct // ok way to use the lib
+		Requests: requests,
+	}
+
+	var response *sheets.BatchUpdateSpreadsheetResponse
+	err := gsc.withRetry(ctx, "batchUpdateSpreadsheet", func(ctx context.Context) error {
+		ctx, cancel := gsc.withRequestTimeout(ctx)
+		defer cancel()
+
+		var err error
+		response, err = gsc.service.Spreadsheets.BatchUpdate(gsc.config.SpreadsheetID, request).Context(ctx).Do()
+		if err != nil {
+			return classifyGSheetAPIError(err)
+		}
+		return checkGSheetAPIStatus(response.HTTPStatusCode)
+	})
+	if err != nil {
+		slog.Error("Batch update of gseets failed", slog.Any("err", err), slog.Int("requests", len(requests)))
+		return nil, err
+	}
+	return response.Replies, nil
+}
+
+// ReadRange returns the values of the range as they are shown, trailing empty rows and cells are not included.
+func (gsc *GSheetsClient) ReadRange(ctx context.Context, readRange *A1Range) ([][]interface{}, error) {
+	return gsc.readRange(ctx, readRange, "FORMATTED_VALUE")
+}
+
+// ReadRangeUnformatted is ReadRange with numbers as float64 and strings as they are, not affected by cell formats.
+func (gsc *GSheetsClient) ReadRangeUnformatted(ctx context.Context, readRange *A1Range) ([][]interface{}, error) {
+	return gsc.readRange(ctx, readRange, "UNFORMATTED_VALUE")
+}
+
+func (gsc *GSheetsClient) readRange(ctx context.Context, readRange *A1Range, valueRenderOption string) ([][]interface{}, error) {
+	// pending writes have to land first, otherwise the read doesn't see them
+	gsc.Flush(ctx)
+
+	var response *sheets.ValueRange
+	err := gsc.withRetry(ctx, "get", func(ctx context.Context) error {
+		ctx, cancel := gsc.withRequestTimeout(ctx)
+		defer cancel()
+
+		var err error
+		response, err = gsc.service.Spreadsheets.Values.Get(gsc.config.SpreadsheetID, readRange.String()).Context(ctx).Do()
+		if err != nil {
+			return classifyGSheetAPIError(err)
+		}
+		return checkGSheetAPIStatus(response.HTTPStatusCode)
+	})
+	if err != nil {
+		slog.Error("Read data from gseets failed", slog.Any("err", err), slog.Any("readRange", readRange))
+		return nil, err
+	}
+	return response.Values, nil
+}
+
+// MakeFreezeRowsRequest makes a request keeping the top rows of the sheet in place while scrolling.
+func MakeFreezeRowsRequest(sheetID int64, rows int64) *sheets.Request {
+	return &sheets.Request{ //nolint:exhaustruct // ok way to use the lib
+		UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+			Properties: &sheets.SheetProperties{ //nolint:exhaustruct // ok way to use the lib
+				SheetId: sheetID,
+				GridProperties: &sheets.GridProperties{ //nolint:exhaustruct // ok way to use the lib
+					FrozenRowCount: rows,
+				},
+			},
+			Fields: "gridProperties.frozenRowCount",
+		},
+	}
+}
+
+// MakeColumnNumberFormatRequest makes a request setting the number format of a column starting from the row (1, 2, ...).
+// formatType is a sheets NumberFormat type: NUMBER, CURRENCY, DATE, DATE_TIME, TEXT, ...
+func MakeColumnNumberFormatRequest(sheetID int64, column string, fromRow int, formatType string, pattern string) *sheets.Request {
+	columnIdx := int64(toIntAlphabetic(column) - 1)
+	return &sheets.Request{ //nolint:exhaustruct // ok way to use the lib
+		RepeatCell: &sheets.RepeatCellRequest{
+			Range: &sheets.GridRange{ //nolint:exhaustruct // the end row is open
+				SheetId:          sheetID,
+				StartRowIndex:    int64(fromRow - 1),
+				StartColumnIndex: columnIdx,
+				EndColumnIndex:   columnIdx + 1,
+			},
+			Cell: &sheets.CellData{ //nolint:exhaustruct // ok way to use the lib
+				UserEnteredFormat: &sheets.CellFormat{ //nolint:exhaustruct // ok way to use the lib
+					NumberFormat: &sheets.NumberFormat{Type: formatType, Pattern: pattern},
+				},
+			},
+			Fields: "userEnteredFormat.numberFormat",
+		},
+	}
+}
+
+// MakeClearSheetRequest makes a request removing all values of the sheet, the formatting stays.
+func MakeClearSheetRequest(sheetID int64) *sheets.Request {
+	return &sheets.Request{ //nolint:exhaustruct // ok way to use the lib
+		UpdateCells: &sheets.UpdateCellsRequest{ //nolint:exhaustruct // ok way to use the lib
+			Range:  &sheets.GridRange{SheetId: sheetID}, //nolint:exhaustruct // the whole sheet
+			Fields: "userEnteredValue",
+		},
+	}
+}
+
+// MakeWriteRowsRequest makes a request writing the rows from the cell (row and column are 1, 2, ...).
+// Values are string, float64, int, int64 or bool, nil leaves the cell empty.
+func MakeWriteRowsRequest(sheetID int64, fromRow int, fromColumn string, rows [][]interface{}) *sheets.Request {
+	rowData := make([]*sheets.RowData, 0, len(rows))
+	for _, row := range rows {
+		cells := make([]*sheets.CellData, 0, len(row))
+		for _, value := range row {
+			cells = append(cells, &sheets.CellData{UserEnteredValue: makeExtendedValue(value)}) //nolint:exhaustruct // ok way to use the lib
+		}
+		rowData = append(rowData, &sheets.RowData{Values: cells}) //nolint:exhaustruct // ok way to use the lib
+	}
+
+	return &sheets.Request{ //nolint:exhaustruct // ok way to use the lib
+		UpdateCells: &sheets.UpdateCellsRequest{ //nolint:exhaustruct // ok way to use the lib
+			Start: &sheets.GridCoordinate{
+				SheetId:     sheetID,
+				RowIndex:    int64(fromRow - 1),
+				ColumnIndex: int64(toIntAlphabetic(fromColumn) - 1),
+			},
+			Rows:   rowData,
+			Fields: "userEnteredValue",
+		},
+	}
+}
+
+func makeExtendedValue(value interface{}) *sheets.ExtendedValue {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case float64:
+		return &sheets.ExtendedValue{NumberValue: &v} //nolint:exhaustruct // ok way to use the lib
+	case int:
+		number := float64(v)
+		return &sheets.ExtendedValue{NumberValue: &number} //nolint:exhaustruct // ok way to use the lib
+	case int64:
+		number := float64(v)
+		return &sheets.ExtendedValue{NumberValue: &number} //nolint:exhaustruct // ok way to use the lib
+	case bool:
+		return &sheets.ExtendedValue{BoolValue: &v} //nolint:exhaustruct // ok way to use the lib
+	case string:
+		return &sheets.ExtendedValue{StringValue: &v} //nolint:exhaustruct // ok way to use the lib
+	}
+	str := fmt.Sprint(value)
+	return &sheets.ExtendedValue{StringValue: &str} //nolint:exhaustruct // ok way to use the lib
+}
+
+func convertSheetProperties(properties *sheets.SheetProperties) *SheetProperties {
+	result := &SheetProperties{
+		SheetID:        properties.SheetId,
+		Title:          properties.Title,
+		FrozenRowCount: 0,
+		RowCount:       0,
+	}
+	if properties.GridProperties != nil {
+		result.FrozenRowCount = properties.GridProperties.FrozenRowCount
+		result.RowCount = properties.GridProperties.RowCount
+	}
+	return result
+}
@@ -20,6 +20,16 @@ type TransactionUserInputData struct {
 	Comment  *string
 }
 
+// Grammar is the input New parses, for showing to the users.
+const Grammar = "<amount> <category> [(tag, tag)] [comment]"
+
+// Examples are the inputs matching Grammar, from the shortest one.
+var Examples = []string{
+	"9.5 lunch",
+	"12,30 taxi (work, airport)",
+	"9,5 lunch (grenka, dumplings) I need food!",
+}
+
 func New() *Parser {
 	regexp := regroup.MustCompile(
 		`^(?P<amount>\d+[\.,]?\d*) (?P<category>\w+) ?(?:\((?P<tags>[\w, ]*)\))?(?P<comment>.*$)?`,
@@ -151,3 +151,12 @@ func TestParser_ParseTransactionUserInputDataFromTextValidationError(t *testing.
 		})
 	}
 }
+
+func TestParser_ExamplesAreParsed(t *testing.T) {
+	p := parser.New()
+
+	for _, example := range parser.Examples {
+		_, err := p.ParseTransactionUserInputDataFromText(example)
+		require.NoError(t, err, example)
+	}
+}
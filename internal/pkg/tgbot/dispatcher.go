@@ -0,0 +1,185 @@
+package tgbot
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mymmrac/telego"
+)
+
+type DispatcherConfig struct {
+	Workers       int           // updates handled at the same time, each of a different chat; 0 - defaultDispatcherWorkers
+	QueueSize     int           // updates received and not handled yet, receiving waits when it is full; 0 - defaultDispatcherQueueSize
+	StatsInterval time.Duration // how often the stats are logged, 0 - only on the stop
+}
+
+const (
+	defaultDispatcherWorkers   = 8
+	defaultDispatcherQueueSize = 100
+)
+
+type DispatcherStats struct {
+	Queued   int           // updates received and not handled yet, including the ones being handled
+	Busy     int           // workers handling an update right now
+	Handled  int64         // updates handled since the start
+	Dropped  int64         // updates not handled because of the stop
+	Waits    int64         // times receiving waited for the full queue
+	WaitTime time.Duration // total time receiving waited for the full queue
+}
+
+// dispatcher handles the updates of a chat one by one in the order they came, so an edit can't overtake
+// the message it edits, while different chats are handled in parallel by a bounded number of workers.
+type dispatcher struct {
+	handle func(ctx context.Context, update telego.Update)
+
+	workers chan struct{} // a token per busy worker
+	slots   chan struct{} // a token per queued update
+	pending sync.WaitGroup
+
+	mu    sync.Mutex
+	chats map[int64][]telego.Update // the chats with a runner, the updates waiting in them
+
+	handled  atomic.Int64
+	dropped  atomic.Int64
+	waits    atomic.Int64
+	waitTime atomic.Int64
+}
+
+func newDispatcher(config *DispatcherConfig, handle func(ctx context.Context, update telego.Update)) *dispatcher {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = defaultDispatcherWorkers
+	}
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultDispatcherQueueSize
+	}
+	return &dispatcher{
+		handle:   handle,
+		workers:  make(chan struct{}, workers),
+		slots:    make(chan struct{}, queueSize),
+		pending:  sync.WaitGroup{},
+		mu:       sync.Mutex{},
+		chats:    make(map[int64][]telego.Update),
+		handled:  atomic.Int64{},
+		dropped:  atomic.Int64{},
+		waits:    atomic.Int64{},
+		waitTime: atomic.Int64{},
+	}
+}
+
+// dispatch queues the update, waiting while the queue is full. The update is handled with ctx.
+// Returns false if ctx is done before the update is queued.
+func (d *dispatcher) dispatch(ctx context.Context, update telego.Update) bool {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		started := time.Now()
+		slog.Warn("tg updates queue is full, receiving waits", slog.Any("stats", d.stats()))
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			d.dropped.Add(1)
+			return false
+		}
+		d.waits.Add(1)
+		d.waitTime.Add(int64(time.Since(started)))
+	}
+	d.pending.Add(1)
+
+	chatID := updateChatID(update)
+	d.mu.Lock()
+	queue, running := d.chats[chatID]
+	d.chats[chatID] = append(queue, update)
+	d.mu.Unlock()
+	if !running {
+		go d.runChat(ctx, chatID)
+	}
+	return true
+}
+
+// runChat handles the updates of the chat until there are none, a worker is taken per update,
+// so a busy chat doesn't hold one for long.
+func (d *dispatcher) runChat(ctx context.Context, chatID int64) {
+	for {
+		d.mu.Lock()
+		queue := d.chats[chatID]
+		if len(queue) == 0 {
+			delete(d.chats, chatID)
+			d.mu.Unlock()
+			return
+		}
+		update := queue[0]
+		d.chats[chatID] = queue[1:]
+		d.mu.Unlock()
+
+		if ctx.Err() != nil {
+			d.dropped.Add(1)
+		} else {
+			d.workers <- struct{}{}
+			d.handle(ctx, update)
+			<-d.workers
+			d.handled.Add(1)
+		}
+		<-d.slots
+		d.pending.Done()
+	}
+}
+
+// wait waits until the queued updates are handled, false if timeout fires first.
+func (d *dispatcher) wait(timeout <-chan time.Time) bool {
+	idle := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+// logStats logs the stats every interval until ctx is done.
+func (d *dispatcher) logStats(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			slog.Info("tg updates dispatcher", slog.Any("stats", d.stats()))
+		}
+	}
+}
+
+func (d *dispatcher) stats() DispatcherStats {
+	return DispatcherStats{
+		Queued:   len(d.slots),
+		Busy:     len(d.workers),
+		Handled:  d.handled.Load(),
+		Dropped:  d.dropped.Load(),
+		Waits:    d.waits.Load(),
+		WaitTime: time.Duration(d.waitTime.Load()),
+	}
+}
+
+// updateChatID is the chat the update belongs to, 0 for the updates without one.
+func updateChatID(update telego.Update) int64 {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID
+	}
+	return 0
+}
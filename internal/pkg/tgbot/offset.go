@@ -0,0 +1,104 @@
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mitrkos/telemoney/internal/utils"
+)
+
+// offsetTracker follows the updates from receiving to handled and keeps the id of the last update handled
+// together with all the updates received before it, long polling resumes after it on the next start.
+// The handlers run in parallel, so an update handled early waits in the tracker for the ones before it.
+type offsetTracker struct {
+	path string // "" - not persisted
+
+	mu       sync.Mutex
+	received []*trackedUpdate // in the order they came, the handled ones at the front are dropped
+	last     int
+}
+
+type trackedUpdate struct {
+	id      int
+	handled bool
+}
+
+// newOffsetTracker reads the last handled update id from the file, a missing file is no updates handled yet.
+func newOffsetTracker(path string) (*offsetTracker, error) {
+	tracker := &offsetTracker{path: path, mu: sync.Mutex{}, received: nil, last: 0}
+	if path == "" {
+		return tracker, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tracker, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tracker.last, err = strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("bad update offset file %s: %w", path, err)
+	}
+	return tracker, nil
+}
+
+// lastHandled is the id of the last update handled with all before it, 0 if unknown.
+func (t *offsetTracker) lastHandled() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// receive tracks the update, false if it is tracked already, i.e. delivered again before it is handled.
+func (t *offsetTracker) receive(id int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, update := range t.received {
+		if update.id == id {
+			return false
+		}
+	}
+	t.received = append(t.received, &trackedUpdate{id: id, handled: false})
+	return true
+}
+
+// handle marks the update handled and saves the new last handled id if it moves.
+func (t *offsetTracker) handle(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, update := range t.received {
+		if update.id == id {
+			update.handled = true
+			break
+		}
+	}
+	last := t.last
+	for len(t.received) > 0 && t.received[0].handled {
+		last = t.received[0].id
+		t.received = t.received[1:]
+	}
+	if last == t.last {
+		return
+	}
+	t.last = last
+
+	if t.path == "" {
+		return
+	}
+	err := writeOffset(t.path, last)
+	if err != nil {
+		slog.Error("can't save the tg update offset", slog.Any("err", err), slog.Int("updateID", last))
+	}
+}
+
+func writeOffset(path string, id int) error {
+	return utils.WriteFileAtomic(path, []byte(strconv.Itoa(id)+"\n"))
+}
@@ -1,8 +1,14 @@
 package tgbot
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mymmrac/telego"
 	"github.com/mymmrac/telego/telegohandler"
@@ -21,120 +27,397 @@ type ReactionForMessage struct {
 type TgBot struct {
 	config *Config
 
-	bot *telego.Bot
+	bot      *telego.Bot
+	username string // of the bot, known once listening
+	userID   int64  // of the bot, known with the username
 
-	updateHandlerStartCommand         func()
-	updateHandlerRemoveMessageCommand func(msg *model.MessageToHandle)
-	updateHandlerMessage              func(msg *model.MessageToHandle)
-	updateHandlerEditedMessage        func(msg *model.MessageToHandle)
+	commandHandlers            map[string]func(ctx context.Context, command *model.CommandToHandle) // by the name
+	updateHandlerMessage       func(ctx context.Context, msg *model.MessageToHandle)
+	updateHandlerEditedMessage func(ctx context.Context, msg *model.MessageToHandle)
 }
 
+type UpdatesMode string
+
+const (
+	UpdatesModePolling UpdatesMode = "polling"
+	UpdatesModeWebhook UpdatesMode = "webhook"
+)
+
 type Config struct {
-	AuthToken string
+	AuthToken      string
+	APIServer      string      // "" - https://api.telegram.org
+	UpdatesMode    UpdatesMode // "" - UpdatesModePolling
+	PollingTimeout time.Duration
+	Webhook        WebhookConfig
+	Dispatcher     DispatcherConfig
+	DrainTimeout   time.Duration // how long the handlers in flight may take after the stop; 0 - defaultDrainTimeout
+	OffsetPath     string        // the file keeping the last handled update id, polling resumes after it; "" - not kept
+	// in the group chats only the messages starting with the bot mention or /add and the replies to the bot
+	// are handled, the others and the commands without a handler are ignored; the way to go when the bot's
+	// privacy mode is on, it doesn't get the other messages anyway
+	GroupPrefixRequired bool
 }
 
+type WebhookConfig struct {
+	ListenAddress string // the address the listener is on, e.g. ":8080"
+	Path          string // the path the updates are posted to, e.g. "/webhook"
+	URL           string // the public url telegram posts to, it has to end with Path when a proxy doesn't rewrite it
+	SecretToken   string // telegram sends it in every request, the others are rejected; "" - not checked
+	CertFile      string // "" - plain http, e.g. behind a reverse proxy doing https
+	KeyFile       string
+}
+
+const (
+	defaultPollingTimeout    = 30 * time.Second
+	defaultDrainTimeout      = 30 * time.Second
+	webhookReadHeaderTimeout = 10 * time.Second
+	webhookStopTimeout       = 10 * time.Second
+)
+
 func New(config *Config) (*TgBot, error) {
-	bot, err := telego.NewBot(config.AuthToken, telego.WithDefaultDebugLogger())
+	options := []telego.BotOption{telego.WithDefaultDebugLogger()}
+	if config.APIServer != "" {
+		options = append(options, telego.WithAPIServer(config.APIServer))
+	}
+	bot, err := telego.NewBot(config.AuthToken, options...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &TgBot{
-		config:                            config,
-		bot:                               bot,
-		updateHandlerStartCommand:         nil,
-		updateHandlerRemoveMessageCommand: nil,
-		updateHandlerMessage:              nil,
-		updateHandlerEditedMessage:        nil,
+		config:                     config,
+		bot:                        bot,
+		username:                   "",
+		userID:                     0,
+		commandHandlers:            make(map[string]func(ctx context.Context, command *model.CommandToHandle)),
+		updateHandlerMessage:       nil,
+		updateHandlerEditedMessage: nil,
 	}, nil
 }
 
-func (tg *TgBot) SetUpdateHandlerStartCommand(handler func()) {
-	tg.updateHandlerStartCommand = handler
-}
-
-func (tg *TgBot) SetUpdateHandlerRemoveMessageCommand(handler func(*model.MessageToHandle)) {
-	tg.updateHandlerRemoveMessageCommand = handler
+// SetUpdateHandlerCommand routes the command with the name, like "summary", to the handler.
+// The commands without a handler come to the message handler as the other texts.
+func (tg *TgBot) SetUpdateHandlerCommand(name string, handler func(context.Context, *model.CommandToHandle)) {
+	tg.commandHandlers[strings.ToLower(name)] = handler
 }
 
-func (tg *TgBot) SetUpdateHandlerMessage(handler func(*model.MessageToHandle)) {
+func (tg *TgBot) SetUpdateHandlerMessage(handler func(context.Context, *model.MessageToHandle)) {
 	tg.updateHandlerMessage = handler
 }
 
-func (tg *TgBot) SetUpdateHandlerEditedMessage(handler func(*model.MessageToHandle)) {
+func (tg *TgBot) SetUpdateHandlerEditedMessage(handler func(context.Context, *model.MessageToHandle)) {
 	tg.updateHandlerEditedMessage = handler
 }
 
-func (tg *TgBot) ListenToUpdates() error {
-	// Get updates channel
-	// (more on configuration in examples/updates_long_polling/main.go)
-	updates, err := tg.bot.UpdatesViaLongPolling(&telego.GetUpdatesParams{ //nolint:exhaustruct // ok way to use
-		Timeout: 30, //nolint:gomnd // timeout in sec
-	})
+// ListenToUpdates handles updates until ctx is done, then it stops receiving them and waits for the handlers
+// in flight up to Config.DrainTimeout. The handlers' context is cancelled only when the drain times out,
+// so the writes started before the stop are finished.
+// The updates come by long polling or to the webhook listener, see Config.UpdatesMode.
+// An update delivered again while the first delivery is in flight is skipped.
+func (tg *TgBot) ListenToUpdates(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	offsets, err := newOffsetTracker(tg.config.OffsetPath)
 	if err != nil {
+		slog.Error("can't read the tg update offset", slog.Any("err", err))
 		return err
 	}
 
-	handler, _ := telegohandler.NewBotHandler(tg.bot, updates)
+	// the commands for the other bots of a group are told by the username
+	me, err := tg.bot.GetMe()
+	if err != nil {
+		slog.Error("can't get the bot username", slog.Any("err", err))
+		return err
+	}
+	tg.username, tg.userID = me.Username, me.ID
+
+	var updates <-chan telego.Update
+	listenErrs := make(chan error, 1)
+	if tg.config.UpdatesMode == UpdatesModeWebhook {
+		updates, err = tg.updatesViaWebhook(ctx)
+		if err != nil {
+			return err
+		}
 
-	// Stop handling updates
-	defer handler.Stop()
-	// Stop reviving updates from update channel
-	defer tg.bot.StopLongPolling()
+		go func() {
+			// blocks until the webhook is stopped, a failure to listen stops the updates
+			err := tg.bot.StartWebhook(tg.config.Webhook.ListenAddress)
+			if err != nil {
+				slog.Error("webhook listener failed", slog.Any("err", err))
+				listenErrs <- err
+				cancel()
+			}
+		}()
+	} else {
+		// (more on configuration in examples/updates_long_polling/main.go)
+		params := &telego.GetUpdatesParams{ //nolint:exhaustruct // ok way to use
+			Timeout: tg.pollingTimeoutSeconds(),
+		}
+		// the updates up to the offset are confirmed to telegram, so the ones handled before a restart don't come again
+		if last := offsets.lastHandled(); last > 0 {
+			params.Offset = last + 1
+			slog.Info("resuming tg updates", slog.Int("offset", params.Offset))
+		}
+		updates, err = tg.bot.UpdatesViaLongPolling(params, telego.WithLongPollingContext(ctx))
+		if err != nil {
+			return err
+		}
+	}
 
-	handler.Handle(func(_ *telego.Bot, _ telego.Update) {
-		if tg.updateHandlerStartCommand == nil {
-			return
+	// the update contexts end with the polling or with the webhook request, the handlers outlive both
+	handlersCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+	dispatcher := newDispatcher(&tg.config.Dispatcher, func(ctx context.Context, update telego.Update) {
+		tg.handleUpdate(ctx, update)
+		// a handler cancelled by the stop may be not done, its update comes again after a restart
+		if ctx.Err() == nil {
+			offsets.handle(update.UpdateID)
 		}
+	})
+	go dispatcher.logStats(ctx, tg.config.Dispatcher.StatsInterval)
+	receivingDone := make(chan struct{})
+	go func() {
+		defer close(receivingDone)
+		// the webhook updates channel is closed right after ctx is done, the polling one only when the request
+		// in flight ends, its updates are not confirmed to telegram then, so they come again after a restart
+		untilClosed := tg.config.UpdatesMode == UpdatesModeWebhook
+		receiveUpdates(ctx, updates, untilClosed, func(update telego.Update) bool {
+			if !offsets.receive(update.UpdateID) {
+				slog.Info("skipping the tg update delivered again", slog.Int("updateID", update.UpdateID))
+				return true
+			}
+			return dispatcher.dispatch(handlersCtx, update)
+		})
+	}()
+	<-ctx.Done()
+
+	slog.Info("stopping receiving tg updates")
+	if tg.config.UpdatesMode == UpdatesModeWebhook {
+		tg.stopWebhook()
+	} else {
+		tg.bot.StopLongPolling()
+	}
+	tg.drain(receivingDone, dispatcher)
+	cancelHandlers()
+	<-receivingDone
+	slog.Info("tg updates are stopped", slog.Any("stats", dispatcher.stats()))
 
-		tg.updateHandlerStartCommand()
-	}, telegohandler.CommandEqual("start"))
+	select {
+	case err = <-listenErrs:
+		return err
+	default:
+		return nil
+	}
+}
 
-	handler.Handle(func(_ *telego.Bot, update telego.Update) {
-		if tg.updateHandlerRemoveMessageCommand == nil {
+// handleUpdate calls the handler of the first route matching the update.
+func (tg *TgBot) handleUpdate(ctx context.Context, update telego.Update) {
+	for _, route := range tg.routes() {
+		if route.predicate(update) {
+			route.handle(ctx, update)
 			return
 		}
+	}
+}
 
-		tg.updateHandlerRemoveMessageCommand(convertTGMessageToMessage(update.Message.ReplyToMessage))
-	}, telegohandler.CommandEqual("remove"))
+type route struct {
+	predicate telegohandler.Predicate
+	handle    func(ctx context.Context, update telego.Update)
+}
 
-	handler.Handle(func(_ *telego.Bot, update telego.Update) {
-		if tg.updateHandlerEditedMessage == nil {
-			return
-		}
-		if update.EditedMessage == nil {
-			return
+func (tg *TgBot) routes() []route {
+	return []route{
+		{tg.isHandledCommand, func(ctx context.Context, update telego.Update) {
+			command := convertTGMessageToCommand(update.Message)
+			tg.commandHandlers[command.Name](ctx, command)
+		}},
+		{telegohandler.AnyEditedMessageWithText(), func(ctx context.Context, update telego.Update) {
+			if tg.updateHandlerEditedMessage == nil {
+				return
+			}
+
+			msg, ok := tg.convertTGMessageForBot(update.EditedMessage)
+			if !ok {
+				return
+			}
+			tg.updateHandlerEditedMessage(ctx, msg)
+		}},
+		{telegohandler.AnyMessageWithText(), func(ctx context.Context, update telego.Update) {
+			if tg.updateHandlerMessage == nil {
+				return
+			}
+
+			msg, ok := tg.convertTGMessageForBot(update.Message)
+			if !ok {
+				return
+			}
+			tg.updateHandlerMessage(ctx, msg)
+		}},
+	}
+}
+
+// receiveUpdates dispatches the updates until ctx is done, then until updates is closed or, if not untilClosed,
+// only the ones buffered already. It stops when dispatch refuses an update.
+func receiveUpdates(ctx context.Context, updates <-chan telego.Update, untilClosed bool, dispatch func(telego.Update) bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				if untilClosed {
+					update, ok := <-updates
+					if !ok || !dispatch(update) {
+						return
+					}
+					continue
+				}
+				select {
+				case update, ok := <-updates:
+					if !ok || !dispatch(update) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case update, ok := <-updates:
+			if !ok || !dispatch(update) {
+				return
+			}
 		}
+	}
+}
 
-		tg.updateHandlerEditedMessage(convertTGMessageToMessage(update.EditedMessage))
-	}, telegohandler.AnyEditedMessageWithText())
+// drain waits for the updates received so far to be handled, up to Config.DrainTimeout.
+func (tg *TgBot) drain(receivingDone <-chan struct{}, dispatcher *dispatcher) {
+	timeout := tg.config.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
 
-	handler.Handle(func(_ *telego.Bot, update telego.Update) {
-		if tg.updateHandlerMessage == nil {
-			return
-		}
-		if update.Message == nil {
+	select {
+	case <-receivingDone:
+		if dispatcher.wait(deadline.C) {
+			slog.Info("tg update handlers are drained")
 			return
 		}
+	case <-deadline.C:
+	}
+	slog.Warn("tg update handlers are not done in time, cancelling them", slog.Duration("timeout", timeout))
+}
 
-		tg.updateHandlerMessage(convertTGMessageToMessage(update.Message))
-	}, telegohandler.AnyMessageWithText())
+func (tg *TgBot) pollingTimeoutSeconds() int {
+	if tg.config.PollingTimeout <= 0 {
+		return int(defaultPollingTimeout.Seconds())
+	}
+	return int(tg.config.PollingTimeout.Seconds())
+}
 
-	// Start handling updates
-	handler.Start()
+// updatesViaWebhook sets the webhook in telegram and registers the listener, the updates stop when ctx is done.
+func (tg *TgBot) updatesViaWebhook(ctx context.Context) (<-chan telego.Update, error) {
+	webhook := tg.config.Webhook
+	server := &http.Server{ //nolint:exhaustruct // the defaults are fine
+		ReadHeaderTimeout: webhookReadHeaderTimeout,
+	}
+	httpServer := telego.HTTPWebhookServer{
+		Logger:      tg.bot.Logger(),
+		Server:      server,
+		ServeMux:    http.NewServeMux(),
+		SecretToken: webhook.SecretToken,
+	}
+	webhookServer := telego.FuncWebhookServer{
+		Server: httpServer,
+		StartFunc: func(address string) error {
+			if webhook.CertFile == "" {
+				return httpServer.Start(address)
+			}
+			server.Addr = address
+			err := server.ListenAndServeTLS(webhook.CertFile, webhook.KeyFile)
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		},
+		StopFunc:            nil,
+		RegisterHandlerFunc: nil,
+	}
 
-	return nil
+	updates, err := tg.bot.UpdatesViaWebhook(webhook.Path,
+		telego.WithWebhookServer(webhookServer),
+		telego.WithWebhookContext(ctx),
+		telego.WithWebhookSet(&telego.SetWebhookParams{ //nolint:exhaustruct // the defaults are fine
+			URL:         webhook.URL,
+			SecretToken: webhook.SecretToken,
+		}),
+	)
+	if err != nil {
+		slog.Error("can't set the webhook", slog.Any("err", err))
+		return nil, err
+	}
+	slog.Info("webhook is set", slog.String("url", webhook.URL), slog.String("listen", webhook.ListenAddress))
+	return updates, nil
 }
 
-func (tg *TgBot) SendMessage(msg *model.MessageToSend) error {
+// stopWebhook stops the listener and deletes the webhook in telegram, so polling works again.
+func (tg *TgBot) stopWebhook() {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookStopTimeout)
+	defer cancel()
+
+	err := tg.bot.StopWebhookWithContext(ctx)
+	if err != nil {
+		slog.Error("can't stop the webhook listener", slog.Any("err", err))
+	}
+	err = tg.bot.DeleteWebhook(&telego.DeleteWebhookParams{DropPendingUpdates: false})
+	if err != nil {
+		slog.Error("can't delete the webhook", slog.Any("err", err))
+		return
+	}
+	slog.Info("webhook is deleted")
+}
+
+// SendMessage posts the message and returns its id.
+func (tg *TgBot) SendMessage(msg *model.MessageToSend) (string, error) {
 	tgChatID, err := convertChatIDToTGChatID(msg.ChatID)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	_, err = tg.bot.SendMessage(telegoutil.Messagef(telegoutil.ID(tgChatID), msg.Text))
+	sent, err := tg.bot.SendMessage(telegoutil.Message(telegoutil.ID(tgChatID), msg.Text))
 	if err != nil {
 		slog.Error("sending msg to tg failed", slog.Any("err", err), slog.Any("msg", msg))
+		return "", err
+	}
+
+	return strconv.Itoa(sent.MessageID), nil
+}
+
+// SetCommands replaces the command menu of the chats of the scope for the users with the language,
+// "" - for the users with a language without its own menu.
+func (tg *TgBot) SetCommands(scope model.CommandScope, languageCode string, commands []*model.Command) error {
+	var tgScope telego.BotCommandScope
+	switch scope {
+	case model.CommandScopePrivate:
+		tgScope = telegoutil.ScopeAllPrivateChats()
+	case model.CommandScopeGroup:
+		tgScope = telegoutil.ScopeAllGroupChats()
+	default:
+		return fmt.Errorf("unknown command scope %q", scope)
+	}
+	tgCommands := make([]telego.BotCommand, 0, len(commands))
+	for _, command := range commands {
+		tgCommands = append(tgCommands, telego.BotCommand{Command: command.Name, Description: command.Description})
+	}
+
+	err := tg.bot.SetMyCommands(&telego.SetMyCommandsParams{
+		Commands:     tgCommands,
+		Scope:        tgScope,
+		LanguageCode: languageCode,
+	})
+	if err != nil {
+		slog.Error("setting the command menu failed", slog.Any("err", err), slog.Any("scope", scope),
+			slog.String("languageCode", languageCode))
 		return err
 	}
 
@@ -194,12 +477,97 @@ func convertTGMessageToMessage(tgMsg *telego.Message) *model.MessageToHandle {
 		return nil
 	}
 
-	return &model.MessageToHandle{
+	msg := &model.MessageToHandle{
 		CreatedAt: tgMsg.Date,
 		MessageID: strconv.Itoa(tgMsg.MessageID),
 		ChatID:    strconv.FormatInt(tgMsg.Chat.ID, 10),
+		UserID:    "",
+		UserName:  "",
 		Text:      tgMsg.Text,
 	}
+	if tgMsg.From != nil {
+		msg.UserID, msg.UserName = strconv.FormatInt(tgMsg.From.ID, 10), convertTGUserToName(tgMsg.From)
+	}
+	return msg
+}
+
+// isHandledCommand tells if the update is a command with a handler.
+func (tg *TgBot) isHandledCommand(update telego.Update) bool {
+	if update.Message == nil {
+		return false
+	}
+	name, username, _ := parseCommand(update.Message.Text)
+	if !tg.isOwnUsername(username) {
+		return false
+	}
+	_, ok := tg.commandHandlers[name]
+	return ok
+}
+
+// parseCommand returns the lowercase command name, the bot username after "@" and the args, the args may be split
+// by new lines too: telegoutil.ParseCommand takes only a space after the command and one line of args,
+// so the text is joined first.
+func parseCommand(text string) (string, string, []string) {
+	name, username, args := telegoutil.ParseCommand(strings.Join(strings.Fields(text), " "))
+	return strings.ToLower(name), strings.TrimPrefix(username, "@"), args
+}
+
+// isOwnUsername tells if a command with the username, like "/help@telemoney_bot", is for this bot.
+// A command without one is for every bot of the chat.
+func (tg *TgBot) isOwnUsername(username string) bool {
+	// usernames are case insensitive
+	return username == "" || strings.EqualFold(username, tg.username)
+}
+
+// convertTGMessageToCommand converts the message with a command, the reply keeps its own sender.
+func convertTGMessageToCommand(tgMsg *telego.Message) *model.CommandToHandle {
+	name, _, args := parseCommand(tgMsg.Text)
+	return &model.CommandToHandle{
+		Name:    name,
+		Args:    args,
+		Message: convertTGMessageToMessage(tgMsg),
+		ReplyTo: convertTGMessageToMessage(tgMsg.ReplyToMessage),
+	}
+}
+
+// convertTGUserToName makes "@username" or the full name if there is no username.
+func convertTGUserToName(user *telego.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// convertTGMessageForBot converts the message with the text meant for the bot: without /add or the bot mention
+// in front. False if the message is not for the bot: a command for another bot, see also Config.GroupPrefixRequired.
+func (tg *TgBot) convertTGMessageForBot(tgMsg *telego.Message) (*model.MessageToHandle, bool) {
+	msg := convertTGMessageToMessage(tgMsg)
+	prefix, rest, _ := strings.Cut(msg.Text, " ")
+	if name, username, _ := parseCommand(prefix); name != "" && !tg.isOwnUsername(username) {
+		return nil, false
+	}
+	if prefix == "/add" || strings.HasPrefix(prefix, "/add@") {
+		msg.Text = strings.TrimSpace(rest)
+		return msg, true
+	}
+	if !tg.config.GroupPrefixRequired || (tgMsg.Chat.Type != telego.ChatTypeGroup && tgMsg.Chat.Type != telego.ChatTypeSupergroup) {
+		return msg, true
+	}
+
+	// the commands with a handler don't come here, the others are for another bot or unknown
+	if strings.HasPrefix(prefix, "/") {
+		return nil, false
+	}
+	// e.g. the answer to a question of the bot
+	if tgMsg.ReplyToMessage != nil && tgMsg.ReplyToMessage.From != nil && tgMsg.ReplyToMessage.From.ID == tg.userID {
+		return msg, true
+	}
+	// usernames are case insensitive
+	if tg.username == "" || !strings.EqualFold(prefix, "@"+tg.username) {
+		return nil, false
+	}
+	msg.Text = strings.TrimSpace(rest)
+	return msg, true
 }
 
 func convertChatIDToTGChatID(chatID string) (int64, error) {
@@ -233,3 +601,10 @@ func MakeReactionOkEmoji() TgMessageReaction {
 		Emoji: "👌",
 	}}
 }
+
+func MakeReactionWritingEmoji() TgMessageReaction {
+	return []telego.ReactionType{&telego.ReactionTypeEmoji{
+		Type:  telego.ReactionEmoji,
+		Emoji: "✍",
+	}}
+}
@@ -0,0 +1,424 @@
+package tgbot_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mitrkos/telemoney/internal/model"
+	"github.com/mitrkos/telemoney/internal/pkg/tgbot"
+	"github.com/mitrkos/telemoney/internal/pkg/tgbot/tgfake"
+)
+
+const testToken = "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+const testUpdate = `{"update_id": 1, "message": {"message_id": 42, "date": 1710000000, "chat": {"id": 7, "type": "private"},
+	"from": {"id": 8, "is_bot": false, "first_name": "user"}, "text": "9.5 lunch"}}`
+
+func pollingConfig(server *tgfake.Server) *tgbot.Config {
+	return &tgbot.Config{
+		AuthToken:      testToken,
+		APIServer:      server.URL(),
+		UpdatesMode:    tgbot.UpdatesModePolling,
+		PollingTimeout: time.Second,
+		Webhook:        tgbot.WebhookConfig{}, //nolint:exhaustruct // not used for polling
+		Dispatcher:     tgbot.DispatcherConfig{Workers: 0, QueueSize: 0, StatsInterval: 0},
+		DrainTimeout:   0,
+		OffsetPath:     "",
+		// the groups take every message
+		GroupPrefixRequired: false,
+	}
+}
+
+// makeMessageUpdate makes an update of the kind "message" or "edited_message".
+func makeMessageUpdate(kind string, chatID int64, messageID int, text string) map[string]interface{} {
+	return map[string]interface{}{kind: map[string]interface{}{
+		"message_id": messageID, "date": 1710000000, "chat": map[string]interface{}{"id": chatID, "type": "private"}, "text": text,
+	}}
+}
+
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return address
+}
+
+func TestTgBot_Webhook(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+	address := freeAddress(t)
+
+	bot, err := tgbot.New(&tgbot.Config{
+		AuthToken:      testToken,
+		APIServer:      server.URL(),
+		UpdatesMode:    tgbot.UpdatesModeWebhook,
+		PollingTimeout: 0,
+		Webhook: tgbot.WebhookConfig{
+			ListenAddress: address,
+			Path:          "/webhook",
+			URL:           "https://example.com/webhook",
+			SecretToken:   "secret",
+			CertFile:      "",
+			KeyFile:       "",
+		},
+		Dispatcher:   tgbot.DispatcherConfig{Workers: 0, QueueSize: 0, StatsInterval: 0},
+		DrainTimeout: 0,
+		OffsetPath:   "",
+	})
+	require.NoError(t, err)
+	messages := make(chan *model.MessageToHandle, 1)
+	bot.SetUpdateHandlerMessage(func(_ context.Context, msg *model.MessageToHandle) {
+		messages <- msg
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- bot.ListenToUpdates(ctx) }()
+
+	post := func(secret string) int {
+		request, err := http.NewRequest(http.MethodPost, "http://"+address+"/webhook", bytes.NewBufferString(testUpdate))
+		require.NoError(t, err)
+		request.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return 0
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+	require.Eventually(t, func() bool { return post("wrong") == http.StatusUnauthorized }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusOK, post("secret"))
+
+	select {
+	case msg := <-messages:
+		require.Equal(t, &model.MessageToHandle{CreatedAt: 1710000000, MessageID: "42", ChatID: "7", UserID: "8", UserName: "user", Text: "9.5 lunch"}, msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the update is not handled")
+	}
+
+	cancel()
+	require.NoError(t, <-listenErr)
+	setWebhook := server.Calls("setWebhook")
+	require.Len(t, setWebhook, 1)
+	require.Equal(t, "https://example.com/webhook", setWebhook[0]["url"])
+	require.Equal(t, "secret", setWebhook[0]["secret_token"])
+	require.Len(t, server.Calls("deleteWebhook"), 1)
+}
+
+func TestTgBot_Polling(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+
+	bot, err := tgbot.New(pollingConfig(server))
+	require.NoError(t, err)
+	messages := make(chan *model.MessageToHandle, 1)
+	bot.SetUpdateHandlerMessage(func(_ context.Context, msg *model.MessageToHandle) {
+		messages <- msg
+	})
+
+	server.AddUpdate(makeMessageUpdate("message", 7, 42, "9.5 lunch"))
+	ctx, cancel := context.WithCancel(context.Background())
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- bot.ListenToUpdates(ctx) }()
+
+	select {
+	case msg := <-messages:
+		require.Equal(t, "42", msg.MessageID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the update is not handled")
+	}
+	cancel()
+	require.NoError(t, <-listenErr)
+	require.Empty(t, server.Calls("setWebhook"))
+}
+
+func TestTgBot_GroupPrefixRequired(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+
+	config := pollingConfig(server)
+	config.GroupPrefixRequired = true
+	bot, err := tgbot.New(config)
+	require.NoError(t, err)
+	messages := make(chan *model.MessageToHandle, 6)
+	bot.SetUpdateHandlerMessage(func(_ context.Context, msg *model.MessageToHandle) {
+		messages <- msg
+	})
+
+	groupUpdate := func(messageID int, text string) map[string]interface{} {
+		update := makeMessageUpdate("message", -100, messageID, text)
+		update["message"].(map[string]interface{})["chat"] = map[string]interface{}{"id": -100, "type": "group"}
+		return update
+	}
+	server.AddUpdate(groupUpdate(41, "see you at lunch"))
+	server.AddUpdate(groupUpdate(42, "@Telemoney_bot 9.5 lunch"))
+	server.AddUpdate(groupUpdate(43, "/add@telemoney_bot 3 coffee"))
+	server.AddUpdate(makeMessageUpdate("message", 7, 44, "5 taxi"))
+	server.AddUpdate(groupUpdate(45, "/weather"))
+	server.AddUpdate(groupUpdate(46, "/skip@other_bot"))
+	answer := groupUpdate(47, "EUR")
+	question := makeMessageUpdate("message", -100, 40, "What currency do you use?")["message"].(map[string]interface{})
+	question["from"] = map[string]interface{}{"id": 1, "is_bot": true, "first_name": "telemoney", "username": "telemoney_bot"}
+	answer["message"].(map[string]interface{})["reply_to_message"] = question
+	server.AddUpdate(answer)
+	ctx, cancel := context.WithCancel(context.Background())
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- bot.ListenToUpdates(ctx) }()
+
+	texts := make(map[string]string)
+	for i := 0; i < 4; i++ {
+		select {
+		case msg := <-messages:
+			texts[msg.MessageID] = msg.Text
+		case <-time.After(5 * time.Second):
+			t.Fatal("the update is not handled")
+		}
+	}
+	cancel()
+	require.NoError(t, <-listenErr)
+	require.Equal(t, map[string]string{"42": "9.5 lunch", "43": "3 coffee", "44": "5 taxi", "47": "EUR"}, texts)
+	require.Empty(t, messages)
+}
+
+func TestTgBot_RoutesCommands(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+
+	bot, err := tgbot.New(pollingConfig(server))
+	require.NoError(t, err)
+	commands := make(chan *model.CommandToHandle, 3)
+	bot.SetUpdateHandlerCommand("remove", func(_ context.Context, command *model.CommandToHandle) {
+		commands <- command
+	})
+	messages := make(chan *model.MessageToHandle, 2)
+	bot.SetUpdateHandlerMessage(func(_ context.Context, msg *model.MessageToHandle) {
+		messages <- msg
+	})
+
+	reply := makeMessageUpdate("message", 7, 42, "/Remove@telemoney_bot now please")
+	reply["message"].(map[string]interface{})["reply_to_message"] = makeMessageUpdate("message", 7, 41, "9.5 lunch")["message"]
+	server.AddUpdate(reply)
+	server.AddUpdate(makeMessageUpdate("message", 7, 43, "/skip"))
+	server.AddUpdate(makeMessageUpdate("message", 7, 44, "/remove\nnow  please"))
+	ctx, cancel := context.WithCancel(context.Background())
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- bot.ListenToUpdates(ctx) }()
+
+	handled := make(map[string]*model.CommandToHandle)
+	for len(handled) < 2 {
+		select {
+		case command := <-commands:
+			handled[command.Message.MessageID] = command
+		case <-time.After(5 * time.Second):
+			t.Fatal("the command is not handled")
+		}
+	}
+	require.Equal(t, "remove", handled["42"].Name)
+	require.Equal(t, []string{"now", "please"}, handled["42"].Args)
+	require.Equal(t, "41", handled["42"].ReplyTo.MessageID)
+	// the args on the next line
+	require.Equal(t, "remove", handled["44"].Name)
+	require.Equal(t, []string{"now", "please"}, handled["44"].Args)
+	select {
+	case msg := <-messages:
+		require.Equal(t, "/skip", msg.Text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command without a handler is not handled as a message")
+	}
+	cancel()
+	require.NoError(t, <-listenErr)
+}
+
+func TestTgBot_SkipsCommandsForOtherBots(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+
+	bot, err := tgbot.New(pollingConfig(server))
+	require.NoError(t, err)
+	commands := make(chan *model.CommandToHandle, 3)
+	bot.SetUpdateHandlerCommand("remove", func(_ context.Context, command *model.CommandToHandle) {
+		commands <- command
+	})
+	messages := make(chan *model.MessageToHandle, 3)
+	bot.SetUpdateHandlerMessage(func(_ context.Context, msg *model.MessageToHandle) {
+		messages <- msg
+	})
+
+	server.AddUpdate(makeMessageUpdate("message", 7, 42, "/remove@otherbot"))
+	server.AddUpdate(makeMessageUpdate("message", 7, 43, "/add@otherbot 9.5 lunch"))
+	server.AddUpdate(makeMessageUpdate("message", 7, 44, "/remove@Telemoney_Bot"))
+	ctx, cancel := context.WithCancel(context.Background())
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- bot.ListenToUpdates(ctx) }()
+
+	// the updates of a chat are handled in order, the ones before are done
+	select {
+	case command := <-commands:
+		require.Equal(t, "44", command.Message.MessageID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command for the bot is not handled")
+	}
+	require.Empty(t, commands)
+	require.Empty(t, messages)
+	cancel()
+	require.NoError(t, <-listenErr)
+}
+
+func TestTgBot_SetCommands(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+
+	bot, err := tgbot.New(pollingConfig(server))
+	require.NoError(t, err)
+	err = bot.SetCommands(model.CommandScopeGroup, "ru", []*model.Command{{Name: "help", Description: "Помощь"}})
+	require.NoError(t, err)
+	require.Error(t, bot.SetCommands("channel", "", nil))
+
+	calls := server.Calls("setMyCommands")
+	require.Len(t, calls, 1)
+	require.Equal(t, map[string]interface{}{"type": "all_group_chats"}, calls[0]["scope"])
+	require.Equal(t, "ru", calls[0]["language_code"])
+	require.Equal(t, []interface{}{map[string]interface{}{"command": "help", "description": "Помощь"}}, calls[0]["commands"])
+}
+
+func TestTgBot_DrainsHandlersOnStop(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+
+	config := pollingConfig(server)
+	config.DrainTimeout = 5 * time.Second
+	bot, err := tgbot.New(config)
+	require.NoError(t, err)
+	started, release := make(chan struct{}), make(chan struct{})
+	handlerErr := make(chan error, 1)
+	bot.SetUpdateHandlerMessage(func(ctx context.Context, _ *model.MessageToHandle) {
+		close(started)
+		<-release
+		handlerErr <- ctx.Err()
+	})
+
+	server.AddUpdate(makeMessageUpdate("message", 7, 42, "9.5 lunch"))
+	ctx, cancel := context.WithCancel(context.Background())
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- bot.ListenToUpdates(ctx) }()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the update is not handled")
+	}
+	cancel()
+	select {
+	case <-listenErr:
+		t.Fatal("stopped before the handler is done")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-listenErr)
+	require.NoError(t, <-handlerErr, "the handler context is not cancelled by the stop")
+}
+
+func TestTgBot_OrdersUpdatesPerChat(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+	bot, err := tgbot.New(pollingConfig(server))
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var handled []string
+	otherChatStarted := make(chan struct{})
+	bot.SetUpdateHandlerMessage(func(_ context.Context, msg *model.MessageToHandle) {
+		if msg.ChatID == "8" {
+			close(otherChatStarted)
+			return
+		}
+		// the other chat is handled while this one waits, the edit of this chat waits for the insert
+		select {
+		case <-otherChatStarted:
+		case <-time.After(5 * time.Second):
+			t.Error("the other chat waits for this one")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, "message "+msg.Text)
+	})
+	done := make(chan struct{})
+	bot.SetUpdateHandlerEditedMessage(func(_ context.Context, msg *model.MessageToHandle) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, "edit "+msg.Text)
+		close(done)
+	})
+
+	server.AddUpdate(makeMessageUpdate("message", 7, 42, "9.5 lunch"))
+	server.AddUpdate(makeMessageUpdate("edited_message", 7, 42, "10 lunch"))
+	server.AddUpdate(makeMessageUpdate("message", 8, 43, "3 coffee"))
+	ctx, cancel := context.WithCancel(context.Background())
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- bot.ListenToUpdates(ctx) }()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the updates are not handled")
+	}
+	cancel()
+	require.NoError(t, <-listenErr)
+	require.Equal(t, []string{"message 9.5 lunch", "edit 10 lunch"}, handled)
+}
+
+func TestTgBot_ResumesFromHandledOffset(t *testing.T) {
+	server := tgfake.New(testToken)
+	t.Cleanup(server.Close)
+	config := pollingConfig(server)
+	config.OffsetPath = filepath.Join(t.TempDir(), "tg_offset")
+
+	listen := func(handle func(msg *model.MessageToHandle)) func() {
+		bot, err := tgbot.New(config)
+		require.NoError(t, err)
+		bot.SetUpdateHandlerMessage(func(_ context.Context, msg *model.MessageToHandle) { handle(msg) })
+		ctx, cancel := context.WithCancel(context.Background())
+		listenErr := make(chan error, 1)
+		go func() { listenErr <- bot.ListenToUpdates(ctx) }()
+		return func() {
+			cancel()
+			require.NoError(t, <-listenErr)
+		}
+	}
+
+	handled := make(chan string, 2)
+	stop := listen(func(msg *model.MessageToHandle) { handled <- msg.MessageID })
+	server.AddUpdate(makeMessageUpdate("message", 7, 42, "9.5 lunch"))
+	updateID := server.AddUpdate(makeMessageUpdate("message", 7, 43, "3 coffee"))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the update is not handled")
+		}
+	}
+	stop()
+
+	offset, err := os.ReadFile(config.OffsetPath)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d\n", updateID), string(offset))
+
+	calls := len(server.Calls("getUpdates"))
+	stop = listen(func(msg *model.MessageToHandle) { handled <- msg.MessageID })
+	require.Eventually(t, func() bool { return len(server.Calls("getUpdates")) > calls }, 5*time.Second, 10*time.Millisecond)
+	stop()
+	require.Equal(t, float64(updateID+1), server.Calls("getUpdates")[calls]["offset"])
+	require.Empty(t, handled)
+}
@@ -0,0 +1,136 @@
+// Package tgfake is an in-memory fake of the Telegram Bot API used by tgbot, for hermetic tests.
+package tgfake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// getUpdatesWait is how long getUpdates waits for an update before answering with none, the fake doesn't
+// hold the requests for the whole polling timeout.
+const getUpdatesWait = 50 * time.Millisecond
+
+// Server answers every method with ok, records the calls and serves the added updates to getUpdates.
+type Server struct {
+	token      string
+	httpServer *httptest.Server
+
+	mu            sync.Mutex
+	calls         map[string][]map[string]interface{}
+	updates       []map[string]interface{}
+	nextUpdateID  int64
+	nextMessageID int64
+}
+
+func New(token string) *Server {
+	s := &Server{
+		token:         token,
+		httpServer:    nil,
+		mu:            sync.Mutex{},
+		calls:         make(map[string][]map[string]interface{}),
+		updates:       nil,
+		nextUpdateID:  1,
+		nextMessageID: 1,
+	}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL is the api server for tgbot.Config.
+func (s *Server) URL() string {
+	return s.httpServer.URL
+}
+
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Calls returns the parameters of the calls of the method, from the oldest.
+func (s *Server) Calls(method string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.calls[method]...)
+}
+
+// AddUpdate queues the update for getUpdates, the update id is set by the server and returned.
+func (s *Server) AddUpdate(update map[string]interface{}) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update["update_id"] = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, update)
+	return update["update_id"].(int64)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + s.token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeResponse(w, http.StatusUnauthorized, map[string]interface{}{"ok": false, "error_code": 401, "description": "Unauthorized"})
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, prefix)
+
+	params := make(map[string]interface{})
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			writeResponse(w, http.StatusBadRequest, map[string]interface{}{"ok": false, "error_code": 400, "description": err.Error()})
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.calls[method] = append(s.calls[method], params)
+	s.mu.Unlock()
+
+	writeResponse(w, http.StatusOK, map[string]interface{}{"ok": true, "result": s.result(method, params)})
+}
+
+func (s *Server) result(method string, params map[string]interface{}) interface{} {
+	switch method {
+	case "getMe":
+		return map[string]interface{}{"id": 1, "is_bot": true, "first_name": "telemoney", "username": "telemoney_bot"}
+	case "getUpdates":
+		return s.takeUpdates(params)
+	case "sendMessage":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		messageID := s.nextMessageID
+		s.nextMessageID++
+		return map[string]interface{}{
+			"message_id": messageID,
+			"date":       time.Now().Unix(),
+			"chat":       map[string]interface{}{"id": params["chat_id"], "type": "private"},
+			"text":       params["text"],
+		}
+	}
+	return true
+}
+
+// takeUpdates returns the updates from the offset, the ones before it are confirmed and dropped.
+func (s *Server) takeUpdates(params map[string]interface{}) []map[string]interface{} {
+	offset, _ := params["offset"].(float64)
+	deadline := time.Now().Add(getUpdatesWait)
+	for {
+		s.mu.Lock()
+		for len(s.updates) > 0 && float64(s.updates[0]["update_id"].(int64)) < offset {
+			s.updates = s.updates[1:]
+		}
+		updates := append([]map[string]interface{}{}, s.updates...)
+		s.mu.Unlock()
+
+		if len(updates) > 0 || time.Now().After(deadline) {
+			return updates
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func writeResponse(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}